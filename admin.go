@@ -0,0 +1,344 @@
+package goworker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Queue is a queue with its number of jobs, as returned by
+// Queues.
+type Queue struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Paused bool   `json:"paused"`
+
+	// OldestEnqueuedAt is when the job at the head of the
+	// queue was enqueued, or nil if the queue is empty or
+	// the job was enqueued without its time, as by Resque.
+	OldestEnqueuedAt *time.Time `json:"oldest_enqueued_at"`
+}
+
+// Job is a job waiting in a queue, or run by a worker.
+type Job struct {
+	Class string        `json:"class"`
+	Args  []interface{} `json:"args"`
+	ID    string        `json:"id,omitempty"`
+
+	// EnqueuedAt is nil for the jobs enqueued without
+	// their time, as by Resque.
+	EnqueuedAt *time.Time `json:"enqueued_at,omitempty"`
+}
+
+// ScheduledJob is a delayed job waiting for its time, as
+// returned by Scheduled.
+type ScheduledJob struct {
+	Queue string    `json:"queue"`
+	At    time.Time `json:"at"`
+	Job
+}
+
+// Worker is a registered worker, as returned by Workers.
+type Worker struct {
+	Name      string `json:"name"`
+	Processed int    `json:"processed"`
+	Failed    int    `json:"failed"`
+
+	// Job is the job the worker is running, or nil if it
+	// is idle.
+	Job *WorkerJob `json:"job"`
+}
+
+// WorkerJob is the job run by a worker.
+type WorkerJob struct {
+	Queue string    `json:"queue"`
+	RunAt time.Time `json:"run_at"`
+	Job
+}
+
+// Info sums up the queues, workers and failures, like
+// Resque.info.
+type Info struct {
+	Pending   int `json:"pending"`
+	Processed int `json:"processed"`
+	Failed    int `json:"failed"`
+	Queues    int `json:"queues"`
+	Workers   int `json:"workers"`
+	Working   int `json:"working"`
+	Failures  int `json:"failures"`
+}
+
+var errorNoQueueAdmin = errors.New("The backend can not clear or pause queues.")
+
+func newJobFromPayload(p payload) Job {
+	job := Job{
+		Class: p.Class,
+		Args:  p.Args,
+		ID:    p.id(),
+	}
+	if p.EnqueuedAt > 0 {
+		enqueuedAt := time.Unix(0, int64(p.EnqueuedAt*float64(time.Second)))
+		job.EnqueuedAt = &enqueuedAt
+	}
+	return job
+}
+
+// Returns the delayed job of queue due at.
+func newScheduledJob(queue string, at time.Time, buffer []byte) (*ScheduledJob, error) {
+	job, err := decodeJob(queue, buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledJob{Queue: queue, At: at, Job: newJobFromPayload(job.Payload)}, nil
+}
+
+// Queues returns the known queues with their size, whether
+// they are paused and the time of their oldest job.
+func Queues() (queues []*Queue, err error) {
+	err = withBackend(func(b Backend) error {
+		names, err := b.Queues()
+		if err != nil {
+			return err
+		}
+		paused := make(map[string]bool)
+		if admin, ok := b.(QueueAdmin); ok {
+			pausedQueues, err := admin.PausedQueues()
+			if err != nil {
+				return err
+			}
+			for _, queue := range pausedQueues {
+				paused[queue] = true
+			}
+		}
+		for _, name := range names {
+			queue := &Queue{Name: name, Paused: paused[name]}
+			if queue.Size, err = b.QueueSize(name); err != nil {
+				return err
+			}
+			jobs, err := b.Peek(name, 0, 1)
+			if err != nil {
+				return err
+			}
+			if len(jobs) > 0 {
+				if job, err := decodeJob(name, jobs[0]); err == nil {
+					queue.OldestEnqueuedAt = newJobFromPayload(job.Payload).EnqueuedAt
+				}
+			}
+			queues = append(queues, queue)
+		}
+		return nil
+	})
+	return
+}
+
+// Peek returns up to limit jobs of queue from offset, in
+// the order they are run, without removing them. A limit
+// of zero returns every job from offset.
+func Peek(queue string, offset, limit int) (jobs []*Job, err error) {
+	err = withBackend(func(b Backend) error {
+		buffers, err := b.Peek(queue, offset, limit)
+		if err != nil {
+			return err
+		}
+		for _, buffer := range buffers {
+			job, err := decodeJob(queue, buffer)
+			if err != nil {
+				return err
+			}
+			j := newJobFromPayload(job.Payload)
+			jobs = append(jobs, &j)
+		}
+		return nil
+	})
+	return
+}
+
+// ClearQueue removes the jobs waiting in queue and returns
+// their number.
+func ClearQueue(queue string) (cleared int, err error) {
+	err = withQueueAdmin(func(admin QueueAdmin) error {
+		cleared, err = admin.ClearQueue(queue)
+		return err
+	})
+	return
+}
+
+// PauseQueue stops the workers from running the jobs of
+// queue, which are kept, until ResumeQueue is called.
+func PauseQueue(queue string) error {
+	return withQueueAdmin(func(admin QueueAdmin) error {
+		return admin.PauseQueue(queue)
+	})
+}
+
+// ResumeQueue lets the workers run the jobs of queue again
+// after PauseQueue.
+func ResumeQueue(queue string) error {
+	return withQueueAdmin(func(admin QueueAdmin) error {
+		return admin.ResumeQueue(queue)
+	})
+}
+
+func withQueueAdmin(fn func(admin QueueAdmin) error) error {
+	return withBackend(func(b Backend) error {
+		admin, ok := b.(QueueAdmin)
+		if !ok {
+			return errorNoQueueAdmin
+		}
+		return fn(admin)
+	})
+}
+
+// Scheduled returns up to limit delayed jobs from offset,
+// the next due first, for the backends which store delayed
+// jobs. A limit of zero returns every job from offset.
+func Scheduled(offset, limit int) (jobs []*ScheduledJob, err error) {
+	err = withBackend(func(b Backend) error {
+		if reader, ok := b.(ScheduleReader); ok {
+			jobs, err = reader.Scheduled(offset, limit)
+		}
+		return err
+	})
+	return
+}
+
+// Workers returns the registered workers, with their
+// stats and the job they are running.
+func Workers() (workers []*Worker, err error) {
+	err = withBackend(func(b Backend) error {
+		names, err := b.Workers()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			worker, err := readWorker(b, name)
+			if err != nil {
+				return err
+			}
+			workers = append(workers, worker)
+		}
+		return nil
+	})
+	return
+}
+
+func readWorker(b Backend, name string) (*Worker, error) {
+	worker := &Worker{Name: name}
+
+	var err error
+	if worker.Processed, err = b.Stat("processed:" + name); err != nil {
+		return nil, err
+	}
+	if worker.Failed, err = b.Stat("failed:" + name); err != nil {
+		return nil, err
+	}
+
+	buffer, err := b.WorkerJob(name)
+	if err != nil || buffer == nil {
+		return worker, err
+	}
+	// The jobs of other programs, like Sidekiq, may not be
+	// readable as goworker ones.
+	var w work
+	if err := json.Unmarshal(buffer, &w); err == nil {
+		worker.Job = &WorkerJob{
+			Queue: w.Queue,
+			RunAt: w.RunAt,
+			Job:   newJobFromPayload(w.Payload),
+		}
+	}
+	return worker, nil
+}
+
+// ReadInfo returns the number of jobs, queues, workers and
+// failures.
+func ReadInfo() (info *Info, err error) {
+	err = withBackend(func(b Backend) error {
+		info = &Info{}
+
+		queues, err := b.Queues()
+		if err != nil {
+			return err
+		}
+		info.Queues = len(queues)
+		for _, queue := range queues {
+			size, err := b.QueueSize(queue)
+			if err != nil {
+				return err
+			}
+			info.Pending += size
+		}
+
+		workers, err := b.Workers()
+		if err != nil {
+			return err
+		}
+		info.Workers = len(workers)
+		for _, worker := range workers {
+			job, err := b.WorkerJob(worker)
+			if err != nil {
+				return err
+			}
+			if job != nil {
+				info.Working++
+			}
+		}
+
+		if info.Processed, err = b.Stat("processed"); err != nil {
+			return err
+		}
+		if info.Failed, err = b.Stat("failed"); err != nil {
+			return err
+		}
+		info.Failures, err = b.FailureCount()
+		return err
+	})
+	return
+}
+
+// PruneWorkers unregisters the workers of this host whose
+// process is gone, as after a crash, like the
+// prune_dead_workers of Resque, and returns them. Workers
+// of other hosts are left alone.
+func PruneWorkers() (pruned []string, err error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	err = withBackend(func(b Backend) error {
+		workers, err := b.Workers()
+		if err != nil {
+			return err
+		}
+		for _, worker := range workers {
+			host, pid, ok := parseWorker(worker)
+			if !ok || host != hostname || pid == os.Getpid() || processAlive(pid) {
+				continue
+			}
+			if err := b.UnregisterWorker(worker); err != nil {
+				return err
+			}
+			pruned = append(pruned, worker)
+		}
+		return nil
+	})
+	return
+}
+
+// Returns the host and pid of a worker named
+// host:pid-id:queues.
+func parseWorker(worker string) (host string, pid int, ok bool) {
+	parts := strings.SplitN(worker, ":", 3)
+	if len(parts) < 3 {
+		return "", 0, false
+	}
+	pid, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], pid, true
+}
@@ -0,0 +1,288 @@
+package goworker
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AdminHandler returns a handler serving a web dashboard
+// like resque-web, and the JSON API it uses, for the
+// backend of Work and Enqueue. Mount it on a path ending
+// with a slash, without the path:
+//
+//	http.Handle("/goworker/", http.StripPrefix("/goworker", goworker.AdminHandler(requireAdmin)))
+//
+// The API is served under api/:
+//
+//	GET    api/info                  the stats, as returned by ReadInfo
+//	GET    api/queues                the queues, as returned by Queues
+//	GET    api/queues/<name>         the jobs of a queue, with offset and limit
+//	DELETE api/queues/<name>         clears a queue
+//	POST   api/queues/<name>/pause   pauses a queue
+//	POST   api/queues/<name>/resume  resumes a queue
+//	GET    api/workers               the workers, as returned by Workers
+//	GET    api/failures              the failures, with offset, limit and the
+//	                                 class, queue and exception filters
+//	DELETE api/failures              removes every failure
+//	POST   api/failures/retry        retries the failures matching the filters
+//	POST   api/failures/<id>/retry
+//	DELETE api/failures/<id>         retries or removes a failure, with the
+//	                                 offset it was listed at and the filters
+//	GET    api/scheduled             the delayed jobs, with offset and limit
+//	GET    api/rate-limits           the rate limits, as returned by RateLimits
+//
+// The requests other than GET must have the
+// X-Requested-With header, which other sites cannot set
+// without being allowed by CORS, so that they cannot make
+// the browser of an admin send them.
+//
+// The handler does not authenticate its requests: every
+// request goes through middleware first, in order, which
+// can check them and reply instead.
+func AdminHandler(middleware ...func(http.Handler) http.Handler) http.Handler {
+	var handler http.Handler = http.HandlerFunc(serveAdmin)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+var (
+	errorNoFailure        = errors.New("There is no failure with this id.")
+	errorMethodNotAllowed = errors.New("The method is not allowed.")
+	errorNoRequestedWith  = errors.New("The request must have the X-Requested-With header.")
+)
+
+// Limit of the lists of the API without a limit parameter.
+const adminDefaultLimit = 50
+
+func serveAdmin(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "" {
+		if r.Method != "GET" {
+			writeAdminError(w, http.StatusMethodNotAllowed, errorMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(adminPage))
+		return
+	}
+	if !strings.HasPrefix(path, "api/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" && r.Header.Get("X-Requested-With") == "" {
+		writeAdminError(w, http.StatusForbidden, errorNoRequestedWith)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "api/"), "/")
+	offset, limit := listParams(r)
+	var result interface{}
+	var err error
+
+	switch route := r.Method + " " + parts[0]; {
+	case route == "GET info" && len(parts) == 1:
+		result, err = ReadInfo()
+	case route == "GET queues" && len(parts) == 1:
+		result, err = orEmpty(Queues())
+	case route == "GET queues" && len(parts) == 2:
+		result, err = orEmpty(Peek(parts[1], offset, limit))
+	case route == "DELETE queues" && len(parts) == 2:
+		var cleared int
+		cleared, err = ClearQueue(parts[1])
+		result = map[string]int{"cleared": cleared}
+	case route == "POST queues" && len(parts) == 3 && parts[2] == "pause":
+		err = PauseQueue(parts[1])
+		result = map[string]bool{"paused": true}
+	case route == "POST queues" && len(parts) == 3 && parts[2] == "resume":
+		err = ResumeQueue(parts[1])
+		result = map[string]bool{"paused": false}
+	case route == "GET workers" && len(parts) == 1:
+		result, err = orEmpty(Workers())
+	case route == "GET failures" && len(parts) == 1:
+		result, err = listFailures(offset, limit, failureFilter(r))
+	case route == "DELETE failures" && len(parts) == 1:
+		err = ClearFailures()
+		result = map[string]bool{"cleared": true}
+	case route == "POST failures" && len(parts) == 2 && parts[1] == "retry":
+		var retried int
+		retried, err = RetryFailures(failureFilter(r))
+		result = map[string]int{"retried": retried}
+	case route == "POST failures" && len(parts) == 3 && parts[2] == "retry":
+		err = withFailure(parts[1], offset, failureFilter(r), RetryFailure)
+		result = map[string]int{"retried": 1}
+	case route == "DELETE failures" && len(parts) == 2:
+		err = withFailure(parts[1], offset, failureFilter(r), RemoveFailure)
+		result = map[string]int{"removed": 1}
+	case route == "GET scheduled" && len(parts) == 1:
+		result, err = orEmpty(Scheduled(offset, limit))
+	case route == "GET rate-limits" && len(parts) == 1:
+		result, err = orEmpty(RateLimits())
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		writeAdminError(w, adminErrorStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Returns the offset and limit parameters of a list.
+func listParams(r *http.Request) (offset, limit int) {
+	offset, _ = strconv.Atoi(r.FormValue("offset"))
+	limit = adminDefaultLimit
+	if value, err := strconv.Atoi(r.FormValue("limit")); err == nil {
+		limit = value
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return
+}
+
+// Returns the filter of the class, queue and exception
+// parameters.
+func failureFilter(r *http.Request) *FailureFilter {
+	return &FailureFilter{
+		Class:     r.FormValue("class"),
+		Queue:     r.FormValue("queue"),
+		Exception: r.FormValue("exception"),
+	}
+}
+
+// Returns an empty list rather than null for the lists of
+// the API.
+func orEmpty(list interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch list := list.(type) {
+	case []*Queue:
+		if list == nil {
+			return []*Queue{}, nil
+		}
+	case []*Job:
+		if list == nil {
+			return []*Job{}, nil
+		}
+	case []*Worker:
+		if list == nil {
+			return []*Worker{}, nil
+		}
+	case []*ScheduledJob:
+		if list == nil {
+			return []*ScheduledJob{}, nil
+		}
+	case []*RateLimit:
+		if list == nil {
+			return []*RateLimit{}, nil
+		}
+	}
+	return list, nil
+}
+
+type adminFailure struct {
+	ID      string   `json:"id"`
+	Failure *Failure `json:"failure"`
+}
+
+type adminFailures struct {
+	Total    int            `json:"total"`
+	Failures []adminFailure `json:"failures"`
+}
+
+// Returns the failures matching filter with their id,
+// which identifies them in the requests retrying or
+// removing one, and the count of the failures matching
+// filter.
+func listFailures(offset, limit int, filter *FailureFilter) (interface{}, error) {
+	var total int
+	var failures []*Failure
+	var err error
+	if filter.empty() {
+		if total, err = FailureCount(); err != nil {
+			return nil, err
+		}
+		if failures, err = Failures(offset, limit, filter); err != nil {
+			return nil, err
+		}
+	} else {
+		// The failures matching filter are counted by
+		// reading them all, and paged from them.
+		if failures, err = Failures(0, 0, filter); err != nil {
+			return nil, err
+		}
+		total = len(failures)
+		if offset > total {
+			offset = total
+		}
+		failures = failures[offset:]
+		if limit > 0 && limit < len(failures) {
+			failures = failures[:limit]
+		}
+	}
+	identified := make([]adminFailure, 0, len(failures))
+	for _, failure := range failures {
+		identified = append(identified, adminFailure{adminFailureID(failure), failure})
+	}
+	return &adminFailures{total, identified}, nil
+}
+
+// Returns the id of failure in the API, a hash of the
+// failure as stored. Unlike its index, it does not change
+// when the failures before it are removed, and it changes
+// when the failure is retried.
+func adminFailureID(failure *Failure) string {
+	sum := sha1.Sum(failure.raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Calls fn with the failure whose id is id among the
+// failures matching filter, listed at offset. The failure
+// is looked up at offset, or before it, as far back as a
+// page of the API, once the failures before it were
+// removed.
+func withFailure(id string, offset int, filter *FailureFilter, fn func(*Failure) error) error {
+	start := offset - adminDefaultLimit
+	if start < 0 {
+		start = 0
+	}
+	failures, err := Failures(start, offset-start+1, filter)
+	if err != nil {
+		return err
+	}
+	for i := len(failures) - 1; i >= 0; i-- {
+		if adminFailureID(failures[i]) == id {
+			return fn(failures[i])
+		}
+	}
+	return errorNoFailure
+}
+
+func adminErrorStatus(err error) int {
+	switch err {
+	case errorNoFailure:
+		return http.StatusNotFound
+	case errorFailureChanged:
+		return http.StatusConflict
+	case errorNoQueueAdmin, errorNoRateLimiters:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,142 @@
+package goworker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	b := NewMemoryBackend()
+	SetBackend(b)
+	defer SetBackend(nil)
+
+	EnqueueWithBackend(b, "test_admin_http", "TestAdminHTTP", []interface{}{1}, false)
+	b.SaveFailure(&Failure{FailedAt: time.Now(), Queue: "test_admin_http", Class: "TestAdminHTTP", Exception: "errors.errorString", Error: "failed"})
+	b.SaveFailure(&Failure{FailedAt: time.Now(), Queue: "test_admin_http", Class: "TestAdminHTTP", Exception: "errors.errorString", Error: "failed again"})
+	failures, _ := b.Failures(0, 0, &FailureFilter{})
+	first, second := adminFailureID(failures[0]), adminFailureID(failures[1])
+
+	var authorized bool
+	handler := AdminHandler(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			authorized = true
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/", http.StatusOK, "<title>goworker</title>"},
+		{"GET", "/api/info", http.StatusOK, `"pending":1`},
+		{"GET", "/api/queues", http.StatusOK, `"name":"test_admin_http","size":1,"paused":false`},
+		{"GET", "/api/queues/test_admin_http", http.StatusOK, `"class":"TestAdminHTTP","args":[1]`},
+		{"POST", "/api/queues/test_admin_http/pause", http.StatusOK, `{"paused":true}`},
+		{"GET", "/api/queues", http.StatusOK, `"paused":true`},
+		{"DELETE", "/api/queues/test_admin_http", http.StatusOK, `{"cleared":1}`},
+		{"GET", "/api/workers", http.StatusOK, `[]`},
+		{"GET", "/api/failures", http.StatusOK, `"total":2,"failures":[{"id":"` + first + `",`},
+		{"GET", "/api/failures?class=TestAdminHTTP&offset=1", http.StatusOK, `"total":2,"failures":[{"id":"` + second + `",`},
+		{"GET", "/api/failures?class=Other", http.StatusOK, `"total":0,"failures":[]`},
+		{"DELETE", "/api/failures/unknown", http.StatusNotFound, `"error":"There is no failure with this id."`},
+		{"DELETE", "/api/failures/" + second + "?offset=0", http.StatusNotFound, `"error":"There is no failure with this id."`},
+		{"DELETE", "/api/failures/" + first + "?offset=0", http.StatusOK, `{"removed":1}`},
+		{"POST", "/api/failures/" + second + "/retry?offset=1", http.StatusOK, `{"retried":1}`},
+		{"GET", "/api/scheduled", http.StatusOK, `[]`},
+		{"GET", "/api/rate-limits", http.StatusOK, `[]`},
+		{"GET", "/api/unknown", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest(tt.method, tt.path, nil)
+		request.Header.Set("Authorization", "secret")
+		request.Header.Set("X-Requested-With", "test")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != tt.status || !strings.Contains(recorder.Body.String(), tt.body) {
+			t.Errorf("%s %s: expected %d %s, actual %d %s", tt.method, tt.path, tt.status, tt.body, recorder.Code, recorder.Body)
+		}
+	}
+	if size, _ := b.QueueSize("test_admin_http"); size != 1 {
+		t.Errorf("expecting the failure to be retried, but got %d jobs", size)
+	}
+
+	// Write requests without the header, as sent by a form
+	// of another site, are rejected.
+	request := httptest.NewRequest("DELETE", "/api/failures", nil)
+	request.Header.Set("Authorization", "secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expecting the request without X-Requested-With to be forbidden, but got %d", recorder.Code)
+	}
+	if count, _ := b.FailureCount(); count != 1 {
+		t.Errorf("expecting the failures to be kept, but got %d", count)
+	}
+
+	authorized = false
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/api/failures", nil))
+	if recorder.Code != http.StatusUnauthorized || authorized {
+		t.Errorf("expecting the middleware to reject the request, but got %d", recorder.Code)
+	}
+}
+
+func TestPollerSkipsPausedQueues(t *testing.T) {
+	b := NewMemoryBackend()
+	b.Push("test_paused", []byte(`{"class":"A","args":[]}`))
+	b.Push("test_unpaused", []byte(`{"class":"B","args":[]}`))
+	b.(QueueAdmin).PauseQueue("test_paused")
+
+	p, err := newPoller([]string{"test_paused", "test_unpaused"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"B", ""} {
+		job, err := p.getJob(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var class string
+		if job != nil {
+			class = job.Payload.Class
+		}
+		if class != expected {
+			t.Errorf("expecting job %q, but got %q", expected, class)
+		}
+	}
+
+	b.(QueueAdmin).ResumeQueue("test_paused")
+	p.pausedAt = time.Time{}
+	if job, _ := p.getJob(b); job == nil || job.Payload.Class != "A" {
+		t.Errorf("expecting job A once resumed, but got %v", job)
+	}
+}
+
+func TestAdminFailuresJSON(t *testing.T) {
+	var result struct {
+		Total    int `json:"total"`
+		Failures []struct {
+			ID      string          `json:"id"`
+			Failure json.RawMessage `json:"failure"`
+		} `json:"failures"`
+	}
+	SetBackend(NewMemoryBackend())
+	defer SetBackend(nil)
+
+	recorder := httptest.NewRecorder()
+	AdminHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/failures", nil))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil || result.Failures == nil {
+		t.Errorf("expecting an empty list of failures, but got %s %v", recorder.Body, err)
+	}
+}
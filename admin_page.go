@@ -0,0 +1,176 @@
+package goworker
+
+// Page of the dashboard of AdminHandler, which renders the
+// JSON of its API. Its links are relative, so that it works
+// wherever the handler is mounted.
+const adminPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>goworker</title>
+<style>
+body { font: 14px/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; color: #222; }
+header { background: #333; color: #fff; padding: 10px 20px; }
+header a { color: #ccc; margin-right: 16px; text-decoration: none; cursor: pointer; }
+header a.active { color: #fff; font-weight: bold; }
+main { padding: 20px; }
+table { border-collapse: collapse; width: 100%; margin-top: 10px; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f4; }
+td.args { font-family: monospace; word-break: break-all; }
+button { margin-right: 4px; }
+.error { color: #b00; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<header>
+<strong>goworker</strong>&nbsp;&nbsp;
+<a data-view="overview">Overview</a>
+<a data-view="queues">Queues</a>
+<a data-view="workers">Workers</a>
+<a data-view="failures">Failures</a>
+<a data-view="scheduled">Scheduled</a>
+<a data-view="rate-limits">Rate limits</a>
+</header>
+<main id="main"></main>
+<script>
+var main = document.getElementById("main");
+
+function esc(value) {
+	return String(value).replace(/[&<>"']/g, function (c) {
+		return "&#" + c.charCodeAt(0) + ";";
+	});
+}
+
+// Encodes a name for the paths of the API, which are also
+// quoted in the onclick attributes.
+function enc(value) {
+	return encodeURIComponent(value).replace(/'/g, "%27");
+}
+
+function time(value) {
+	return value ? esc(new Date(value).toLocaleString()) : '<span class="muted">-</span>';
+}
+
+function args(value) {
+	return '<td class="args">' + esc(JSON.stringify(value)) + '</td>';
+}
+
+function api(method, path) {
+	return fetch("api/" + path, {method: method, credentials: "same-origin", headers: {"X-Requested-With": "goworker"}}).then(function (response) {
+		return response.json().then(function (body) {
+			if (!response.ok) {
+				throw new Error(body.error || response.statusText);
+			}
+			return body;
+		});
+	});
+}
+
+function act(method, path, question) {
+	if (question && !confirm(question)) {
+		return;
+	}
+	api(method, path).then(route, fail);
+}
+
+function fail(err) {
+	main.innerHTML = '<p class="error">' + esc(err.message) + '</p>';
+}
+
+function table(headers, rows) {
+	if (rows.length == 0) {
+		return '<p class="muted">Nothing to show.</p>';
+	}
+	return '<table><tr><th>' + headers.join('</th><th>') + '</th></tr>' + rows.join('') + '</table>';
+}
+
+var views = {
+	overview: function () {
+		return api("GET", "info").then(function (info) {
+			var rows = Object.keys(info).map(function (name) {
+				return '<tr><td>' + esc(name) + '</td><td>' + esc(info[name]) + '</td></tr>';
+			});
+			return '<h2>Overview</h2>' + table(["Stat", "Value"], rows);
+		});
+	},
+	queues: function (queue) {
+		if (queue) {
+			return api("GET", "queues/" + enc(queue) + "?limit=100").then(function (jobs) {
+				return '<h2>Queue ' + esc(queue) + '</h2>' + table(["Enqueued at", "ID", "Class", "Args"], jobs.map(function (job) {
+					return '<tr><td>' + time(job.enqueued_at) + '</td><td>' + esc(job.id || "") + '</td><td>' + esc(job.class) + '</td>' + args(job.args) + '</tr>';
+				}));
+			});
+		}
+		return api("GET", "queues").then(function (queues) {
+			return '<h2>Queues</h2>' + table(["Queue", "Size", "Oldest job", ""], queues.map(function (q) {
+				var name = enc(q.name);
+				return '<tr><td><a href="#queues/' + name + '">' + esc(q.name) + '</a>' + (q.paused ? ' <span class="muted">(paused)</span>' : '') + '</td>' +
+					'<td>' + q.size + '</td><td>' + time(q.oldest_enqueued_at) + '</td><td>' +
+					(q.paused ?
+						'<button onclick="act(\'POST\', \'queues/' + name + '/resume\')">Resume</button>' :
+						'<button onclick="act(\'POST\', \'queues/' + name + '/pause\')">Pause</button>') +
+					'<button onclick="act(\'DELETE\', \'queues/' + name + '\', \'Clear the queue?\')">Clear</button></td></tr>';
+			}));
+		});
+	},
+	workers: function () {
+		return api("GET", "workers").then(function (workers) {
+			return '<h2>Workers</h2>' + table(["Worker", "Processed", "Failed", "Queue", "Started at", "Class", "Args"], workers.map(function (w) {
+				var job = w.job;
+				return '<tr><td>' + esc(w.name) + '</td><td>' + w.processed + '</td><td>' + w.failed + '</td>' +
+					(job ? '<td>' + esc(job.queue) + '</td><td>' + time(job.run_at) + '</td><td>' + esc(job.class) + '</td>' + args(job.args) :
+						'<td colspan="4" class="muted">idle</td>') + '</tr>';
+			}));
+		});
+	},
+	failures: function () {
+		return api("GET", "failures?limit=100").then(function (result) {
+			var buttons = result.failures.length == 0 ? '' :
+				'<button onclick="act(\'POST\', \'failures/retry\', \'Retry every failure?\')">Retry all</button>' +
+				'<button onclick="act(\'DELETE\', \'failures\', \'Remove every failure?\')">Clear</button>';
+			return '<h2>Failures (' + result.total + ')</h2>' + buttons + table(["Failed at", "Queue", "Class", "Args", "Error", ""], result.failures.map(function (f, i) {
+				var failure = f.failure;
+				return '<tr><td>' + esc(failure.failed_at) + '</td><td>' + esc(failure.queue) + '</td><td>' + esc(failure.payload.class) + '</td>' + args(failure.payload.args) +
+					'<td>' + esc(failure.exception) + ': ' + esc(failure.error) + '</td><td>' +
+					'<button onclick="act(\'POST\', \'failures/' + f.id + '/retry?offset=' + i + '\')">Retry</button>' +
+					'<button onclick="act(\'DELETE\', \'failures/' + f.id + '?offset=' + i + '\')">Remove</button></td></tr>';
+			}));
+		});
+	},
+	scheduled: function () {
+		return api("GET", "scheduled?limit=100").then(function (jobs) {
+			return '<h2>Scheduled</h2>' + table(["Due at", "Queue", "Class", "Args"], jobs.map(function (job) {
+				return '<tr><td>' + time(job.at) + '</td><td>' + esc(job.queue) + '</td><td>' + esc(job.class) + '</td>' + args(job.args) + '</tr>';
+			}));
+		});
+	},
+	"rate-limits": function () {
+		return api("GET", "rate-limits").then(function (limits) {
+			return '<h2>Rate limits</h2>' + table(["Class", "Queue", "Limit", "Window", "Count", "Next at"], limits.map(function (l) {
+				return '<tr><td>' + esc(l.class || "") + '</td><td>' + esc(l.queue || "") + '</td><td>' + l.limit + '</td><td>' + esc(l.window) + 's</td>' +
+					'<td>' + l.count + '</td><td>' + time(l.next_at) + '</td></tr>';
+			}));
+		});
+	}
+};
+
+function route() {
+	var hash = location.hash.replace(/^#/, "").split("/");
+	var view = views[hash[0]] ? hash[0] : "overview";
+	document.querySelectorAll("header a").forEach(function (a) {
+		a.className = a.getAttribute("data-view") == view ? "active" : "";
+		a.href = "#" + a.getAttribute("data-view");
+	});
+	views[view](hash[1] && decodeURIComponent(hash[1])).then(function (html) {
+		main.innerHTML = html;
+	}, fail);
+}
+
+window.addEventListener("hashchange", route);
+route();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,80 @@
+package goworker
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+var parseWorkerTests = []struct {
+	worker string
+	host   string
+	pid    int
+	ok     bool
+}{
+	{"host:123-0:high,low", "host", 123, true},
+	{"host:123-0:", "host", 123, true},
+	{"host:abc-0:high", "", 0, false},
+	{"host", "", 0, false},
+}
+
+func TestParseWorker(t *testing.T) {
+	for _, tt := range parseWorkerTests {
+		host, pid, ok := parseWorker(tt.worker)
+		if host != tt.host || pid != tt.pid || ok != tt.ok {
+			t.Errorf("ParseWorker(%q): expected %s %d %v, actual %s %d %v", tt.worker, tt.host, tt.pid, tt.ok, host, pid, ok)
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	b := NewMemoryBackend()
+	SetBackend(b)
+	defer SetBackend(nil)
+
+	hostname, _ := os.Hostname()
+	alive := fmt.Sprintf("%s:%d-0:test_admin", hostname, os.Getpid())
+	dead := fmt.Sprintf("%s:%d-0:test_admin", hostname, 1<<22-1)
+	other := "elsewhere:1-0:test_admin"
+
+	if err := EnqueueWithBackend(b, "test_admin", "TestAdmin", []interface{}{1}, false); err != nil {
+		t.Fatal(err)
+	}
+	b.Push("test_admin", []byte(`{"class":"TestResque","args":[]}`))
+	for _, worker := range []string{alive, dead, other} {
+		b.RegisterWorker(worker)
+	}
+	b.StartWork(alive, []byte(`{"queue":"test_admin","run_at":"2016-01-02T15:04:05Z","payload":{"class":"TestAdmin","args":[2]}}`))
+	b.IncrStats("processed", "processed:"+alive)
+
+	queues, err := Queues()
+	if err != nil || len(queues) != 1 || queues[0].Size != 2 || queues[0].OldestEnqueuedAt == nil || time.Since(*queues[0].OldestEnqueuedAt) > time.Minute {
+		t.Errorf("expecting test_admin with 2 jobs enqueued just now, but got %+v %v", queues, err)
+	}
+
+	jobs, err := Peek("test_admin", 1, 1)
+	if err != nil || len(jobs) != 1 || jobs[0].Class != "TestResque" || jobs[0].EnqueuedAt != nil {
+		t.Errorf("expecting the job of Resque, but got %+v %v", jobs, err)
+	}
+
+	workers, err := Workers()
+	if err != nil || len(workers) != 3 {
+		t.Fatalf("expecting 3 workers, but got %v %v", workers, err)
+	}
+	for _, worker := range workers {
+		if worker.Name == alive && (worker.Processed != 1 || worker.Job == nil || worker.Job.Class != "TestAdmin") {
+			t.Errorf("expecting %s to run TestAdmin, but got %+v", alive, worker)
+		}
+	}
+
+	info, err := ReadInfo()
+	if err != nil || *info != (Info{Pending: 2, Processed: 1, Queues: 1, Workers: 3, Working: 1}) {
+		t.Errorf("expecting the info of test_admin, but got %+v %v", info, err)
+	}
+
+	pruned, err := PruneWorkers()
+	if err != nil || len(pruned) != 1 || pruned[0] != dead {
+		t.Errorf("expecting %s to be pruned, but got %v %v", dead, pruned, err)
+	}
+}
@@ -0,0 +1,313 @@
+package goworker
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	errorNoDelayedJobs = errors.New("The backend does not store delayed jobs.")
+)
+
+// Backend stores the queues, workers, stats and failures
+// of goworker. The default backend is Redis, laid out like
+// Resque. The memory backend, chosen with a memory:// URI,
+// keeps everything in the process, the Postgres backend,
+// chosen with a postgres:// URI, in tables, and the SQLite
+// backend, chosen with a sqlite:// URI, in a file.
+//
+// Errors which mean that the backend could not be reached
+// are retried with backoff within the outage budget; the
+// others are returned to the caller.
+type Backend interface {
+	// Push appends a job to the tail of queue.
+	Push(queue string, job []byte) error
+
+	// Requeue puts a job popped from queue back at its
+	// head, when goworker stops before running it.
+	Requeue(queue string, job []byte) error
+
+	// Pop removes the job at the head of the first
+	// non-empty queue, in order. The job is nil if every
+	// queue is empty.
+	Pop(queues []string) (queue string, job []byte, err error)
+
+	// Queues returns the names of the known queues.
+	Queues() ([]string, error)
+
+	// QueueSize returns the number of jobs in queue.
+	QueueSize(queue string) (int, error)
+
+	// Peek returns up to limit jobs of queue from offset,
+	// without removing them. A limit of zero returns every
+	// job from offset.
+	Peek(queue string, offset, limit int) ([][]byte, error)
+
+	// RegisterWorker adds worker to the workers and resets
+	// its stats. UnregisterWorker removes it with its
+	// stats and current job.
+	RegisterWorker(worker string) error
+	UnregisterWorker(worker string) error
+
+	// StartWork records the job worker is running, as the
+	// JSON of its queue, run_at and payload, or only the
+	// start time if work is nil. FinishWork clears it.
+	StartWork(worker string, work []byte) error
+	FinishWork(worker string) error
+
+	// Workers returns the registered workers, and
+	// WorkerJob the job a worker is running, or nil.
+	Workers() ([]string, error)
+	WorkerJob(worker string) ([]byte, error)
+
+	// IncrStats increments the named counters, like
+	// processed or failed:<worker>, and Stat reads one.
+	IncrStats(stats ...string) error
+	Stat(stat string) (int, error)
+
+	// SaveFailure stores a failure, subject to the
+	// retention options.
+	SaveFailure(failure *Failure) error
+
+	// Failures returns up to limit failures matching
+	// filter, skipping the first offset matches, as for
+	// the Failures function.
+	Failures(offset, limit int, filter *FailureFilter) ([]*Failure, error)
+	FailureCount() (int, error)
+
+	// RetryFailure pushes the job of a failure back onto
+	// its queue and sets its retried_at. RemoveFailure
+	// deletes it. Both return an error if the failure
+	// changed since it was read.
+	RetryFailure(failure *Failure) error
+	RemoveFailure(failure *Failure) error
+	ClearFailures() error
+
+	// PruneFailures removes the failures which failed
+	// before cutoff and returns their number.
+	PruneFailures(cutoff time.Time) (int, error)
+
+	// Ping checks that the backend can be reached.
+	Ping() error
+
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// Acknowledger is implemented by the backends which keep
+// the jobs they pop until the jobs are done with, like the
+// streams backend. Ack is called with the queue and the
+// job returned by Pop once the job succeeded, or once its
+// failure was saved.
+type Acknowledger interface {
+	Ack(queue string, job []byte) error
+}
+
+// pendingJobs holds the ids of the jobs popped from a
+// backend until they are acknowledged, by queue and job.
+type pendingJobs struct {
+	mutex sync.Mutex
+	ids   map[string][]string
+}
+
+// Records the id of a job popped from queue. The id of a
+// job popped again, after it failed, is already known.
+func (p *pendingJobs) add(queue string, job []byte, id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.ids == nil {
+		p.ids = make(map[string][]string)
+	}
+	key := queue + "\x00" + string(job)
+	for _, pending := range p.ids[key] {
+		if pending == id {
+			return
+		}
+	}
+	p.ids[key] = append(p.ids[key], id)
+}
+
+// Returns and forgets the id of a job popped from queue, or
+// an empty string.
+func (p *pendingJobs) take(queue string, job []byte) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := queue + "\x00" + string(job)
+	ids := p.ids[key]
+	if len(ids) == 0 {
+		return ""
+	}
+	if len(ids) == 1 {
+		delete(p.ids, key)
+	} else {
+		p.ids[key] = ids[1:]
+	}
+	return ids[0]
+}
+
+// Returns the ids of a job popped from queue which is not
+// acknowledged yet.
+func (p *pendingJobs) get(queue string, job []byte) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]string(nil), p.ids[queue+"\x00"+string(job)]...)
+}
+
+// ClaimRenewer is implemented by the Acknowledgers whose
+// jobs are claimed for ClaimLease once popped, like the
+// SQLite backend, and popped again afterwards as if their
+// process crashed. RenewClaim extends the claim of a job
+// popped from queue, while the job runs.
+type ClaimRenewer interface {
+	ClaimLease() time.Duration
+	RenewClaim(queue string, job []byte) error
+}
+
+// claim renews the claim of a job until it is released.
+type claim struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+// Renews the claim of job every third of its lease until
+// the returned claim is released, or returns nil if b does
+// not claim the jobs it pops.
+func renewClaim(b Backend, job *job) *claim {
+	renewer, ok := b.(ClaimRenewer)
+	if !ok || job.raw == nil || renewer.ClaimLease() <= 0 {
+		return nil
+	}
+
+	c := &claim{stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(renewer.ClaimLease() / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if err := renewer.RenewClaim(job.Queue, job.raw); err != nil {
+					logger.With("queue", job.Queue).Errorf("Error on renewing the claim of %v: %v", job.Payload.Class, err)
+				}
+			}
+		}
+	}()
+	return c
+}
+
+// Stops renewing the claim of a job once it finished, or
+// was requeued. It does nothing on nil claims, as for the
+// backends which do not claim jobs.
+func (c *claim) release() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		close(c.stop)
+	})
+}
+
+// Scheduler is implemented by the backends which store
+// delayed jobs, like the SQLite backend. PushAt appends a
+// job to queue which is not popped before at.
+type Scheduler interface {
+	PushAt(queue string, job []byte, at time.Time) error
+}
+
+// ScheduleReader is implemented by the Schedulers which
+// list their delayed jobs. Scheduled returns up to limit
+// jobs which are not due yet from offset, the next due
+// first. A limit of zero returns every job from offset.
+type ScheduleReader interface {
+	Scheduled(offset, limit int) ([]*ScheduledJob, error)
+}
+
+// QueueAdmin is implemented by the backends whose queues
+// can be cleared and paused, from the admin handler.
+// ClearQueue removes the jobs of queue waiting to be run
+// and returns their number. Pollers do not pop the jobs of
+// the queues returned by PausedQueues.
+type QueueAdmin interface {
+	ClearQueue(queue string) (int, error)
+	PauseQueue(queue string) error
+	ResumeQueue(queue string) error
+	PausedQueues() ([]string, error)
+}
+
+// ConcurrencyCommander is implemented by the backends
+// through which the concurrency of running processes is
+// changed, like the Redis backends. process is the
+// hostname:pid of a process, or empty for every process.
+// RemoteConcurrency returns the concurrency set for
+// process, or else for every process, or zero if none is.
+type ConcurrencyCommander interface {
+	SetRemoteConcurrency(process string, concurrency int) error
+	RemoteConcurrency(process string) (int, error)
+}
+
+// Waker is implemented by the backends which tell the
+// poller that a job was pushed, like the Postgres backend,
+// so that it does not wait for the interval. Wakeup
+// returns a channel receiving the queue of the jobs.
+type Waker interface {
+	Wakeup() <-chan string
+}
+
+// currentBackend is the backend of Work and Enqueue.
+var currentBackend Backend
+
+// Returns a backend for uri, the uri option unless given
+// a pool or a backend. Redis backends are closed by the
+// caller, memory backends live as long as the process so
+// that jobs enqueued by Enqueue can be run by Work.
+func newBackend(uri string) Backend {
+	if u, err := url.Parse(uri); err == nil {
+		switch u.Scheme {
+		case "memory":
+			return memoryBackendFor(u.Host + u.Path)
+		case "postgres", "postgresql":
+			return NewPostgresBackend(uri)
+		case "sqlite":
+			return NewSQLiteBackend(sqlitePath(u))
+		}
+	}
+	b := &redisBackend{
+		pool:      newRedisPool(uri, cfg.connections, maxConnections(), time.Minute),
+		closePool: true,
+	}
+	switch cfg.queueBackend {
+	case "streams":
+		return newStreamsBackend(b)
+	case "sidekiq":
+		return newSidekiqBackend(b)
+	}
+	return b
+}
+
+// SetBackend sets the backend of Work, Enqueue and the
+// other functions of the API which are not given one,
+// instead of that of the uri option, as for the fake
+// backend of the goworkertest package. The backend is not
+// closed by them. A nil backend restores the uri option.
+func SetBackend(b Backend) {
+	cfg.backend = b
+}
+
+// Runs fn with the backend of SetBackend or of the uri
+// option, for the functions of the API which are not
+// given a backend.
+func withBackend(fn func(b Backend) error) error {
+	if cfg.backend != nil {
+		return fn(cfg.backend)
+	}
+	b := newBackend(cfg.uri)
+	defer b.Close()
+	return fn(b)
+}
@@ -0,0 +1,133 @@
+package goworker
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+)
+
+// Backtracer is implemented by errors that carry the
+// stack trace of the place they were created. The lines
+// are stored as the backtrace of the failure.
+type Backtracer interface {
+	Backtrace() []string
+}
+
+// ExceptionNamer is implemented by errors that want to
+// choose the exception name shown for the failure instead
+// of their Go type.
+type ExceptionNamer interface {
+	ExceptionName() string
+}
+
+// Errors like those of github.com/go-errors/errors expose
+// the raw output of runtime/debug.Stack.
+type stacker interface {
+	Stack() []byte
+}
+
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func newPanicError(value interface{}) *panicError {
+	return &panicError{
+		value: value,
+		stack: debug.Stack(),
+	}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprint(e.value)
+}
+
+func (e *panicError) Backtrace() []string {
+	return stackLines(e.stack)
+}
+
+func (e *panicError) ExceptionName() string {
+	if err, ok := e.value.(error); ok {
+		return exceptionName(err)
+	}
+	return "Panic"
+}
+
+// Returns err and every error it wraps, following both
+// Unwrap and the Cause method of github.com/pkg/errors.
+func errorChain(err error) []error {
+	var chain []error
+	for err != nil {
+		chain = append(chain, err)
+		switch e := err.(type) {
+		case interface {
+			Unwrap() error
+		}:
+			err = e.Unwrap()
+		case interface {
+			Cause() error
+		}:
+			err = e.Cause()
+		default:
+			err = nil
+		}
+	}
+	return chain
+}
+
+func exceptionName(err error) string {
+	for _, e := range errorChain(err) {
+		if namer, ok := e.(ExceptionNamer); ok {
+			if name := namer.ExceptionName(); name != "" {
+				return name
+			}
+		}
+	}
+	return strings.TrimPrefix(reflect.TypeOf(err).String(), "*")
+}
+
+func backtrace(err error) []string {
+	for _, e := range errorChain(err) {
+		switch e := e.(type) {
+		case Backtracer:
+			return e.Backtrace()
+		case stacker:
+			return stackLines(e.Stack())
+		}
+		if lines := stackTraceLines(e); lines != nil {
+			return lines
+		}
+	}
+	return nil
+}
+
+// Errors of github.com/pkg/errors have a StackTrace method
+// returning a slice of frames which print their function,
+// file and line when formatted with %+v. Reflection is
+// used to avoid depending on the package.
+func stackTraceLines(err error) []string {
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return nil
+	}
+	frames := method.Call(nil)[0]
+	if frames.Kind() != reflect.Slice {
+		return nil
+	}
+	var lines []string
+	for i := 0; i < frames.Len(); i++ {
+		lines = append(lines, stackLines([]byte(fmt.Sprintf("%+v", frames.Index(i).Interface())))...)
+	}
+	return lines
+}
+
+func stackLines(stack []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(stack), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
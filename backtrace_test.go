@@ -0,0 +1,147 @@
+package goworker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type namedError struct{}
+
+func (e *namedError) Error() string         { return "named" }
+func (e *namedError) ExceptionName() string { return "Named::Error" }
+
+type backtracedError struct{}
+
+func (e backtracedError) Error() string       { return "backtraced" }
+func (e backtracedError) Backtrace() []string { return []string{"a.go:1", "b.go:2"} }
+
+type stackedError struct{}
+
+func (e stackedError) Error() string { return "stacked" }
+func (e stackedError) Stack() []byte { return []byte("main.f()\n\t/a.go:1 +0x1\n") }
+
+type wrappedError struct {
+	err error
+}
+
+func (e *wrappedError) Error() string { return "wrapped: " + e.err.Error() }
+func (e *wrappedError) Unwrap() error { return e.err }
+
+type causedError struct {
+	err error
+}
+
+func (e causedError) Error() string { return "caused: " + e.err.Error() }
+func (e causedError) Cause() error  { return e.err }
+
+type frame string
+
+func (f frame) Format(s fmt.State, verb rune) {
+	fmt.Fprintf(s, "%s\n\t/%s.go:1", string(f), string(f))
+}
+
+type stackTracedError struct{}
+
+func (e stackTracedError) Error() string       { return "stack traced" }
+func (e stackTracedError) StackTrace() []frame { return []frame{"main.f", "main.g"} }
+
+var exceptionNameTests = []struct {
+	err      error
+	expected string
+}{
+	{
+		errors.New("plain"),
+		"errors.errorString",
+	},
+	{
+		backtracedError{},
+		"goworker.backtracedError",
+	},
+	{
+		&namedError{},
+		"Named::Error",
+	},
+	{
+		&wrappedError{&namedError{}},
+		"Named::Error",
+	},
+	{
+		causedError{errors.New("plain")},
+		"goworker.causedError",
+	},
+	{
+		&panicError{value: "boom"},
+		"Panic",
+	},
+	{
+		&panicError{value: &namedError{}},
+		"Named::Error",
+	},
+}
+
+func TestExceptionName(t *testing.T) {
+	for _, tt := range exceptionNameTests {
+		actual := exceptionName(tt.err)
+		if actual != tt.expected {
+			t.Errorf("ExceptionName(%#v): expected %s, actual %s", tt.err, tt.expected, actual)
+		}
+	}
+}
+
+var backtraceTests = []struct {
+	err      error
+	expected []string
+}{
+	{
+		errors.New("plain"),
+		nil,
+	},
+	{
+		backtracedError{},
+		[]string{"a.go:1", "b.go:2"},
+	},
+	{
+		stackedError{},
+		[]string{"main.f()", "/a.go:1 +0x1"},
+	},
+	{
+		&wrappedError{causedError{backtracedError{}}},
+		[]string{"a.go:1", "b.go:2"},
+	},
+	{
+		stackTracedError{},
+		[]string{"main.f", "/main.f.go:1", "main.g", "/main.g.go:1"},
+	},
+	{
+		&panicError{value: "boom", stack: []byte("goroutine 1 [running]:\nmain.f()\n")},
+		[]string{"goroutine 1 [running]:", "main.f()"},
+	},
+}
+
+func TestBacktrace(t *testing.T) {
+	for _, tt := range backtraceTests {
+		actual := backtrace(tt.err)
+		if fmt.Sprint(actual) != fmt.Sprint(tt.expected) {
+			t.Errorf("Backtrace(%#v): expected %v, actual %v", tt.err, tt.expected, actual)
+		}
+	}
+}
+
+func TestPanicErrorRecordsStack(t *testing.T) {
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			}
+		}()
+		panic("boom")
+	}()
+	if err.Error() != "boom" {
+		t.Errorf("PanicError: expected boom, actual %s", err)
+	}
+	if len(backtrace(err)) == 0 {
+		t.Error("PanicError: expected a backtrace")
+	}
+}
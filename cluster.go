@@ -0,0 +1,504 @@
+package goworker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Number of hash slots of a Redis Cluster.
+const clusterSlots = 16384
+
+// Number of MOVED redirections followed for a command.
+const clusterMaxRedirects = 5
+
+var (
+	errorNoClusterNode    = errors.New("No Redis Cluster node could be reached.")
+	errorNoPendingReply   = errors.New("No pending reply to receive.")
+	errorClusterConnClose = errors.New("Cluster connection closed.")
+)
+
+// cluster holds the slot map of a Redis Cluster, shared by
+// the connections to it.
+type cluster struct {
+	seeds []string
+	dial  func(addr string) (redis.Conn, error)
+
+	mu    sync.RWMutex
+	slots []string
+}
+
+var (
+	clustersMutex sync.Mutex
+	clusters      = make(map[string]*cluster)
+)
+
+// Returns the cluster of uri, so that the connections of a
+// pool share its slot map.
+func clusterFor(uri string, seeds []string, dial func(addr string) (redis.Conn, error)) *cluster {
+	clustersMutex.Lock()
+	defer clustersMutex.Unlock()
+
+	c, ok := clusters[uri]
+	if !ok {
+		c = &cluster{seeds: seeds, dial: dial}
+		clusters[uri] = c
+	}
+	return c
+}
+
+// Loads the slot map from the first node answering
+// CLUSTER SLOTS, trying the seeds and the known nodes.
+func (c *cluster) refresh() error {
+	c.mu.RLock()
+	addrs := append([]string(nil), c.seeds...)
+	for _, addr := range c.slots {
+		if addr != "" && (len(addrs) == 0 || addrs[len(addrs)-1] != addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	c.mu.RUnlock()
+
+	err := errorNoClusterNode
+	for _, addr := range addrs {
+		var slots []string
+		if slots, err = c.loadSlots(addr); err == nil {
+			c.mu.Lock()
+			c.slots = slots
+			c.mu.Unlock()
+			return nil
+		}
+		logger.Warnf("Error on loading the slots of Redis Cluster from %s: %v", addr, err)
+	}
+	return err
+}
+
+func (c *cluster) loadSlots(addr string) ([]string, error) {
+	conn, err := c.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]string, clusterSlots)
+	for _, r := range ranges {
+		// Each range is start, end, master, replicas...,
+		// and each node is host, port, id...
+		fields, err := redis.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("Invalid CLUSTER SLOTS range %v.", r)
+		}
+		start, _ := redis.Int(fields[0], nil)
+		end, _ := redis.Int(fields[1], nil)
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 || start < 0 || end >= clusterSlots {
+			return nil, fmt.Errorf("Invalid CLUSTER SLOTS range %v.", r)
+		}
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		if host == "" {
+			// The node answering does not know its own
+			// address.
+			host, _, _ = net.SplitHostPort(addr)
+		}
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+	return slots, nil
+}
+
+// Returns the address of the master of slot, loading the
+// slot map if needed.
+func (c *cluster) addr(slot int) (string, error) {
+	c.mu.RLock()
+	loaded := c.slots != nil
+	var addr string
+	if loaded {
+		addr = c.slots[slot]
+	}
+	c.mu.RUnlock()
+
+	if !loaded {
+		if err := c.refresh(); err != nil {
+			return "", err
+		}
+		return c.addr(slot)
+	}
+	if addr == "" {
+		return "", fmt.Errorf("Slot %d is not served by Redis Cluster.", slot)
+	}
+	return addr, nil
+}
+
+// Returns the address of any node, for commands without
+// keys.
+func (c *cluster) anyAddr() (string, error) {
+	c.mu.RLock()
+	for _, addr := range c.slots {
+		if addr != "" {
+			c.mu.RUnlock()
+			return addr, nil
+		}
+	}
+	c.mu.RUnlock()
+	if len(c.seeds) == 0 {
+		return "", errorNoClusterNode
+	}
+	return c.seeds[0], nil
+}
+
+// Forgets the slot map, so that it is loaded again after a
+// node failed or a slot moved.
+func (c *cluster) invalidate() {
+	c.mu.Lock()
+	c.slots = nil
+	c.mu.Unlock()
+}
+
+// Returns the hash slot of key, of its hash tag if any.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// CRC16-CCITT (XModem), the hash of Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Returns the key that a command is routed by, if any.
+func commandKey(cmd string, args []interface{}) (string, bool) {
+	switch strings.ToUpper(cmd) {
+	case "", "PING", "ECHO", "AUTH", "SELECT", "INFO", "TIME", "SCRIPT", "CLUSTER", "CLIENT",
+		"MULTI", "EXEC", "DISCARD", "UNWATCH", "ASKING":
+		return "", false
+	case "EVAL", "EVALSHA":
+		if len(args) > 2 {
+			if n, _ := strconv.Atoi(argString(args[1])); n > 0 {
+				return argString(args[2]), true
+			}
+		}
+		return "", false
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.ToUpper(argString(arg)) == "STREAMS" && i+1 < len(args) {
+				return argString(args[i+1]), true
+			}
+		}
+		return "", false
+	case "XGROUP", "XINFO", "OBJECT":
+		if len(args) > 1 {
+			return argString(args[1]), true
+		}
+		return "", false
+	}
+	if len(args) > 0 {
+		return argString(args[0]), true
+	}
+	return "", false
+}
+
+func argString(arg interface{}) string {
+	switch arg := arg.(type) {
+	case string:
+		return arg
+	case []byte:
+		return string(arg)
+	}
+	return fmt.Sprint(arg)
+}
+
+type clusterCommand struct {
+	cmd  string
+	args []interface{}
+}
+
+type clusterReply struct {
+	reply interface{}
+	err   error
+}
+
+// clusterConn is a redis.Conn routing each command to the
+// master of the slot of its key. Pipelined commands are
+// run in order when flushed, as they may go to different
+// nodes. A transaction is pinned to the node of the key
+// watched or of its first command, so that its keys must
+// share a slot.
+type clusterConn struct {
+	cluster *cluster
+	conns   map[string]redis.Conn
+	pending []clusterCommand
+	replies []clusterReply
+
+	// tx is the node of the current transaction.
+	tx     string
+	inTx   bool
+	closed bool
+}
+
+func newClusterConn(c *cluster) *clusterConn {
+	return &clusterConn{cluster: c, conns: make(map[string]redis.Conn)}
+}
+
+// Returns a connection to the Redis Cluster of a
+// redis+cluster or rediss+cluster URI, listing some of its
+// nodes as in redis+cluster://:pass@host1:7000,host2:7001/.
+func clusterConnFromUri(uriString string, uri *url.URL) (*redisConn, error) {
+	options, err := dialOptions(uri.Query(), uri.Scheme == "rediss+cluster")
+	if err != nil {
+		return nil, err
+	}
+	var username, password string
+	if uri.User != nil {
+		username = uri.User.Username()
+		password, _ = uri.User.Password()
+	}
+
+	c := clusterFor(uriString, strings.Split(uri.Host, ","), func(addr string) (redis.Conn, error) {
+		conn, err := redis.Dial("tcp", addr, options...)
+		if err != nil {
+			return nil, err
+		}
+		if password != "" {
+			if err := auth(conn, username, password); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	})
+	// Fails like other connections while the cluster
+	// cannot be reached.
+	if _, err := c.addr(0); err != nil {
+		return nil, err
+	}
+	return &redisConn{Conn: newClusterConn(c)}, nil
+}
+
+func (c *clusterConn) Close() error {
+	for addr, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+	c.closed = true
+	return nil
+}
+
+func (c *clusterConn) Err() error {
+	if c.closed {
+		return errorClusterConnClose
+	}
+	return nil
+}
+
+func (c *clusterConn) Send(cmd string, args ...interface{}) error {
+	if c.closed {
+		return errorClusterConnClose
+	}
+	c.pending = append(c.pending, clusterCommand{cmd, args})
+	return nil
+}
+
+func (c *clusterConn) Flush() error {
+	if c.closed {
+		return errorClusterConnClose
+	}
+	// Commands are taken off one at a time, so that MULTI
+	// can see the commands that follow it.
+	for len(c.pending) > 0 {
+		command := c.pending[0]
+		c.pending = c.pending[1:]
+		reply, err := c.run(command.cmd, command.args)
+		c.replies = append(c.replies, clusterReply{reply, err})
+	}
+	return nil
+}
+
+func (c *clusterConn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+		if len(c.replies) == 0 {
+			return nil, errorNoPendingReply
+		}
+	}
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return reply.reply, reply.err
+}
+
+// Do runs the pending commands, then cmd. Like the
+// connections of redigo, it returns the replies of the
+// pending commands when cmd is "", and else the reply of
+// cmd, with the first error of them all.
+func (c *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	replies := c.replies
+	c.replies = nil
+
+	var err error
+	for _, reply := range replies {
+		if reply.err != nil && err == nil {
+			err = reply.err
+		}
+	}
+	if cmd == "" {
+		values := make([]interface{}, len(replies))
+		for i, reply := range replies {
+			values[i] = reply.reply
+		}
+		return values, err
+	}
+
+	reply, cmdErr := c.run(cmd, args)
+	if err == nil {
+		err = cmdErr
+	}
+	return reply, err
+}
+
+// Runs a command on the node of its key, following the
+// redirections of the cluster.
+func (c *clusterConn) run(cmd string, args []interface{}) (interface{}, error) {
+	name := strings.ToUpper(cmd)
+
+	// Keys of a DEL may be in different slots.
+	if name == "DEL" && len(args) > 1 && !c.inTx {
+		var deleted int64
+		for _, arg := range args {
+			n, err := redis.Int64(c.run(cmd, []interface{}{arg}))
+			if err != nil {
+				return nil, err
+			}
+			deleted += n
+		}
+		return deleted, nil
+	}
+
+	addr, err := c.route(name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	asking := false
+	for redirects := 0; ; redirects++ {
+		conn, err := c.node(addr)
+		if err != nil {
+			c.cluster.invalidate()
+			return nil, err
+		}
+		if asking {
+			conn.Send("ASKING")
+		}
+		reply, err := conn.Do(cmd, args...)
+		if e, ok := err.(redis.Error); ok {
+			reply = e
+			fields := strings.Fields(string(e))
+			if len(fields) == 3 && (fields[0] == "MOVED" || fields[0] == "ASK") && !c.inTx && redirects < clusterMaxRedirects {
+				if fields[0] == "MOVED" {
+					c.cluster.invalidate()
+				}
+				asking = fields[0] == "ASK"
+				addr = fields[2]
+				continue
+			}
+		} else if err != nil {
+			// The node failed: its connection is dropped
+			// and the slots loaded again next time.
+			conn.Close()
+			delete(c.conns, addr)
+			c.cluster.invalidate()
+		}
+
+		switch name {
+		case "WATCH":
+			c.tx = addr
+		case "EXEC", "DISCARD":
+			c.inTx = false
+			c.tx = ""
+		case "UNWATCH":
+			if !c.inTx {
+				c.tx = ""
+			}
+		}
+		return reply, err
+	}
+}
+
+// Returns the node of a command, and follows transactions.
+func (c *clusterConn) route(name string, args []interface{}) (string, error) {
+	switch name {
+	case "MULTI":
+		c.inTx = true
+		if c.tx != "" {
+			return c.tx, nil
+		}
+		// MULTI goes to the node of the first command of
+		// the transaction, found among the pending ones.
+		for _, command := range c.pending {
+			if key, ok := commandKey(command.cmd, command.args); ok {
+				addr, err := c.cluster.addr(keySlot(key))
+				c.tx = addr
+				return addr, err
+			}
+		}
+		addr, err := c.cluster.anyAddr()
+		c.tx = addr
+		return addr, err
+	case "EXEC", "DISCARD", "UNWATCH":
+		if c.tx != "" {
+			return c.tx, nil
+		}
+	}
+
+	key, ok := commandKey(name, args)
+	if !ok {
+		return c.cluster.anyAddr()
+	}
+	if c.inTx {
+		return c.tx, nil
+	}
+	return c.cluster.addr(keySlot(key))
+}
+
+func (c *clusterConn) node(addr string) (redis.Conn, error) {
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := c.cluster.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
@@ -0,0 +1,141 @@
+package goworker
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var keySlotTests = []struct {
+	key      string
+	expected int
+}{
+	{"123456789", 12739},
+	{"foo", 12182},
+	{"{foo}:queue:high", 12182},
+	{"resque:{foo}", 12182},
+	{"foo{}{bar}", keySlot("foo{}{bar}")},
+	{"{}foo", int(crc16("{}foo") % clusterSlots)},
+}
+
+func TestKeySlot(t *testing.T) {
+	for _, tt := range keySlotTests {
+		if actual := keySlot(tt.key); actual != tt.expected {
+			t.Errorf("KeySlot(%s): expected %d, actual %d", tt.key, tt.expected, actual)
+		}
+	}
+}
+
+var commandKeyTests = []struct {
+	cmd      string
+	args     []interface{}
+	expected string
+}{
+	{"LPOP", []interface{}{"resque:queue:high"}, "resque:queue:high"},
+	{"RPUSH", []interface{}{[]byte("q"), "job"}, "q"},
+	{"PING", nil, ""},
+	{"MULTI", nil, ""},
+	{"EVALSHA", []interface{}{"sha", 2, "a", "b"}, "a"},
+	{"EVAL", []interface{}{"return 1", 0}, ""},
+	{"XREADGROUP", []interface{}{"GROUP", "g", "c", "STREAMS", "s", ">"}, "s"},
+	{"XGROUP", []interface{}{"CREATE", "s", "g", "$"}, "s"},
+}
+
+func TestCommandKey(t *testing.T) {
+	for _, tt := range commandKeyTests {
+		if actual, _ := commandKey(tt.cmd, tt.args); actual != tt.expected {
+			t.Errorf("CommandKey(%s %v): expected %q, actual %q", tt.cmd, tt.args, tt.expected, actual)
+		}
+	}
+}
+
+// Starts a fake cluster node which serves the lower half of
+// the slots by redirecting them to the test Redis, which
+// serves the upper half.
+func startFakeClusterNode(t *testing.T) string {
+	uri, _ := url.Parse(cfg.uri)
+	host, port, _ := net.SplitHostPort(uri.Host)
+
+	var addr string
+	addr = startFakeRedis(t, func(args []string) string {
+		if strings.ToUpper(args[0]) == "CLUSTER" {
+			selfHost, selfPort, _ := net.SplitHostPort(addr)
+			return fmt.Sprintf("*2\r\n*3\r\n:0\r\n:8191\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n*3\r\n:8192\r\n:16383\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n",
+				len(selfHost), selfHost, selfPort, len(host), host, port)
+		}
+		return fmt.Sprintf("-MOVED 0 %s\r\n", uri.Host)
+	})
+	return addr
+}
+
+func TestClusterConn(t *testing.T) {
+	conn, err := redisConnFromUri(fmt.Sprintf("redis+cluster://%s/", startFakeClusterNode(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Keys on both halves of the slots.
+	keys := []string{"test_cluster:a", "test_cluster:b", "test_cluster:c", "test_cluster:d"}
+	defer conn.Do("DEL", "test_cluster:a", "test_cluster:b", "test_cluster:c", "test_cluster:d")
+
+	for _, key := range keys {
+		conn.Send("INCR", key)
+	}
+	replies, err := redis.Ints(conn.Do(""))
+	if err != nil || len(replies) != len(keys) {
+		t.Fatalf("expecting %d replies, but got %v %v", len(keys), replies, err)
+	}
+
+	if _, err := conn.Do("WATCH", "test_cluster:a"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Send("MULTI")
+	conn.Send("INCR", "test_cluster:a")
+	exec, err := redis.Values(conn.Do("EXEC"))
+	if err != nil || len(exec) != 1 {
+		t.Errorf("expecting the transaction to run, but got %v %v", exec, err)
+	}
+
+	script := redis.NewScript(1, `return redis.call("GET", KEYS[1])`)
+	if value, err := redis.Int(script.Do(conn.Conn, "test_cluster:a")); err != nil || value != 2 {
+		t.Errorf("expecting the script to read 2, but got %v %v", value, err)
+	}
+
+	deleted, err := redis.Int(conn.Do("DEL", "test_cluster:a", "test_cluster:b", "test_cluster:c", "test_cluster:d"))
+	if err != nil || deleted != len(keys) {
+		t.Errorf("expecting %d keys deleted, but got %v %v", len(keys), deleted, err)
+	}
+}
+
+func TestNamespaceHashTag(t *testing.T) {
+	namespace := cfg.namespace
+	defer func() {
+		Configure(map[string]string{"namespaceHashTag": "false"})
+		cfg.namespace = namespace
+	}()
+
+	Configure(map[string]string{"namespace": "resque:", "namespaceHashTag": "true"})
+	if cfg.namespace != "{resque}:" {
+		t.Errorf("expecting namespace {resque}:, but got %s", cfg.namespace)
+	}
+	Configure(map[string]string{"interval": "5"})
+	if cfg.namespace != "{resque}:" {
+		t.Errorf("expecting the namespace to be wrapped once, but got %s", cfg.namespace)
+	}
+
+	// An empty namespace would be wrapped in {}, which is
+	// no hash tag.
+	func() {
+		defer func() {
+			if r := recover(); r != errorEmptyHashTag {
+				t.Errorf("expecting %v, but got %v", errorEmptyHashTag, r)
+			}
+		}()
+		Configure(map[string]string{"namespace": ""})
+	}()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yudppp/goworker"
+)
+
+var errorNoFailures = errors.New("Give the indexes of the failures or -all.")
+
+func failed(args []string) error {
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "list":
+		return failedList(args[1:])
+	case "retry":
+		return failedRetry(args[1:])
+	case "remove":
+		return failedRemove(args[1:])
+	case "clear":
+		if err := goworker.ClearFailures(); err != nil {
+			return err
+		}
+		if jsonOutput {
+			return printJSON(map[string]bool{"cleared": true})
+		}
+		return nil
+	}
+	usage()
+	return nil
+}
+
+func failureFlags(name string) (*flag.FlagSet, func() (*goworker.FailureFilter, error)) {
+	flags := flag.NewFlagSet("failed "+name, flag.ExitOnError)
+	class := flags.String("class", "", "only failures of this class")
+	queue := flags.String("queue", "", "only failures from this queue")
+	exception := flags.String("exception", "", "only failures with this exception")
+	since := flags.String("since", "", "only failures at or after this RFC 3339 time")
+	until := flags.String("until", "", "only failures before this RFC 3339 time")
+
+	return flags, func() (*goworker.FailureFilter, error) {
+		filter := &goworker.FailureFilter{
+			Class:     *class,
+			Queue:     *queue,
+			Exception: *exception,
+		}
+		var err error
+		if *since != "" {
+			if filter.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+				return nil, err
+			}
+		}
+		if *until != "" {
+			if filter.Until, err = time.Parse(time.RFC3339, *until); err != nil {
+				return nil, err
+			}
+		}
+		return filter, nil
+	}
+}
+
+func failedList(args []string) error {
+	flags, filter := failureFlags("list")
+	offset := flags.Int("offset", 0, "number of matching failures to skip")
+	limit := flags.Int("limit", 20, "maximum number of failures to list, 0 for all")
+	flags.Parse(args)
+
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	failures, err := goworker.Failures(*offset, *limit, f)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		type indexedFailure struct {
+			Index   int               `json:"index"`
+			Failure *goworker.Failure `json:"failure"`
+		}
+		indexed := []indexedFailure{}
+		for i, failure := range failures {
+			indexed = append(indexed, indexedFailure{*offset + i, failure})
+		}
+		return printJSON(indexed)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tFAILED AT\tCOUNT\tQUEUE\tCLASS\tEXCEPTION\tERROR")
+	for i, failure := range failures {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			*offset+i,
+			failure.FailedAt.Format(time.RFC3339),
+			failure.Occurrences,
+			failure.Queue,
+			failure.Class,
+			failure.Exception,
+			strings.SplitN(failure.Error, "\n", 2)[0])
+	}
+	return w.Flush()
+}
+
+func failedRetry(args []string) error {
+	flags, filter := failureFlags("retry")
+	all := flags.Bool("all", false, "retry every failure matching the filters")
+	flags.Parse(args)
+
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	if *all {
+		retried, err := goworker.RetryFailures(f)
+		printCount("retried", "Retried", retried)
+		return err
+	}
+
+	failures, err := failuresAt(f, flags.Args())
+	if err != nil {
+		return err
+	}
+	for _, failure := range failures {
+		if err := goworker.RetryFailure(failure); err != nil {
+			return err
+		}
+	}
+	return printCount("retried", "Retried", len(failures))
+}
+
+func failedRemove(args []string) error {
+	flags, filter := failureFlags("remove")
+	flags.Parse(args)
+
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	// Failures are returned last first, so that removing
+	// them does not shift the indexes of the others.
+	failures, err := failuresAt(f, flags.Args())
+	if err != nil {
+		return err
+	}
+	for _, failure := range failures {
+		if err := goworker.RemoveFailure(failure); err != nil {
+			return err
+		}
+	}
+	return printCount("removed", "Removed", len(failures))
+}
+
+// Prints the number of failures retried or removed, under
+// key in JSON.
+func printCount(key string, action string, count int) error {
+	if jsonOutput {
+		return printJSON(map[string]int{key: count})
+	}
+	fmt.Printf("%s %d failures\n", action, count)
+	return nil
+}
+
+// Returns the failures at the indexes listed by failed
+// list with the same filters, from the last to the first.
+func failuresAt(filter *goworker.FailureFilter, values []string) ([]*goworker.Failure, error) {
+	if len(values) == 0 {
+		return nil, errorNoFailures
+	}
+	var indexes []int
+	for _, value := range values {
+		index, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+
+	var failures []*goworker.Failure
+	for _, index := range indexes {
+		found, err := goworker.Failures(index, 1, filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("No failure at index %d.", index)
+		}
+		failures = append(failures, found[0])
+	}
+	return failures, nil
+}
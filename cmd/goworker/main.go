@@ -0,0 +1,104 @@
+// Command goworker administers the Redis database used by
+// goworker and Resque.
+//
+//	goworker [-uri=redis://localhost:6379/] [-namespace=resque:] [-queue-backend=redis] [-json] <command> [arguments]
+//
+// The commands are:
+//
+//	queues                             lists the queues with their size and oldest job
+//	peek <queue>                       lists the next jobs of a queue
+//	enqueue <queue> <class> [<args>]   enqueues a job with a JSON array of arguments
+//	workers                            lists the workers and their current job
+//	prune-workers                      unregisters the dead workers of this host
+//	concurrency <n>                    sets the concurrency of running processes
+//	stats                              shows the number of jobs, workers and failures
+//	failed list                        lists failed jobs
+//	failed retry                       requeues failed jobs onto their queue
+//	failed remove                      removes failed jobs
+//	failed clear                       removes every failed job
+//
+// With -json, commands print JSON instead of tables, for
+// scripts.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/yudppp/goworker"
+)
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"queues":        queues,
+	"peek":          peek,
+	"enqueue":       enqueue,
+	"workers":       workers,
+	"prune-workers": pruneWorkers,
+	"concurrency":   concurrency,
+	"stats":         stats,
+	"failed":        failed,
+}
+
+// jsonOutput is set by the -json flag.
+var jsonOutput bool
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: goworker [-uri=redis://localhost:6379/] [-namespace=resque:] [-queue-backend=redis] [-json] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  queues")
+	fmt.Fprintln(os.Stderr, "  peek [-offset=0] [-limit=20] <queue>")
+	fmt.Fprintln(os.Stderr, "  enqueue [-dedupe] <queue> <class> [<json-args>]")
+	fmt.Fprintln(os.Stderr, "  workers")
+	fmt.Fprintln(os.Stderr, "  prune-workers")
+	fmt.Fprintln(os.Stderr, "  concurrency [-process=hostname:pid] <n>")
+	fmt.Fprintln(os.Stderr, "  stats")
+	fmt.Fprintln(os.Stderr, "  failed list|retry|remove|clear")
+	os.Exit(2)
+}
+
+// Prints v as indented JSON.
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func main() {
+	uri := flag.String("uri", "redis://localhost:6379/", "URI of the Redis database")
+	namespace := flag.String("namespace", "resque:", "namespace of the goworker keys, none by default with -queue-backend=sidekiq")
+	queueBackend := flag.String("queue-backend", "redis", "how the queues are stored: redis, streams or sidekiq")
+	flag.BoolVar(&jsonOutput, "json", false, "print JSON instead of tables")
+	flag.Usage = usage
+	flag.Parse()
+
+	options := map[string]string{
+		"uri":          *uri,
+		"queueBackend": *queueBackend,
+		"connections":  "1",
+	}
+	// The namespace defaults to the one of the queue
+	// backend unless set.
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "namespace" {
+			options["namespace"] = *namespace
+		}
+	})
+	goworker.Configure(options)
+
+	if flag.NArg() == 0 {
+		usage()
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+	}
+	if err := cmd(flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yudppp/goworker"
+)
+
+var errorNoQueue = errors.New("Give the name of the queue.")
+
+func queues(args []string) error {
+	queues, err := goworker.Queues()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		if queues == nil {
+			queues = []*goworker.Queue{}
+		}
+		return printJSON(queues)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "QUEUE\tSIZE\tOLDEST JOB")
+	for _, queue := range queues {
+		oldest := "-"
+		if queue.OldestEnqueuedAt != nil {
+			oldest = time.Since(*queue.OldestEnqueuedAt).Truncate(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", queue.Name, queue.Size, oldest)
+	}
+	return w.Flush()
+}
+
+func peek(args []string) error {
+	flags := flag.NewFlagSet("peek", flag.ExitOnError)
+	offset := flags.Int("offset", 0, "number of jobs to skip")
+	limit := flags.Int("limit", 20, "maximum number of jobs to list, 0 for all")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errorNoQueue
+	}
+
+	jobs, err := goworker.Peek(flags.Arg(0), *offset, *limit)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		if jobs == nil {
+			jobs = []*goworker.Job{}
+		}
+		return printJSON(jobs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tENQUEUED AT\tID\tCLASS\tARGS")
+	for i, job := range jobs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", *offset+i, formatTime(job.EnqueuedAt), job.ID, job.Class, formatArgs(job.Args))
+	}
+	return w.Flush()
+}
+
+func enqueue(args []string) error {
+	flags := flag.NewFlagSet("enqueue", flag.ExitOnError)
+	dedupe := flags.Bool("dedupe", false, "do not enqueue the job if the same one waits in the queue")
+	flags.Parse(args)
+	if flags.NArg() < 2 || flags.NArg() > 3 {
+		usage()
+	}
+
+	var jobArgs []interface{}
+	if flags.NArg() == 3 {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(flags.Arg(2))))
+		decoder.UseNumber()
+		if err := decoder.Decode(&jobArgs); err != nil {
+			return fmt.Errorf("The arguments must be a JSON array: %v", err)
+		}
+	}
+	if jobArgs == nil {
+		jobArgs = []interface{}{}
+	}
+
+	if err := goworker.Enqueue(flags.Arg(0), flags.Arg(1), jobArgs, *dedupe); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(map[string]interface{}{"queue": flags.Arg(0), "class": flags.Arg(1), "args": jobArgs})
+	}
+	fmt.Printf("Enqueued %s to %s\n", flags.Arg(1), flags.Arg(0))
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatArgs(args []interface{}) string {
+	buffer, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Sprint(args)
+	}
+	return string(buffer)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yudppp/goworker"
+)
+
+func workers(args []string) error {
+	workers, err := goworker.Workers()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		if workers == nil {
+			workers = []*goworker.Worker{}
+		}
+		return printJSON(workers)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKER\tPROCESSED\tFAILED\tQUEUE\tRUNNING FOR\tCLASS\tARGS")
+	for _, worker := range workers {
+		queue, runningFor, class, jobArgs := "-", "-", "-", "-"
+		if job := worker.Job; job != nil {
+			queue = job.Queue
+			runningFor = time.Since(job.RunAt).Truncate(time.Second).String()
+			class = job.Class
+			jobArgs = formatArgs(job.Args)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", worker.Name, worker.Processed, worker.Failed, queue, runningFor, class, jobArgs)
+	}
+	return w.Flush()
+}
+
+func pruneWorkers(args []string) error {
+	pruned, err := goworker.PruneWorkers()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		if pruned == nil {
+			pruned = []string{}
+		}
+		return printJSON(pruned)
+	}
+	for _, worker := range pruned {
+		fmt.Println(worker)
+	}
+	fmt.Printf("Pruned %d workers\n", len(pruned))
+	return nil
+}
+
+var errorNoConcurrency = errors.New("Give the concurrency, or 0 to remove it.")
+
+func concurrency(args []string) error {
+	flags := flag.NewFlagSet("concurrency", flag.ExitOnError)
+	process := flags.String("process", "", "hostname:pid of the process, every process if empty")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errorNoConcurrency
+	}
+	n, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return errorNoConcurrency
+	}
+
+	if err := goworker.SetRemoteConcurrency(*process, n); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(map[string]interface{}{"process": *process, "concurrency": n})
+	}
+	target := "every process"
+	if *process != "" {
+		target = *process
+	}
+	if n == 0 {
+		fmt.Printf("Removed the concurrency of %s\n", target)
+	} else {
+		fmt.Printf("Set the concurrency of %s to %d\n", target, n)
+	}
+	return nil
+}
+
+func stats(args []string) error {
+	info, err := goworker.ReadInfo()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(info)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "pending\t%d\n", info.Pending)
+	fmt.Fprintf(w, "processed\t%d\n", info.Processed)
+	fmt.Fprintf(w, "failed\t%d\n", info.Failed)
+	fmt.Fprintf(w, "queues\t%d\n", info.Queues)
+	fmt.Fprintf(w, "workers\t%d\n", info.Workers)
+	fmt.Fprintf(w, "working\t%d\n", info.Working)
+	fmt.Fprintf(w, "failures\t%d\n", info.Failures)
+	return w.Flush()
+}
@@ -0,0 +1,237 @@
+package goworker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Semaphores is implemented by the backends which limit
+// the number of jobs running at once across processes,
+// like the Redis backends. A slot of name is held by
+// token until ReleaseSlot or until its lease expires,
+// unless RenewSlot extends it.
+type Semaphores interface {
+	// AcquireSlot takes a slot of name for lease and
+	// returns true, unless limit slots are already held.
+	AcquireSlot(name, token string, limit int, lease time.Duration) (bool, error)
+	RenewSlot(name, token string, lease time.Duration) error
+	ReleaseSlot(name, token string) error
+}
+
+var (
+	errorInvalidConcurrencyLimit = errors.New("The concurrency limit must be a positive number, as in Class=3.")
+	errorNoSemaphores            = errors.New("The backend can not limit the concurrency across processes.")
+	errorInvalidConcurrencyLease = errors.New("The concurrency lease must be positive.")
+)
+
+// How long the poller waits, at most, for a concurrency
+// slot once every job it popped was deferred, before
+// popping them again.
+const deferredWait = time.Second
+
+// tokens counts the global slots and the rate tokens taken
+// by the process, to tell them apart.
+var tokens int64
+
+type concurrencyLimitsOption map[string]int
+
+// Parses limits like Class=3,Other=1.
+func (l *concurrencyLimitsOption) parse(value string) error {
+	limits := make(concurrencyLimitsOption)
+	for _, nameAndLimit := range strings.Split(value, ",") {
+		if nameAndLimit == "" {
+			continue
+		}
+		parts := strings.SplitN(nameAndLimit, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return errorInvalidConcurrencyLimit
+		}
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit <= 0 {
+			return errorInvalidConcurrencyLimit
+		}
+		limits[parts[0]] = limit
+	}
+	*l = limits
+	return nil
+}
+
+// limiter gives the jobs of the limited classes and queues
+// their slots, in the process and, with the
+// globalConcurrency option, across processes.
+type limiter struct {
+	classes map[string]int
+	queues  map[string]int
+
+	// local holds the slots in the process of each limited
+	// class and queue, a channel buffered to its limit
+	// which holds a value per slot taken.
+	local map[string]chan struct{}
+
+	// semaphores is nil unless the limits are global.
+	semaphores Semaphores
+	lease      time.Duration
+	prefix     string
+
+	// released receives when a slot is released in the
+	// process, to wake the poller up.
+	released chan struct{}
+}
+
+// Returns the limiter of the concurrency options for b, or
+// nil if no class or queue is limited.
+func newLimiter(b Backend) (*limiter, error) {
+	if len(cfg.classConcurrency) == 0 && len(cfg.queueConcurrency) == 0 {
+		return nil, nil
+	}
+	l := &limiter{
+		classes:  cfg.classConcurrency,
+		queues:   cfg.queueConcurrency,
+		local:    make(map[string]chan struct{}),
+		released: make(chan struct{}, 1),
+	}
+	for class, limit := range l.classes {
+		l.local["class:"+class] = make(chan struct{}, limit)
+	}
+	for queue, limit := range l.queues {
+		l.local["queue:"+queue] = make(chan struct{}, limit)
+	}
+
+	if cfg.globalConcurrency {
+		semaphores, ok := b.(Semaphores)
+		if !ok {
+			return nil, errorNoSemaphores
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.concurrencyLease <= 0 {
+			return nil, errorInvalidConcurrencyLease
+		}
+		l.semaphores = semaphores
+		l.lease = time.Duration(cfg.concurrencyLease)
+		l.prefix = fmt.Sprintf("%s:%d:", hostname, os.Getpid())
+	}
+	return l, nil
+}
+
+// Returns the names of the slots job needs.
+func (l *limiter) names(job *job) []string {
+	var names []string
+	if _, ok := l.classes[job.Payload.Class]; ok {
+		names = append(names, "class:"+job.Payload.Class)
+	}
+	if _, ok := l.queues[job.Queue]; ok {
+		names = append(names, "queue:"+job.Queue)
+	}
+	return names
+}
+
+func (l *limiter) limit(name string) int {
+	if strings.HasPrefix(name, "class:") {
+		return l.classes[strings.TrimPrefix(name, "class:")]
+	}
+	return l.queues[strings.TrimPrefix(name, "queue:")]
+}
+
+// Takes every slot job needs and returns them, or nil if
+// one is not free. The slots taken are then released.
+func (l *limiter) acquire(job *job) (*slots, error) {
+	s := &slots{limiter: l}
+	for _, name := range l.names(job) {
+		select {
+		case l.local[name] <- struct{}{}:
+		default:
+			s.free()
+			return nil, nil
+		}
+		s.local = append(s.local, name)
+
+		if l.semaphores == nil {
+			continue
+		}
+		token := l.prefix + strconv.FormatInt(atomic.AddInt64(&tokens, 1), 10)
+		ok, err := l.semaphores.AcquireSlot(name, token, l.limit(name), l.lease)
+		if err != nil || !ok {
+			s.free()
+			return nil, err
+		}
+		s.global = append(s.global, [2]string{name, token})
+	}
+	if len(s.global) > 0 {
+		s.stop = make(chan struct{})
+		go s.renew()
+	}
+	return s, nil
+}
+
+// slots are the slots held by a job until it finishes.
+type slots struct {
+	limiter *limiter
+	local   []string
+
+	// global holds the names and tokens of the slots
+	// taken across processes, renewed until stop is
+	// closed.
+	global [][2]string
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// Renews the leases of the global slots until they are
+// released, so that only the slots of crashed processes
+// expire.
+func (s *slots) renew() {
+	ticker := time.NewTicker(s.limiter.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			for _, slot := range s.global {
+				if err := s.limiter.semaphores.RenewSlot(slot[0], slot[1], s.limiter.lease); err != nil {
+					logger.Errorf("Error on renewing concurrency slot %s: %v", slot[0], err)
+				}
+			}
+		}
+	}
+}
+
+// Releases the slots of a job once it finished, or was
+// requeued, and wakes the poller up. It does nothing on nil
+// slots, as for the jobs which are not limited.
+func (s *slots) release() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.free()
+		select {
+		case s.limiter.released <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func (s *slots) free() {
+	if s.stop != nil {
+		close(s.stop)
+	}
+	for _, slot := range s.global {
+		if err := s.limiter.semaphores.ReleaseSlot(slot[0], slot[1]); err != nil {
+			logger.Errorf("Error on releasing concurrency slot %s: %v", slot[0], err)
+		}
+	}
+	for _, name := range s.local {
+		<-s.limiter.local[name]
+	}
+}
@@ -0,0 +1,236 @@
+package goworker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var concurrencyLimitsTests = []struct {
+	v        string
+	expected concurrencyLimitsOption
+	err      error
+}{
+	{"", concurrencyLimitsOption{}, nil},
+	{"VendorSync=3", concurrencyLimitsOption{"VendorSync": 3}, nil},
+	{"VendorSync=3,Report=1,", concurrencyLimitsOption{"VendorSync": 3, "Report": 1}, nil},
+	{"VendorSync", nil, errorInvalidConcurrencyLimit},
+	{"VendorSync=0", nil, errorInvalidConcurrencyLimit},
+	{"=3", nil, errorInvalidConcurrencyLimit},
+}
+
+func TestConcurrencyLimitsOptionParse(t *testing.T) {
+	for _, tt := range concurrencyLimitsTests {
+		var actual concurrencyLimitsOption
+		err := actual.parse(tt.v)
+		if err != tt.err {
+			t.Errorf("ConcurrencyLimits(%q): expected error %v, actual %v", tt.v, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("ConcurrencyLimits(%q): expected %v, actual %v", tt.v, tt.expected, actual)
+		}
+	}
+}
+
+var unlimitedConcurrency = map[string]string{
+	"classConcurrency":  "",
+	"queueConcurrency":  "",
+	"globalConcurrency": "false",
+}
+
+func testJob(queue, class string) *job {
+	return &job{Queue: queue, Payload: payload{Class: class}}
+}
+
+func TestLimiter(t *testing.T) {
+	Configure(map[string]string{"classConcurrency": "A=1", "queueConcurrency": "test_limited=2"})
+	defer Configure(unlimitedConcurrency)
+
+	l, err := newLimiter(NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := l.acquire(testJob("test", "A"))
+	if first == nil {
+		t.Fatal("expecting a slot for the first job of A")
+	}
+	if s, _ := l.acquire(testJob("test", "A")); s != nil {
+		t.Error("expecting no slot for a second job of A")
+	}
+	if s, _ := l.acquire(testJob("test", "B")); s == nil {
+		t.Error("expecting the jobs of B not to be limited")
+	}
+
+	// Both slots are needed, and neither is kept without
+	// the other.
+	l.acquire(testJob("test_limited", "B"))
+	if s, _ := l.acquire(testJob("test_limited", "A")); s != nil {
+		t.Error("expecting no slot for a job of A in test_limited")
+	}
+	if s, _ := l.acquire(testJob("test_limited", "B")); s == nil {
+		t.Error("expecting the second slot of test_limited to be free")
+	}
+
+	first.release()
+	first.release()
+	select {
+	case <-l.released:
+	default:
+		t.Error("expecting the release to wake the poller up")
+	}
+	if s, _ := l.acquire(testJob("test", "A")); s == nil {
+		t.Error("expecting a slot for A once released")
+	}
+	if s, _ := l.acquire(testJob("test", "A")); s != nil {
+		t.Error("expecting a single release of the slot of A")
+	}
+}
+
+func TestGlobalLimiter(t *testing.T) {
+	Configure(map[string]string{"classConcurrency": "A=1", "globalConcurrency": "true"})
+	defer Configure(unlimitedConcurrency)
+
+	if _, err := newLimiter(NewPostgresBackend("postgres://localhost/test")); err != errorNoSemaphores {
+		t.Errorf("expecting %v for a backend without semaphores, but got %v", errorNoSemaphores, err)
+	}
+
+	b := NewMemoryBackend()
+	l1, err := newLimiter(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, _ := newLimiter(b)
+
+	s, err := l1.acquire(testJob("test", "A"))
+	if err != nil || s == nil {
+		t.Fatalf("expecting a slot for A, but got %v", err)
+	}
+	if s, _ := l2.acquire(testJob("test", "A")); s != nil {
+		t.Error("expecting no slot for A in another process")
+	}
+	s.release()
+	if s, _ := l2.acquire(testJob("test", "A")); s == nil {
+		t.Error("expecting a slot for A in another process once released")
+	}
+}
+
+func TestSemaphores(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+
+	for name, b := range map[string]Semaphores{
+		"memory": NewMemoryBackend().(Semaphores),
+		"redis":  NewRedisBackend(p).(Semaphores),
+	} {
+		b.ReleaseSlot("test", "a")
+		b.ReleaseSlot("test", "b")
+
+		if ok, err := b.AcquireSlot("test", "a", 1, 50*time.Millisecond); err != nil || !ok {
+			t.Fatalf("%s: expecting a slot, but got %v", name, err)
+		}
+		if ok, _ := b.AcquireSlot("test", "a", 1, 50*time.Millisecond); !ok {
+			t.Errorf("%s: expecting the holder to keep its slot", name)
+		}
+		if ok, _ := b.AcquireSlot("test", "b", 1, time.Minute); ok {
+			t.Errorf("%s: expecting no slot over the limit", name)
+		}
+		if err := b.RenewSlot("test", "a", 200*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if ok, _ := b.AcquireSlot("test", "b", 1, time.Minute); ok {
+			t.Errorf("%s: expecting the renewed lease to hold", name)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if ok, _ := b.AcquireSlot("test", "b", 1, time.Minute); !ok {
+			t.Errorf("%s: expecting the slot to be free once its lease expired", name)
+		}
+		b.ReleaseSlot("test", "b")
+		if ok, _ := b.AcquireSlot("test", "a", 1, time.Minute); !ok {
+			t.Errorf("%s: expecting the slot to be free once released", name)
+		}
+		b.ReleaseSlot("test", "a")
+	}
+}
+
+func TestPollerDefersLimitedJobs(t *testing.T) {
+	Configure(map[string]string{"classConcurrency": "A=1"})
+	defer Configure(unlimitedConcurrency)
+
+	b := NewMemoryBackend()
+	for _, job := range []string{`{"class":"A","args":[1]}`, `{"class":"A","args":[2]}`, `{"class":"B","args":[]}`} {
+		b.Push("test_limited", []byte(job))
+	}
+	p, err := newPoller([]string{"test_limited"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.limiter, err = newLimiter(b); err != nil {
+		t.Fatal(err)
+	}
+
+	running, _ := p.getJob(b)
+	if running == nil || running.Payload.Class != "A" || running.slots == nil {
+		t.Fatalf("expecting the first job of A with its slot, but got %v", running)
+	}
+	for i, expected := range []string{"", "B", "", ""} {
+		p.deferring = false
+		job, err := p.getJob(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var class string
+		if job != nil {
+			class = job.Payload.Class
+		}
+		if class != expected || p.deferring != (job == nil) {
+			t.Errorf("%d: expecting job %q, but got %q", i, expected, class)
+		}
+	}
+	if !p.cycled {
+		t.Error("expecting the poller to wait once every job popped was deferred")
+	}
+	if jobs, _ := b.Peek("test_limited", 0, 0); len(jobs) != 1 || string(jobs[0]) != `{"class":"A","args":[2]}` {
+		t.Errorf("expecting the deferred job in the queue, but got %q", jobs)
+	}
+
+	running.slots.release()
+	if job, _ := p.getJob(b); job == nil || job.Payload.Class != "A" {
+		t.Errorf("expecting the deferred job once the slot is released, but got %v", job)
+	}
+}
+
+func TestPollerWaitsWhenOtherProcessesPopDeferredJobs(t *testing.T) {
+	Configure(map[string]string{"classConcurrency": "A=1"})
+	defer Configure(unlimitedConcurrency)
+
+	b := NewMemoryBackend()
+	for _, job := range []string{`{"class":"A","args":[1]}`, `{"class":"A","args":[2]}`, `{"class":"A","args":[3]}`} {
+		b.Push("test_limited", []byte(job))
+	}
+	p, err := newPoller([]string{"test_limited"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.limiter, err = newLimiter(b); err != nil {
+		t.Fatal(err)
+	}
+	if running, _ := p.getJob(b); running == nil {
+		t.Fatal("expecting the first job of A")
+	}
+
+	// The job deferred first is popped by another process,
+	// so that it is never popped again by this one.
+	p.getJob(b)
+	_, deferred, _ := b.Pop([]string{"test_limited"})
+	b.Push("test_limited", deferred)
+	if _, job, _ := b.Pop([]string{"test_limited"}); string(job) != `{"class":"A","args":[2]}` {
+		t.Fatalf("expecting the other process to pop the job deferred first, but got %q", job)
+	}
+	if p.cycled {
+		t.Fatal("expecting the poller not to wait before the queue was popped")
+	}
+	p.getJob(b)
+	if !p.cycled {
+		t.Error("expecting the poller to wait once as many jobs as queued were deferred")
+	}
+}
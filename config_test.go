@@ -187,3 +187,28 @@ func TestQueuesOptionString(t *testing.T) {
 		}
 	}
 }
+
+var namespaceTests = []struct {
+	options  map[string]string
+	expected string
+}{
+	{map[string]string{"queueBackend": "redis"}, "resque:"},
+	{map[string]string{"queueBackend": "sidekiq"}, ""},
+	{map[string]string{"queueBackend": "sidekiq", "namespace": "myapp:"}, "myapp:"},
+	{map[string]string{"queueBackend": "redis", "namespace": ""}, ""},
+}
+
+func TestNamespace(t *testing.T) {
+	defer func() {
+		cfg.namespaceSet = false
+		Configure(map[string]string{"queueBackend": "redis"})
+	}()
+
+	for _, tt := range namespaceTests {
+		cfg.namespaceSet = false
+		Configure(tt.options)
+		if cfg.namespace != tt.expected {
+			t.Errorf("Namespace: %v expected %q, actual %q", tt.options, tt.expected, cfg.namespace)
+		}
+	}
+}
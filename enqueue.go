@@ -1,11 +1,10 @@
 package goworker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-
-	"github.com/garyburd/redigo/redis"
-	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+	"time"
 )
 
 // EnqueueUnique function let you asynchronously enqueue a new job in Resque given
@@ -16,75 +15,79 @@ import (
 // param args:  arguments to pass to the handler function. Must be the non-marshalled version.
 //
 // return an error if args cannot be marshalled
-func enqueue(p *pools.ResourcePool, queue string, class string, args []interface{}, dedupe bool) (err error) {
+func enqueue(ctx context.Context, b Backend, queue string, class string, args []interface{}, dedupe bool) (err error) {
 
 	if dedupe {
 
-		if isJobUnique(p, queue, class, args) {
-			err = addToQueue(p, queue, class, args)
+		var unique bool
+		if unique, err = isJobUnique(ctx, b, queue, class, args); err != nil {
+			return
+		}
+		if unique {
+			err = addToQueue(ctx, b, queue, class, args)
 		} else {
 			logger.Infof("not enqueueing duplicate msg in queue %s | class: %s | args: %v", queue, class, args)
 		}
 	} else {
-		err = addToQueue(p, queue, class, args)
+		err = addToQueue(ctx, b, queue, class, args)
 	}
 
 	return
 }
 
-func addToQueue(p *pools.ResourcePool, queue string, class string, args []interface{}) (err error) {
-
-	var conn *redisConn
-
-	resource, err := p.Get()
-	if err != nil {
-		logger.Criticalf("Error on getting connection to enqueue job: %v", err)
-	} else {
-		conn = resource.(*redisConn)
-		defer p.Put(conn)
-	}
-
-	data := &payload{
-		Class: class,
-		Args:  args,
-	}
+func addToQueue(ctx context.Context, b Backend, queue string, class string, args []interface{}) (err error) {
 
-	b, err := json.Marshal(data)
+	buffer, err := newJob(ctx, class, args)
 	if err != nil {
 		return
 	}
 
-	// Push job in redis
-	_, err = conn.Do("RPUSH", fmt.Sprintf("%squeue:%s", cfg.namespace, queue), b)
+	// Push job in the backend
+	err = retry(ctx.Done(), func() error {
+		return b.Push(queue, buffer)
+	})
 	if err != nil {
-		return
+		logger.With("queue", queue).With("class", class).Errorf("Error on enqueueing job: %v", err)
 	}
 
 	return
 
 }
 
-func isJobUnique(p *pools.ResourcePool, queue string, class string, args []interface{}) bool {
+// Returns the JSON of a new job of class with args, with
+// the trace context of ctx.
+func newJob(ctx context.Context, class string, args []interface{}) ([]byte, error) {
+	data := &payload{
+		Class: class,
+		Args:  args,
+		ID:    newJobID(),
+		Trace: injectTrace(ctx),
 
-	var conn *redisConn
+		EnqueuedAt: float64(time.Now().UnixNano()) / float64(time.Second),
+	}
+	return json.Marshal(data)
+}
 
-	isUnique := true
+func isJobUnique(ctx context.Context, b Backend, queue string, class string, args []interface{}) (bool, error) {
+
+	var messages [][]byte
 
-	resource, err := p.Get()
+	// Check if job already exists in the backend
+	err := retry(ctx.Done(), func() (err error) {
+		messages, err = b.Peek(queue, 0, 0)
+		return err
+	})
 	if err != nil {
-		logger.Criticalf("Error on getting connection to check if job is already in queue: %v", err)
-	} else {
-		conn = resource.(*redisConn)
-		defer p.Put(conn)
+		logger.Errorf("Error on checking if job is already in queue: %v", err)
+		return false, err
 	}
 
-	// Check if job already exists in Redis
-	messages, _ := redis.Strings(conn.Do("lrange", fmt.Sprintf("%squeue:%s", cfg.namespace, queue), 0, -1))
+	isUnique := true
 
 	for _, msg := range messages {
 
 		var result map[string]interface{}
-		json.Unmarshal([]byte(msg), &result)
+		json.Unmarshal(msg, &result)
 
 		resultClass := result["class"]
 		resultArgs := fmt.Sprintf("%v", result["args"].(interface{}))
@@ -96,5 +99,5 @@ func isJobUnique(p *pools.ResourcePool, queue string, class string, args []inter
 		}
 	}
 
-	return isUnique
+	return isUnique, nil
 }
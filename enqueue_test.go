@@ -93,3 +93,31 @@ func TestEnqueueUniqueWriteToRedis(t *testing.T) {
 		t.Errorf("expecting 1 job in queue, but got %d jobs", res)
 	}
 }
+
+func TestEnqueueKeepsBackendOfWork(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+
+	cfg.exitOnComplete = true
+	cfg.queues = []string{"test_enqueue_keeps_backend"}
+	var pingErr error
+	Register("KeepsBackend", func(q string, args ...interface{}) error {
+		// Enqueue closes the backend it opens for the job.
+		Enqueue("dummy", "Dummy", nil, false)
+		pingErr = currentBackend.Ping()
+		return nil
+	})
+
+	EnqueueWithPool(p, "test_enqueue_keeps_backend", "KeepsBackend", nil, false)
+	if err := WorkWithPool(p); err != nil {
+		t.Fatal(err)
+	}
+	if pingErr != nil {
+		t.Errorf("expecting the backend of Work to be usable, but got %v", pingErr)
+	}
+
+	resource, _ := p.Get()
+	conn := resource.(*redisConn)
+	defer p.Put(conn)
+	conn.Do("DEL", fmt.Sprintf("%squeue:dummy", cfg.namespace))
+}
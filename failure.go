@@ -1,15 +1,155 @@
 package goworker
 
 import (
+	"bytes"
+	"encoding/json"
 	"time"
 )
 
+// Layouts of the timestamps written by Ruby Resque. The
+// failed_at of Resque includes the zone, retried_at is
+// written in local time without it.
+const (
+	resqueFailedAtLayout  = "2006/01/02 15:04:05 MST"
+	resqueRetriedAtLayout = "2006/01/02 15:04:05"
+)
+
 type failure struct {
-	FailedAt  time.Time `json:"failed_at"`
-	Payload   payload   `json:"payload"`
-	Exception string    `json:"exception"`
-	Error     string    `json:"error"`
-	Backtrace []string  `json:"backtrace"`
-	Worker    *worker   `json:"worker"`
-	Queue     string    `json:"queue"`
+	FailedAt  time.Time       `json:"failed_at"`
+	Payload   json.RawMessage `json:"payload"`
+	Exception string          `json:"exception"`
+	Error     string          `json:"error"`
+	Backtrace []string        `json:"backtrace"`
+	Worker    string          `json:"worker"`
+	Queue     string          `json:"queue"`
+	RetriedAt string          `json:"retried_at,omitempty"`
+	Sample    string          `json:"sample,omitempty"`
+}
+
+// Failure is a job that failed. It is handed to the
+// failure backend when a job fails, and read back from the
+// failed lists. Failures written by both goworker and Ruby
+// Resque can be read.
+type Failure struct {
+	// Index is the position of the failure in the failed
+	// list it was read from.
+	Index int
+
+	FailedAt  time.Time
+	RetriedAt time.Time
+	Queue     string
+	Class     string
+	Args      []interface{}
+	Exception string
+	Error     string
+	Backtrace []string
+	Worker    string
+
+	// Occurrences is the number of identical failures
+	// aggregated into this one when failures are sampled,
+	// including itself, until its sampling window ends.
+	Occurrences int
+
+	// The id of the failure when failures are sampled.
+	sample string
+
+	// The failed list and the failure as stored, used to
+	// detect that the entry at Index changed before
+	// modifying it.
+	key string
+	raw []byte
+
+	// The job which failed as it was popped, for the
+	// backends which store the failure with the job.
+	job []byte
+}
+
+func (f *Failure) MarshalJSON() ([]byte, error) {
+	stored, err := f.storedPayload()
+	if err != nil {
+		return nil, err
+	}
+	record := &failure{
+		FailedAt:  f.FailedAt,
+		Payload:   stored,
+		Exception: f.Exception,
+		Error:     f.Error,
+		Backtrace: f.Backtrace,
+		Worker:    f.Worker,
+		Queue:     f.Queue,
+		Sample:    f.sample,
+	}
+	if !f.RetriedAt.IsZero() {
+		record.RetriedAt = f.RetriedAt.Format(resqueRetriedAtLayout)
+	}
+	return json.Marshal(record)
+}
+
+// Returns the payload of the failure as stored: the job as
+// popped, as Resque stores it, so that its retry keeps its
+// id, trace and attempt, or else its class and arguments,
+// as for the jobs which could not be decoded.
+func (f *Failure) storedPayload() (json.RawMessage, error) {
+	if json.Valid(f.job) && bytes.HasPrefix(bytes.TrimSpace(f.job), []byte("{")) {
+		return f.job, nil
+	}
+	return json.Marshal(payload{Class: f.Class, Args: f.Args})
+}
+
+func (f *Failure) UnmarshalJSON(data []byte) error {
+	var record struct {
+		FailedAt  string   `json:"failed_at"`
+		RetriedAt string   `json:"retried_at"`
+		Payload   payload  `json:"payload"`
+		Exception string   `json:"exception"`
+		Error     string   `json:"error"`
+		Backtrace []string `json:"backtrace"`
+		Worker    string   `json:"worker"`
+		Queue     string   `json:"queue"`
+		Sample    string   `json:"sample"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&record); err != nil {
+		return err
+	}
+
+	failedAt, err := parseFailureTime(record.FailedAt)
+	if err != nil {
+		return err
+	}
+	retriedAt, err := parseFailureTime(record.RetriedAt)
+	if err != nil {
+		return err
+	}
+
+	*f = Failure{
+		FailedAt:    failedAt,
+		RetriedAt:   retriedAt,
+		Queue:       record.Queue,
+		Class:       record.Payload.Class,
+		Args:        record.Payload.Args,
+		Exception:   record.Exception,
+		Error:       record.Error,
+		Backtrace:   record.Backtrace,
+		Worker:      record.Worker,
+		Occurrences: 1,
+		sample:      record.Sample,
+	}
+	return nil
+}
+
+func parseFailureTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(resqueFailedAtLayout, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(resqueRetriedAtLayout, value, time.Local)
 }
@@ -0,0 +1,160 @@
+package goworker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+var (
+	errorInvalidFailureBackend = errors.New("Invalid failure backend.")
+	errorNoRedisPool           = errors.New("The failure backend needs Redis.")
+)
+
+// FailureBackend stores the failures of jobs. Save is
+// called once for every failed job, from the goroutine of
+// the worker which ran it.
+type FailureBackend interface {
+	Save(failure *Failure) error
+}
+
+// SetFailureBackend replaces the backend failures are
+// saved to, for backends which can not be chosen with the
+// failureBackend option. It must be called before Work.
+func SetFailureBackend(backend FailureBackend) {
+	cfg.failureBackend = backend
+}
+
+type redisFailureBackend struct {
+	pool *pools.ResourcePool
+}
+
+// NewRedisFailureBackend returns the default backend,
+// which pushes failures to the failed list like the Redis
+// failure backend of Resque. A nil pool saves failures to
+// the backend of Work, whichever it is.
+func NewRedisFailureBackend(pool *pools.ResourcePool) FailureBackend {
+	return &redisFailureBackend{pool: pool}
+}
+
+func (b *redisFailureBackend) Save(failure *Failure) error {
+	if b.pool == nil {
+		return retry(nil, func() error {
+			return currentBackend.SaveFailure(failure)
+		})
+	}
+	return withFailureConn(b.pool, func(conn *redisConn) error {
+		return pushFailure(conn, fmt.Sprintf("%sfailed", cfg.namespace), failure)
+	})
+}
+
+type redisMultiQueueFailureBackend struct {
+	pool *pools.ResourcePool
+}
+
+// NewRedisMultiQueueFailureBackend returns a backend
+// compatible with the RedisMultiQueue failure backend of
+// Resque, which pushes failures to a <queue>_failed list
+// per queue and tracks those lists in the failed_queues
+// set. A nil pool uses the pool of Work.
+func NewRedisMultiQueueFailureBackend(pool *pools.ResourcePool) FailureBackend {
+	return &redisMultiQueueFailureBackend{pool: pool}
+}
+
+func (b *redisMultiQueueFailureBackend) Save(failure *Failure) error {
+	return withFailureConn(b.pool, func(conn *redisConn) error {
+		queue := fmt.Sprintf("%s_failed", failure.Queue)
+		if _, err := conn.Do("SADD", fmt.Sprintf("%sfailed_queues", cfg.namespace), queue); err != nil {
+			return err
+		}
+		return pushFailure(conn, fmt.Sprintf("%s%s", cfg.namespace, queue), failure)
+	})
+}
+
+func withFailureConn(p *pools.ResourcePool, fn func(conn *redisConn) error) error {
+	if p == nil {
+		p = pool
+	}
+	if p == nil {
+		return errorNoRedisPool
+	}
+	return withRetry(p, nil, fn)
+}
+
+type fileFailureBackend struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileFailureBackend returns a backend which appends
+// failures to the file at path, one JSON object per line.
+func NewFileFailureBackend(path string) FailureBackend {
+	return &fileFailureBackend{path: path}
+}
+
+func (b *fileFailureBackend) Save(failure *Failure) error {
+	buffer, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(buffer, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+type multipleFailureBackend []FailureBackend
+
+// NewMultipleFailureBackend returns a backend which saves
+// failures to every given backend, like the Multiple
+// failure backend of Resque. Every backend is tried even
+// if some fail, and the first error is returned.
+func NewMultipleFailureBackend(backends ...FailureBackend) FailureBackend {
+	return multipleFailureBackend(backends)
+}
+
+func (b multipleFailureBackend) Save(failure *Failure) error {
+	var first error
+	for _, backend := range b {
+		if err := backend.Save(failure); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Parses the failureBackend option, a comma separated list
+// of redis, redis_multi_queue and file:<path>.
+func parseFailureBackend(value string) (FailureBackend, error) {
+	var backends []FailureBackend
+	for _, name := range strings.Split(value, ",") {
+		switch {
+		case name == "redis":
+			backends = append(backends, NewRedisFailureBackend(nil))
+		case name == "redis_multi_queue":
+			backends = append(backends, NewRedisMultiQueueFailureBackend(nil))
+		case strings.HasPrefix(name, "file:") && len(name) > len("file:"):
+			backends = append(backends, NewFileFailureBackend(name[len("file:"):]))
+		default:
+			return nil, errorInvalidFailureBackend
+		}
+	}
+	if len(backends) == 1 {
+		return backends[0], nil
+	}
+	return NewMultipleFailureBackend(backends...), nil
+}
@@ -0,0 +1,140 @@
+package goworker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var parseFailureBackendTests = []struct {
+	v        string
+	expected FailureBackend
+	err      error
+}{
+	{
+		"redis",
+		&redisFailureBackend{},
+		nil,
+	},
+	{
+		"redis_multi_queue",
+		&redisMultiQueueFailureBackend{},
+		nil,
+	},
+	{
+		"redis,file:/tmp/failures.jsonl",
+		multipleFailureBackend{&redisFailureBackend{}, &fileFailureBackend{path: "/tmp/failures.jsonl"}},
+		nil,
+	},
+	{
+		"file:",
+		nil,
+		errors.New("Invalid failure backend."),
+	},
+	{
+		"",
+		nil,
+		errors.New("Invalid failure backend."),
+	},
+}
+
+func TestParseFailureBackend(t *testing.T) {
+	for _, tt := range parseFailureBackendTests {
+		actual, err := parseFailureBackend(tt.v)
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("FailureBackend: parse %s expected %#v, actual %#v", tt.v, tt.expected, actual)
+		}
+		if fmt.Sprint(err) != fmt.Sprint(tt.err) {
+			t.Errorf("FailureBackend: parse %s expected err %v, actual err %v", tt.v, tt.err, err)
+		}
+	}
+}
+
+type failingFailureBackend struct {
+	saved int
+}
+
+func (b *failingFailureBackend) Save(failure *Failure) error {
+	b.saved++
+	return errors.New("failing")
+}
+
+func TestMultipleFailureBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "failures.jsonl")
+	failing := &failingFailureBackend{}
+	backend := NewMultipleFailureBackend(failing, NewFileFailureBackend(path))
+
+	failure := &Failure{
+		FailedAt:  time.Date(2015, 3, 1, 18, 0, 6, 0, time.UTC),
+		Queue:     "high",
+		Class:     "MyClass",
+		Args:      []interface{}{"hi"},
+		Exception: "errors.errorString",
+		Error:     "boom",
+		Worker:    "hostname:12345-1:high",
+	}
+	for i := 0; i < 2; i++ {
+		if err := backend.Save(failure); err == nil || err.Error() != "failing" {
+			t.Errorf("expecting the first error, but got %v", err)
+		}
+	}
+	if failing.saved != 2 {
+		t.Errorf("expecting 2 saves, but got %d", failing.saved)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expecting 2 lines, but got %d", len(lines))
+	}
+	expected := `{"failed_at":"2015-03-01T18:00:06Z","payload":{"class":"MyClass","args":["hi"]},"exception":"errors.errorString","error":"boom","backtrace":null,"worker":"hostname:12345-1:high","queue":"high"}`
+	if lines[0] != expected {
+		t.Errorf("expecting %s, but got %s", expected, lines[0])
+	}
+}
+
+func TestRedisMultiQueueFailureBackend(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+
+	failure := &Failure{
+		FailedAt: time.Now(),
+		Queue:    "test_multi_queue",
+		Class:    "TestRedisMultiQueueFailureBackend",
+		Args:     []interface{}{},
+	}
+	if err := NewRedisMultiQueueFailureBackend(p).Save(failure); err != nil {
+		t.Fatal(err)
+	}
+	defer ClearFailuresWithPool(p)
+
+	failures, err := FailuresWithPool(p, 0, 0, &FailureFilter{Class: "TestRedisMultiQueueFailureBackend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].key != fmt.Sprintf("%stest_multi_queue_failed", cfg.namespace) {
+		t.Fatalf("expecting the failure in the queue failed list, but got %v", failures)
+	}
+
+	var record map[string]interface{}
+	json.Unmarshal(failures[0].raw, &record)
+	if record["queue"] != "test_multi_queue" {
+		t.Errorf("expecting queue test_multi_queue, but got %v", record["queue"])
+	}
+}
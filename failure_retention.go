@@ -0,0 +1,249 @@
+package goworker
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+// Period of the sweeper pruning failures older than the
+// failureMaxAge option.
+const failureSweepInterval = time.Minute
+
+// Number of failures read at once when pruning them.
+const failurePruneBatch = 100
+
+// Pushes a failure to a failed list, applying the sampling
+// and the maximum count of the retention options.
+//
+// When sampling, the sample key of identical failures holds
+// the id of the failure stored for the current window and
+// the number of failures aggregated into it, and expires
+// with the window. A failure is stored again if the stored
+// one was removed in the meantime, or if the failures were
+// cleared since, which increments the generation key.
+//
+// KEYS: failed list, sample key, generation key, which are
+// the failed list again when not sampling so that every key
+// can share the slot of a cluster
+// ARGV: failure, maximum count, sample id, window seconds,
+// prefix of the sample keys
+var pushFailureScript = redis.NewScript(3, `
+if ARGV[3] ~= "" then
+	local generation = redis.call("GET", KEYS[3]) or "0"
+	local sample = redis.call("HMGET", KEYS[2], "id", "generation")
+	if sample[1] and sample[2] == generation then
+		redis.call("HINCRBY", KEYS[2], "count", 1)
+		return 0
+	end
+	redis.call("DEL", KEYS[2])
+	redis.call("HMSET", KEYS[2], "id", ARGV[3], "count", 1, "generation", generation)
+	redis.call("EXPIRE", KEYS[2], ARGV[4])
+end
+redis.call("RPUSH", KEYS[1], ARGV[1])
+local max = tonumber(ARGV[2])
+if max > 0 then
+	local excess = redis.call("LLEN", KEYS[1]) - max
+	if excess > 0 then
+		local trimmed = redis.call("LRANGE", KEYS[1], 0, excess - 1)
+		redis.call("LTRIM", KEYS[1], excess, -1)
+		for _, entry in ipairs(trimmed) do
+			local ok, failure = pcall(cjson.decode, entry)
+			if ok and type(failure) == "table" and type(failure["sample"]) == "string" then
+				local key = ARGV[5] .. string.match(failure["sample"], "^[^:]*")
+				if redis.call("HGET", key, "id") == failure["sample"] then
+					redis.call("DEL", key)
+				end
+			end
+		end
+	end
+end
+return 1
+`)
+
+// Removes the sample key of a failure being removed, unless
+// it already holds a later failure.
+//
+// KEYS: sample key
+// ARGV: sample id
+var removeSampleScript = redis.NewScript(1, `
+if redis.call("HGET", KEYS[1], "id") == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Pops the given failures from the head of a failed list,
+// with their sample keys, as long as the head holds them.
+// The head differs once another process pruned or removed
+// some of them.
+//
+// KEYS: failed list
+// ARGV: prefix of the sample keys, failures
+var pruneFailuresScript = redis.NewScript(1, `
+local pruned = 0
+for i = 2, #ARGV do
+	if redis.call("LINDEX", KEYS[1], 0) ~= ARGV[i] then
+		break
+	end
+	redis.call("LPOP", KEYS[1])
+	pruned = pruned + 1
+	local ok, failure = pcall(cjson.decode, ARGV[i])
+	if ok and type(failure) == "table" and type(failure["sample"]) == "string" then
+		local key = ARGV[1] .. string.match(failure["sample"], "^[^:]*")
+		if redis.call("HGET", key, "id") == failure["sample"] then
+			redis.call("DEL", key)
+		end
+	end
+end
+return pruned
+`)
+
+func pushFailure(conn *redisConn, key string, failure *Failure) error {
+	if cfg.failureMaxCount <= 0 && cfg.failureSampleWindow <= 0 {
+		buffer, err := json.Marshal(failure)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Do("RPUSH", key, buffer)
+		return err
+	}
+
+	sampleKey, generationKey, window := key, key, ""
+	if cfg.failureSampleWindow > 0 {
+		signature := failureSignature(failure)
+
+		// The failure is copied as the same failure may be
+		// saved by other backends.
+		sampled := *failure
+		sampled.sample = fmt.Sprintf("%s:%d", signature, failure.FailedAt.UnixNano())
+		failure = &sampled
+
+		sampleKey = failureSampleKey(failure.sample)
+		generationKey = fmt.Sprintf("%sfailed_generation", cfg.namespace)
+		window = strconv.FormatInt(int64((time.Duration(cfg.failureSampleWindow)+time.Second-1)/time.Second), 10)
+	}
+
+	buffer, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+	_, err = pushFailureScript.Do(conn.Conn, key, sampleKey, generationKey,
+		buffer, cfg.failureMaxCount, failure.sample, window, failureSampleKey(""))
+	return err
+}
+
+// Identical failures have the same queue, class, exception
+// and error message.
+func failureSignature(failure *Failure) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s", failure.Queue, failure.Class, failure.Exception, failure.Error)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Returns the sample key of the failures sampled under the
+// sample id, whose signature comes first.
+func failureSampleKey(sample string) string {
+	return fmt.Sprintf("%sfailed_sample:%s", cfg.namespace, strings.SplitN(sample, ":", 2)[0])
+}
+
+// Sends the command removing the sample key of failure, if
+// it was sampled.
+func sendRemoveSample(conn *redisConn, failure *Failure) {
+	if failure.sample != "" {
+		removeSampleScript.Send(conn.Conn, failureSampleKey(failure.sample), failure.sample)
+	}
+}
+
+// Starts the sweeper pruning failures older than the
+// failureMaxAge option, if set. The sweeper stops when
+// done is closed.
+func startFailureSweeper(b Backend, done <-chan struct{}) {
+	if cfg.failureMaxAge <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(failureSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			pruned, err := b.PruneFailures(time.Now().Add(-time.Duration(cfg.failureMaxAge)))
+			if err != nil {
+				logger.Errorf("Error on pruning failures: %v", err)
+			} else if pruned > 0 {
+				logger.Infof("Pruned %d failures older than %v", pruned, time.Duration(cfg.failureMaxAge))
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Removes the failures which failed before cutoff from the
+// head of every failed list, and returns their number. The
+// failures are read by batches of failurePruneBatch, and
+// the old ones at the head of a batch are removed at once.
+func pruneFailures(p *pools.ResourcePool, cutoff time.Time) (int, error) {
+	resource, err := p.Get()
+	if err != nil {
+		return 0, err
+	}
+	conn := resource.(*redisConn)
+	defer p.Put(conn)
+
+	keys, err := failedKeys(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, key := range keys {
+		for {
+			entries, err := redis.ByteSlices(conn.Do("LRANGE", key, 0, failurePruneBatch-1))
+			if err != nil {
+				return pruned, err
+			}
+
+			args := []interface{}{key, failureSampleKey("")}
+			for _, entry := range entries {
+				failure := &Failure{}
+				if err := json.Unmarshal(entry, failure); err != nil {
+					logger.Warnf("Stopping pruning of %s at unreadable failure: %v", key, err)
+					break
+				}
+				if !failure.FailedAt.Before(cutoff) {
+					break
+				}
+				args = append(args, entry)
+			}
+			if len(args) == 2 {
+				break
+			}
+
+			removed, err := redis.Int(pruneFailuresScript.Do(conn.Conn, args...))
+			if err != nil {
+				return pruned, err
+			}
+			pruned += removed
+
+			// The next batch is read only if this one was
+			// all old and removed.
+			if removed < len(args)-2 || len(entries) < failurePruneBatch {
+				break
+			}
+		}
+	}
+	return pruned, nil
+}
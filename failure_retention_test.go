@@ -0,0 +1,150 @@
+package goworker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func saveTestFailures(t *testing.T, backend FailureBackend, failedAt time.Time, errors ...string) {
+	for _, e := range errors {
+		failure := &Failure{
+			FailedAt:  failedAt,
+			Queue:     "test_retention",
+			Class:     "TestRetention",
+			Args:      []interface{}{},
+			Exception: "errors.errorString",
+			Error:     e,
+		}
+		if err := backend.Save(failure); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFailureMaxCount(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	defer ClearFailuresWithPool(p)
+
+	cfg.failureMaxCount = 2
+	defer func() { cfg.failureMaxCount = 0 }()
+
+	saveTestFailures(t, NewRedisFailureBackend(p), time.Now(), "first", "second", "third")
+
+	failures, err := FailuresWithPool(p, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 || failures[0].Error != "second" || failures[1].Error != "third" {
+		t.Errorf("expecting the second and third failures, but got %v", failures)
+	}
+}
+
+func TestFailureSampling(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	defer ClearFailuresWithPool(p)
+
+	cfg.failureSampleWindow = intervalOption(time.Minute)
+	defer func() { cfg.failureSampleWindow = 0 }()
+	defer func() {
+		resource, _ := p.Get()
+		conn := resource.(*redisConn)
+		defer p.Put(conn)
+		for _, queue := range []string{"test_retention", "test_retention_other"} {
+			for _, e := range []string{"storm", "other"} {
+				conn.Do("DEL", fmt.Sprintf("%sfailed_sample:%s", cfg.namespace, failureSignature(&Failure{
+					Queue:     queue,
+					Class:     "TestRetention",
+					Exception: "errors.errorString",
+					Error:     e,
+				})))
+			}
+		}
+	}()
+
+	backend := NewRedisFailureBackend(p)
+	saveTestFailures(t, backend, time.Now(), "storm", "storm", "other", "storm")
+	if err := backend.Save(&Failure{
+		FailedAt:  time.Now(),
+		Queue:     "test_retention_other",
+		Class:     "TestRetention",
+		Exception: "errors.errorString",
+		Error:     "storm",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	failures, err := FailuresWithPool(p, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 3 {
+		t.Fatalf("expecting 3 failures, but got %d", len(failures))
+	}
+	if failures[2].Queue != "test_retention_other" || failures[2].Occurrences != 1 {
+		t.Errorf("expecting the failure of the other queue to be stored, but got %d of %s", failures[2].Occurrences, failures[2].Queue)
+	}
+	if failures[0].Error != "storm" || failures[0].Occurrences != 3 {
+		t.Errorf("expecting 3 occurrences of storm, but got %d of %s", failures[0].Occurrences, failures[0].Error)
+	}
+	if failures[1].Error != "other" || failures[1].Occurrences != 1 {
+		t.Errorf("expecting 1 occurrence of other, but got %d of %s", failures[1].Occurrences, failures[1].Error)
+	}
+
+	// Once the stored failure is removed, the next identical
+	// failure is stored again.
+	if err := RemoveFailureWithPool(p, failures[0]); err != nil {
+		t.Fatal(err)
+	}
+	saveTestFailures(t, backend, time.Now(), "storm")
+	count, err := FailureCountWithPool(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expecting 3 failures, but got %d", count)
+	}
+
+	// So it is once the failures are cleared.
+	if err := ClearFailuresWithPool(p); err != nil {
+		t.Fatal(err)
+	}
+	saveTestFailures(t, backend, time.Now(), "storm")
+	if count, _ := FailureCountWithPool(p); count != 1 {
+		t.Errorf("expecting 1 failure, but got %d", count)
+	}
+}
+
+func TestPruneFailures(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	defer ClearFailuresWithPool(p)
+
+	// The old failures span more than one batch.
+	old := make([]string, failurePruneBatch+1)
+	for i := range old {
+		old[i] = "old"
+	}
+	now := time.Now()
+	saveTestFailures(t, NewRedisFailureBackend(p), now.Add(-2*time.Hour), old...)
+	saveTestFailures(t, NewRedisMultiQueueFailureBackend(p), now.Add(-2*time.Hour), "old")
+	saveTestFailures(t, NewRedisFailureBackend(p), now, "new")
+
+	pruned, err := pruneFailures(p, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != failurePruneBatch+2 {
+		t.Errorf("expecting %d pruned failures, but got %d", failurePruneBatch+2, pruned)
+	}
+
+	failures, err := FailuresWithPool(p, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].Error != "new" {
+		t.Errorf("expecting only the new failure, but got %v", failures)
+	}
+}
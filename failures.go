@@ -0,0 +1,410 @@
+package goworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+// Number of failures read from Redis at a time while
+// paging through the failed lists.
+const failuresBatchSize = 100
+
+var (
+	errorFailureChanged = errors.New("The failure was changed or removed since it was read.")
+)
+
+// FailureFilter selects failures by their fields. Empty
+// fields match every failure. Since is inclusive and Until
+// is exclusive.
+type FailureFilter struct {
+	Class     string
+	Queue     string
+	Exception string
+	Since     time.Time
+	Until     time.Time
+}
+
+// Returns whether the filter matches every failure.
+func (f *FailureFilter) empty() bool {
+	return f == nil || *f == FailureFilter{}
+}
+
+func (f *FailureFilter) match(failure *Failure) bool {
+	if f == nil {
+		return true
+	}
+	if f.Class != "" && f.Class != failure.Class {
+		return false
+	}
+	if f.Queue != "" && f.Queue != failure.Queue {
+		return false
+	}
+	if f.Exception != "" && f.Exception != failure.Exception {
+		return false
+	}
+	if !f.Since.IsZero() && failure.FailedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !failure.FailedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Failures returns up to limit failures matching filter,
+// skipping the first offset matches. A limit of zero
+// returns every match. A nil filter matches every failure.
+func Failures(offset, limit int, filter *FailureFilter) (failures []*Failure, err error) {
+	err = withBackend(func(b Backend) error {
+		failures, err = b.Failures(offset, limit, filter)
+		return err
+	})
+	return
+}
+
+func FailuresWithPool(p *pools.ResourcePool, offset, limit int, filter *FailureFilter) ([]*Failure, error) {
+	return collectFailures(func(fn func(*Failure) bool) error {
+		return eachFailure(p, fn)
+	}, offset, limit, filter)
+}
+
+// Returns the failures passed by each to its function,
+// like Backend.Failures.
+func collectFailures(each func(fn func(*Failure) bool) error, offset, limit int, filter *FailureFilter) ([]*Failure, error) {
+	var failures []*Failure
+	err := each(func(failure *Failure) bool {
+		if !filter.match(failure) {
+			return true
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		failures = append(failures, failure)
+		return limit <= 0 || len(failures) < limit
+	})
+	return failures, err
+}
+
+// FailureCount returns the number of failures in the
+// failed lists.
+func FailureCount() (count int, err error) {
+	err = withBackend(func(b Backend) error {
+		count, err = b.FailureCount()
+		return err
+	})
+	return
+}
+
+func FailureCountWithPool(p *pools.ResourcePool) (int, error) {
+	resource, err := p.Get()
+	if err != nil {
+		return 0, err
+	}
+	conn := resource.(*redisConn)
+	defer p.Put(conn)
+
+	keys, err := failedKeys(conn)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		conn.Send("LLEN", key)
+	}
+	counts, err := redis.Ints(conn.Do(""))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, c := range counts {
+		count += c
+	}
+	return count, nil
+}
+
+// RetryFailure pushes the job of a failure returned by
+// Failures back onto its original queue and sets the
+// retried_at of the failure, like Resque does. The failure
+// is kept in its failed list.
+func RetryFailure(failure *Failure) error {
+	return withBackend(func(b Backend) error {
+		return b.RetryFailure(failure)
+	})
+}
+
+func RetryFailureWithPool(p *pools.ResourcePool, failure *Failure) error {
+	resource, err := p.Get()
+	if err != nil {
+		return err
+	}
+	conn := resource.(*redisConn)
+	defer p.Put(conn)
+
+	return retryFailure(conn, failure, time.Now(), sendListPush)
+}
+
+// RetryFailures retries every failure matching filter and
+// returns the number of retried failures.
+func RetryFailures(filter *FailureFilter) (retried int, err error) {
+	err = withBackend(func(b Backend) error {
+		retried, err = retryFailures(b, filter)
+		return err
+	})
+	return
+}
+
+func RetryFailuresWithPool(p *pools.ResourcePool, filter *FailureFilter) (int, error) {
+	return retryFailures(NewRedisBackend(p), filter)
+}
+
+func retryFailures(b Backend, filter *FailureFilter) (int, error) {
+	failures, err := b.Failures(0, 0, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	retried := 0
+	for _, failure := range failures {
+		if err := b.RetryFailure(failure); err != nil {
+			return retried, err
+		}
+		retried++
+	}
+	return retried, nil
+}
+
+// RemoveFailure removes a failure returned by Failures
+// from its failed list. The index of every later failure
+// of the list is shifted down by one.
+func RemoveFailure(failure *Failure) error {
+	return withBackend(func(b Backend) error {
+		return b.RemoveFailure(failure)
+	})
+}
+
+func RemoveFailureWithPool(p *pools.ResourcePool, failure *Failure) error {
+	resource, err := p.Get()
+	if err != nil {
+		return err
+	}
+	conn := resource.(*redisConn)
+	defer p.Put(conn)
+
+	// Like Resque, replace the entry by a sentinel and
+	// remove the sentinel, as lists can not be deleted from
+	// by index.
+	return updateFailure(conn, failure, func() {
+		conn.Send("LSET", failure.key, failure.Index, "")
+		conn.Send("LREM", failure.key, 1, "")
+		sendRemoveSample(conn, failure)
+	})
+}
+
+// ClearFailures removes every failure from the failed
+// lists.
+func ClearFailures() error {
+	return withBackend(func(b Backend) error {
+		return b.ClearFailures()
+	})
+}
+
+func ClearFailuresWithPool(p *pools.ResourcePool) error {
+	resource, err := p.Get()
+	if err != nil {
+		return err
+	}
+	conn := resource.(*redisConn)
+	defer p.Put(conn)
+
+	keys, err := failedKeys(conn)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{fmt.Sprintf("%sfailed_queues", cfg.namespace)}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	// The sample keys of the failures removed are left to
+	// expire, and no longer match the generation.
+	conn.Send("DEL", args...)
+	conn.Send("INCR", fmt.Sprintf("%sfailed_generation", cfg.namespace))
+	_, err = conn.Do("")
+	return err
+}
+
+// Returns the keys of the failed list of the Redis failure
+// backend and of the lists of the RedisMultiQueue backend.
+func failedKeys(conn *redisConn) ([]string, error) {
+	queues, err := redis.Strings(conn.Do("SMEMBERS", fmt.Sprintf("%sfailed_queues", cfg.namespace)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(queues)
+
+	keys := []string{fmt.Sprintf("%sfailed", cfg.namespace)}
+	for _, queue := range queues {
+		keys = append(keys, fmt.Sprintf("%s%s", cfg.namespace, queue))
+	}
+	return keys, nil
+}
+
+// Calls fn with every failure of the failed lists, oldest
+// first within each list, until fn returns false.
+func eachFailure(p *pools.ResourcePool, fn func(*Failure) bool) error {
+	resource, err := p.Get()
+	if err != nil {
+		return err
+	}
+	conn := resource.(*redisConn)
+	defer p.Put(conn)
+
+	keys, err := failedKeys(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		for start := 0; ; start += failuresBatchSize {
+			entries, err := redis.ByteSlices(conn.Do("LRANGE", key, start, start+failuresBatchSize-1))
+			if err != nil {
+				return err
+			}
+			for i, entry := range entries {
+				failure := &Failure{}
+				if err := json.Unmarshal(entry, failure); err != nil {
+					logger.Warnf("Skipping unreadable failure %d of %s: %v", start+i, key, err)
+					continue
+				}
+				failure.Index = start + i
+				failure.key = key
+				failure.raw = entry
+				if failure.sample != "" {
+					sample, err := redis.Values(conn.Do("HMGET", failureSampleKey(failure.sample), "id", "count"))
+					if err != nil {
+						return err
+					}
+					var id string
+					var occurrences int
+					if _, err := redis.Scan(sample, &id, &occurrences); err != nil {
+						return err
+					}
+					if id == failure.sample && occurrences > 0 {
+						failure.Occurrences = occurrences
+					}
+				}
+				if !fn(failure) {
+					return nil
+				}
+			}
+			if len(entries) < failuresBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Retries failure, with push sending the command which
+// pushes its job back onto its queue.
+func retryFailure(conn *redisConn, failure *Failure, now time.Time, push func(conn *redisConn, queue string, job []byte)) error {
+	updated, job, err := retriedFailure(failure, now)
+	if err != nil {
+		return err
+	}
+
+	err = updateFailure(conn, failure, func() {
+		conn.Send("LSET", failure.key, failure.Index, updated)
+		push(conn, failure.Queue, job)
+	})
+	if err != nil {
+		return err
+	}
+
+	failure.RetriedAt, _ = parseFailureTime(now.Format(resqueRetriedAtLayout))
+	failure.raw = updated
+	return nil
+}
+
+// Sends the command pushing job to the tail of the list of
+// queue.
+func sendListPush(conn *redisConn, queue string, job []byte) {
+	conn.Send("RPUSH", fmt.Sprintf("%squeue:%s", cfg.namespace, queue), job)
+}
+
+// Returns the stored record of failure retried at now, and
+// the job to push back onto its queue.
+func retriedFailure(failure *Failure, now time.Time) (updated []byte, job []byte, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(failure.raw))
+	decoder.UseNumber()
+
+	// The failure is decoded into a map so that fields
+	// unknown to goworker are written back untouched.
+	var record map[string]interface{}
+	if err = decoder.Decode(&record); err != nil {
+		return
+	}
+	record["retried_at"] = now.Format(resqueRetriedAtLayout)
+
+	if updated, err = json.Marshal(record); err != nil {
+		return
+	}
+	job, err = json.Marshal(retriedPayload(record["payload"]))
+	return
+}
+
+// Returns a copy of the payload of a failure, the job as
+// it failed, with its attempt incremented, so that its
+// retry is told apart from its first run.
+func retriedPayload(value interface{}) interface{} {
+	payload, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	retried := make(map[string]interface{}, len(payload)+1)
+	for key, value := range payload {
+		retried[key] = value
+	}
+	var attempt int64
+	if number, ok := payload["attempt"].(json.Number); ok {
+		attempt, _ = number.Int64()
+	}
+	retried["attempt"] = attempt + 1
+	return retried
+}
+
+// Runs the commands sent by update in a transaction that
+// is only executed if the failure is still stored at its
+// index.
+func updateFailure(conn *redisConn, failure *Failure, update func()) error {
+	if _, err := conn.Do("WATCH", failure.key); err != nil {
+		return err
+	}
+	current, err := redis.Bytes(conn.Do("LINDEX", failure.key, failure.Index))
+	if err != nil && err != redis.ErrNil {
+		conn.Do("UNWATCH")
+		return err
+	}
+	if !bytes.Equal(current, failure.raw) {
+		conn.Do("UNWATCH")
+		return errorFailureChanged
+	}
+
+	conn.Send("MULTI")
+	update()
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return errorFailureChanged
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package goworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var failureUnmarshalJSONTests = []struct {
+	data     string
+	expected Failure
+}{
+	{
+		`{"failed_at":"2015-03-01T18:00:06Z","payload":{"class":"MyClass","args":["hi",1]},"exception":"errors.errorString","error":"boom","backtrace":null,"worker":"hostname:12345-1:high","queue":"high"}`,
+		Failure{
+			FailedAt:    time.Date(2015, 3, 1, 18, 0, 6, 0, time.UTC),
+			Queue:       "high",
+			Class:       "MyClass",
+			Args:        []interface{}{"hi", json.Number("1")},
+			Exception:   "errors.errorString",
+			Error:       "boom",
+			Worker:      "hostname:12345-1:high",
+			Occurrences: 1,
+		},
+	},
+	{
+		`{"failed_at":"2015/03/01 18:00:06 UTC","payload":{"class":"MyClass","args":[]},"exception":"RuntimeError","error":"boom","backtrace":["a.rb:1"],"worker":"hostname:12345:high","queue":"high","retried_at":"2015/03/02 10:00:00"}`,
+		Failure{
+			FailedAt:    time.Date(2015, 3, 1, 18, 0, 6, 0, time.UTC),
+			RetriedAt:   time.Date(2015, 3, 2, 10, 0, 0, 0, time.Local),
+			Queue:       "high",
+			Class:       "MyClass",
+			Args:        []interface{}{},
+			Exception:   "RuntimeError",
+			Error:       "boom",
+			Backtrace:   []string{"a.rb:1"},
+			Worker:      "hostname:12345:high",
+			Occurrences: 1,
+		},
+	},
+}
+
+func TestFailureUnmarshalJSON(t *testing.T) {
+	for _, tt := range failureUnmarshalJSONTests {
+		var actual Failure
+		if err := json.Unmarshal([]byte(tt.data), &actual); err != nil {
+			t.Errorf("Failure(%s): error %s", tt.data, err)
+			continue
+		}
+		if !actual.FailedAt.Equal(tt.expected.FailedAt) || !actual.RetriedAt.Equal(tt.expected.RetriedAt) {
+			t.Errorf("Failure(%s): expected times %v %v, actual %v %v", tt.data, tt.expected.FailedAt, tt.expected.RetriedAt, actual.FailedAt, actual.RetriedAt)
+		}
+		actual.FailedAt, actual.RetriedAt = tt.expected.FailedAt, tt.expected.RetriedAt
+		if fmt.Sprintf("%#v", actual) != fmt.Sprintf("%#v", tt.expected) {
+			t.Errorf("Failure(%s): expected %#v, actual %#v", tt.data, tt.expected, actual)
+		}
+	}
+}
+
+var failureFilterMatchTests = []struct {
+	filter   *FailureFilter
+	expected bool
+}{
+	{nil, true},
+	{&FailureFilter{}, true},
+	{&FailureFilter{Class: "MyClass", Queue: "high", Exception: "RuntimeError"}, true},
+	{&FailureFilter{Class: "Other"}, false},
+	{&FailureFilter{Queue: "low"}, false},
+	{&FailureFilter{Exception: "Panic"}, false},
+	{&FailureFilter{Since: time.Date(2015, 3, 1, 18, 0, 6, 0, time.UTC)}, true},
+	{&FailureFilter{Since: time.Date(2015, 3, 1, 18, 0, 7, 0, time.UTC)}, false},
+	{&FailureFilter{Until: time.Date(2015, 3, 1, 18, 0, 7, 0, time.UTC)}, true},
+	{&FailureFilter{Until: time.Date(2015, 3, 1, 18, 0, 6, 0, time.UTC)}, false},
+}
+
+func TestFailureFilterMatch(t *testing.T) {
+	failure := &Failure{
+		FailedAt:  time.Date(2015, 3, 1, 18, 0, 6, 0, time.UTC),
+		Queue:     "high",
+		Class:     "MyClass",
+		Exception: "RuntimeError",
+	}
+	for _, tt := range failureFilterMatchTests {
+		actual := tt.filter.match(failure)
+		if actual != tt.expected {
+			t.Errorf("FailureFilter(%#v): expected %v, actual %v", tt.filter, tt.expected, actual)
+		}
+	}
+}
+
+func TestRetryAndRemoveFailures(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+
+	resource, _ := p.Get()
+	conn := resource.(*redisConn)
+	conn.Do("DEL", fmt.Sprintf("%sfailed", cfg.namespace))
+	for _, class := range []string{"TestRetryFailure", "TestRemoveFailure", "TestRetryFailure"} {
+		conn.Do("RPUSH", fmt.Sprintf("%sfailed", cfg.namespace),
+			fmt.Sprintf(`{"failed_at":"2015/03/01 18:00:06 UTC","payload":{"class":"%s","args":[1]},"exception":"RuntimeError","error":"boom","backtrace":[],"worker":"w","queue":"test_failures","extra":true}`, class))
+	}
+	p.Put(conn)
+	defer func() {
+		resource, _ := p.Get()
+		conn := resource.(*redisConn)
+		defer p.Put(conn)
+		conn.Do("DEL", fmt.Sprintf("%sfailed", cfg.namespace))
+		conn.Do("DEL", fmt.Sprintf("%squeue:test_failures", cfg.namespace))
+	}()
+
+	retried, err := RetryFailuresWithPool(p, &FailureFilter{Class: "TestRetryFailure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried != 2 {
+		t.Errorf("expecting 2 retried failures, but got %d", retried)
+	}
+
+	failures, err := FailuresWithPool(p, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 3 {
+		t.Fatalf("expecting 3 failures, but got %d", len(failures))
+	}
+	if failures[0].RetriedAt.IsZero() || !failures[1].RetriedAt.IsZero() {
+		t.Errorf("expecting only retried failures to have retried_at, got %v and %v", failures[0].RetriedAt, failures[1].RetriedAt)
+	}
+
+	if err := RemoveFailureWithPool(p, failures[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveFailureWithPool(p, failures[1]); err != errorFailureChanged {
+		t.Errorf("expecting removing twice to fail, but got %v", err)
+	}
+
+	resource, _ = p.Get()
+	conn = resource.(*redisConn)
+	defer p.Put(conn)
+
+	jobs, _ := redis.Strings(conn.Do("LRANGE", fmt.Sprintf("%squeue:test_failures", cfg.namespace), 0, -1))
+	if len(jobs) != 2 || jobs[0] != `{"args":[1],"attempt":1,"class":"TestRetryFailure"}` {
+		t.Errorf("expecting 2 requeued jobs, but got %v", jobs)
+	}
+	entries, _ := redis.Strings(conn.Do("LRANGE", fmt.Sprintf("%sfailed", cfg.namespace), 0, -1))
+	if len(entries) != 2 {
+		t.Errorf("expecting 2 failures left, but got %d", len(entries))
+	}
+	var record map[string]interface{}
+	json.Unmarshal([]byte(entries[0]), &record)
+	if record["extra"] != true {
+		t.Errorf("expecting unknown fields to be kept, got %v", entries[0])
+	}
+}
+
+func TestRetryFailureIncrementsAttempt(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	defer withConn(p, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", fmt.Sprintf("%sfailed", cfg.namespace), fmt.Sprintf("%squeue:test_failures_attempt", cfg.namespace))
+		return err
+	})
+	withConn(p, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", fmt.Sprintf("%sfailed", cfg.namespace))
+		return err
+	})
+
+	// The job failed on its first retry.
+	err := NewRedisFailureBackend(p).Save(&Failure{
+		FailedAt:  time.Now(),
+		Queue:     "test_failures_attempt",
+		Class:     "TestRetryAttempt",
+		Args:      []interface{}{1},
+		Exception: "errors.errorString",
+		Error:     "failed",
+		job:       []byte(`{"class":"TestRetryAttempt","args":[1],"id":"abc","attempt":1}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RetryFailuresWithPool(p, &FailureFilter{Class: "TestRetryAttempt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var job payload
+	err = withConn(p, func(conn *redisConn) error {
+		reply, err := redis.Bytes(conn.Do("LPOP", fmt.Sprintf("%squeue:test_failures_attempt", cfg.namespace)))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(reply, &job)
+	})
+	if err != nil || job.Attempt != 2 || job.ID != "abc" {
+		t.Errorf("expecting the second retry of job abc, but got %+v %v", job, err)
+	}
+}
@@ -1,17 +1,13 @@
 package goworker
 
 import (
-	"os"
-	"strconv"
-	"sync"
+	"context"
+	"sync/atomic"
 	"time"
 
-	"github.com/cihub/seelog"
 	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
 )
 
-var logger seelog.LoggerInterface
-
 func init() {
 	if err := initLogger(); err != nil {
 		panic(err)
@@ -24,9 +20,7 @@ func init() {
 // received, or until the queues are empty if the
 // -exit-on-complete flag is set.
 func Work() error {
-	p := newRedisPool(cfg.uri, cfg.connections, cfg.connections, time.Minute)
-	defer p.Close()
-	return startWorkerWithPool(p)
+	return withBackend(startWorkerWithBackend)
 }
 
 /*
@@ -34,55 +28,160 @@ Enqueue puts a job in the queue.  If you don't want duplicate jobs,
 then set dedupe = true
 */
 func Enqueue(queue string, class string, args []interface{}, dedupe bool) error {
-	p := newRedisPool(cfg.uri, cfg.connections, cfg.connections, time.Minute)
-	defer p.Close()
-	return startEnqueuerWithPool(p, queue, class, args, dedupe)
+	if cfg.inline {
+		return runInline(context.Background(), queue, class, args)
+	}
+	return withBackend(func(b Backend) error {
+		return startEnqueuerWithBackend(context.Background(), b, queue, class, args, dedupe)
+	})
 }
 
 func EnqueueWithPool(p *pools.ResourcePool, queue string, class string, args []interface{}, dedupe bool) error {
-	return startEnqueuerWithPool(p, queue, class, args, dedupe)
+	return startEnqueuerWithBackend(context.Background(), NewRedisBackend(p), queue, class, args, dedupe)
+}
+
+// EnqueueWithBackend is like Enqueue, with the given
+// backend.
+func EnqueueWithBackend(b Backend, queue string, class string, args []interface{}, dedupe bool) error {
+	return startEnqueuerWithBackend(context.Background(), b, queue, class, args, dedupe)
+}
+
+// EnqueueContext is like Enqueue, and stores the trace
+// context of ctx in the job for the Tracer.
+func EnqueueContext(ctx context.Context, queue string, class string, args []interface{}, dedupe bool) error {
+	if cfg.inline {
+		return runInline(ctx, queue, class, args)
+	}
+	return withBackend(func(b Backend) error {
+		return startEnqueuerWithBackend(ctx, b, queue, class, args, dedupe)
+	})
+}
+
+func EnqueueWithPoolContext(ctx context.Context, p *pools.ResourcePool, queue string, class string, args []interface{}, dedupe bool) error {
+	return startEnqueuerWithBackend(ctx, NewRedisBackend(p), queue, class, args, dedupe)
+}
+
+// EnqueueWithBackendContext is like EnqueueContext, with
+// the given backend.
+func EnqueueWithBackendContext(ctx context.Context, b Backend, queue string, class string, args []interface{}, dedupe bool) error {
+	return startEnqueuerWithBackend(ctx, b, queue, class, args, dedupe)
+}
+
+// EnqueueAt is like Enqueue for a job which is not run
+// before at. It returns an error unless the backend stores
+// delayed jobs, like the SQLite backend. In inline mode,
+// the job runs at once.
+func EnqueueAt(at time.Time, queue string, class string, args []interface{}) error {
+	if cfg.inline {
+		return runInline(context.Background(), queue, class, args)
+	}
+	return withBackend(func(b Backend) error {
+		return EnqueueAtWithBackend(b, at, queue, class, args)
+	})
+}
+
+// EnqueueAtWithBackend is like EnqueueAt, with the given
+// backend.
+func EnqueueAtWithBackend(b Backend, at time.Time, queue string, class string, args []interface{}) error {
+	if cfg.inline {
+		return runInline(context.Background(), queue, class, args)
+	}
+	scheduler, ok := b.(Scheduler)
+	if !ok {
+		return errorNoDelayedJobs
+	}
+	job, err := newJob(context.Background(), class, args)
+	if err != nil {
+		return err
+	}
+	return retry(nil, func() error {
+		return scheduler.PushAt(queue, job, at)
+	})
 }
 
-func startEnqueuerWithPool(p *pools.ResourcePool, queue string, class string, args []interface{}, dedupe bool) error {
-	pool = p
-	return enqueue(p, queue, class, args, dedupe)
+func startEnqueuerWithBackend(ctx context.Context, b Backend, queue string, class string, args []interface{}, dedupe bool) error {
+	if cfg.inline {
+		return runInline(ctx, queue, class, args)
+	}
+	return enqueue(ctx, b, queue, class, args, dedupe)
 }
 
 // Call this function to run goworker with the given pool.
 func WorkWithPool(pool *pools.ResourcePool) error {
-	return startWorkerWithPool(pool)
+	return startWorkerWithBackend(NewRedisBackend(pool))
+}
+
+// Call this function to run goworker with the given
+// backend, like the memory backend of NewMemoryBackend.
+func WorkWithBackend(b Backend) error {
+	return startWorkerWithBackend(b)
+}
+
+// Sets the backend of the process, and the pool of the
+// Redis stats and failures if it is a Redis backend, or
+// embeds one like the streams and Sidekiq backends.
+func useBackend(b Backend) {
+	currentBackend = b
+	if b, ok := b.(interface {
+		resourcePool() *pools.ResourcePool
+	}); ok {
+		pool = b.resourcePool()
+	}
 }
 
-func initLogger() error {
-	var err error
-	logger, err = seelog.LoggerFromWriterWithMinLevel(os.Stdout, seelog.InfoLvl)
+// Start worker with the given backend.
+func startWorkerWithBackend(b Backend) error {
+	useBackend(b)
+	atomic.StoreInt32(&shuttingDown, 0)
+	publishStats()
+
+	listener, err := startHTTPServer()
 	if err != nil {
 		return err
 	}
-	return nil
-}
+	if listener != nil {
+		defer listener.Close()
+	}
 
-// Start worker with the given pool.
-func startWorkerWithPool(p *pools.ResourcePool) error {
-	pool = p
-	quit := signals()
+	quit, reload := signals()
 
 	poller, err := newPoller(cfg.queues, cfg.isStrict)
 	if err != nil {
 		return err
 	}
-	jobs := poller.poll(p, time.Duration(cfg.interval), quit)
+	if poller.limiter, err = newLimiter(b); err != nil {
+		return err
+	}
+	if poller.rates, err = newRateLimiter(b); err != nil {
+		return err
+	}
+	jobs := poller.poll(b, time.Duration(cfg.interval), quit)
 
-	var monitor sync.WaitGroup
+	sweeperDone := make(chan struct{})
+	defer close(sweeperDone)
+	startFailureSweeper(b, sweeperDone)
 
-	for id := 0; id < cfg.concurrency; id++ {
-		worker, err := newWorker(strconv.Itoa(id), cfg.queues)
-		if err != nil {
-			return err
-		}
-		worker.work(p, jobs, &monitor)
+	group, err := newWorkerGroup(b, jobs, poller.stopped, quit)
+	if err != nil {
+		return err
+	}
+	if err := group.resize(cfg.concurrency); err != nil {
+		return err
 	}
 
-	monitor.Wait()
-	return nil
+	currentWorkersMutex.Lock()
+	currentWorkers = group
+	currentWorkersMutex.Unlock()
+	defer func() {
+		currentWorkersMutex.Lock()
+		currentWorkers = nil
+		currentWorkersMutex.Unlock()
+	}()
+
+	watcherDone := make(chan struct{})
+	defer close(watcherDone)
+	go group.watch(reload, watcherDone)
+
+	group.monitor.Wait()
+	return poller.err
 }
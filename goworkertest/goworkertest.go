@@ -0,0 +1,371 @@
+// Package goworkertest helps testing code which enqueues
+// goworker jobs, without Redis.
+//
+// Setup replaces the backend of goworker with a fake one
+// in memory for the duration of a test, so that Enqueue,
+// EnqueueAt and the other functions which are not given a
+// backend write to it:
+//
+//	func TestSignup(t *testing.T) {
+//		goworkertest.Setup(t)
+//
+//		signup("ada@example.com")
+//
+//		goworkertest.AssertEnqueued(t, "mail", "Welcome", []interface{}{"ada@example.com"})
+//		if err := goworkertest.Drain(); err != nil {
+//			t.Fatal(err)
+//		}
+//	}
+//
+// Jobs enqueued with EnqueueAt wait for the clock of the
+// fake backend, which starts at the current time and only
+// moves with Advance.
+package goworkertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yudppp/goworker"
+)
+
+var errorNoSetup = errors.New("The fake backend is not set up: call Setup first.")
+
+// Job is a job of the fake backend.
+type Job struct {
+	Queue string
+	Class string
+	Args  []interface{}
+	ID    string
+
+	// At is when the job is due, or zero if it was not
+	// enqueued with EnqueueAt.
+	At time.Time
+
+	raw []byte
+}
+
+// Backend is the fake backend set up by Setup. It keeps
+// everything in memory like the memory backend of
+// goworker, and also stores the jobs of EnqueueAt until
+// its clock reaches them.
+type Backend struct {
+	goworker.Backend
+
+	mutex     sync.Mutex
+	now       time.Time
+	scheduled []*Job
+}
+
+var (
+	currentMutex sync.Mutex
+	current      *Backend
+)
+
+// Setup sets a new fake backend as the backend of
+// goworker, until the end of the test t, and returns it.
+func Setup(t testing.TB) *Backend {
+	b := &Backend{
+		Backend: goworker.NewMemoryBackend(),
+		now:     time.Now(),
+	}
+
+	currentMutex.Lock()
+	current = b
+	currentMutex.Unlock()
+	goworker.SetBackend(b)
+
+	t.Cleanup(func() {
+		goworker.SetBackend(nil)
+
+		currentMutex.Lock()
+		if current == b {
+			current = nil
+		}
+		currentMutex.Unlock()
+	})
+	return b
+}
+
+// Returns the backend of Setup.
+func backend() *Backend {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+
+	if current == nil {
+		panic(errorNoSetup)
+	}
+	return current
+}
+
+// PushAt stores a job until the clock reaches at, or
+// pushes it if at has passed.
+func (b *Backend) PushAt(queue string, job []byte, at time.Time) error {
+	b.mutex.Lock()
+	due := !at.After(b.now)
+	if !due {
+		j, err := decodeJob(queue, job)
+		if err != nil {
+			b.mutex.Unlock()
+			return err
+		}
+		j.At = at
+		b.scheduled = append(b.scheduled, j)
+	}
+	b.mutex.Unlock()
+
+	if due {
+		return b.Push(queue, job)
+	}
+	return nil
+}
+
+// Now returns the time of the clock.
+func (b *Backend) Now() time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.now
+}
+
+// Advance moves the clock forward by d and pushes the jobs
+// of EnqueueAt which are then due, in the order of their
+// time.
+func (b *Backend) Advance(d time.Duration) error {
+	b.mutex.Lock()
+	b.now = b.now.Add(d)
+
+	var due, later []*Job
+	for _, job := range b.scheduled {
+		if job.At.After(b.now) {
+			later = append(later, job)
+		} else {
+			due = append(due, job)
+		}
+	}
+	b.scheduled = later
+	b.mutex.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].At.Before(due[j].At)
+	})
+	for _, job := range due {
+		if err := b.Push(job.Queue, job.raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Jobs returns the jobs waiting in queue, in order.
+func (b *Backend) Jobs(queue string) ([]*Job, error) {
+	buffers, err := b.Peek(queue, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(buffers))
+	for _, buffer := range buffers {
+		job, err := decodeJob(queue, buffer)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Scheduled returns the jobs of EnqueueAt for queue which
+// are not due yet, in the order of their time.
+func (b *Backend) Scheduled(queue string) []*Job {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var jobs []*Job
+	for _, job := range b.scheduled {
+		if job.Queue == queue {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].At.Before(jobs[j].At)
+	})
+	return jobs
+}
+
+// Drain runs the jobs of every queue with their registered
+// worker function, queue by queue in the order of their
+// names, until the queues are empty, including the jobs
+// enqueued by the jobs it runs. Jobs which are not due are
+// left scheduled. Every job runs even if some fail, and
+// Drain returns the error of the first which failed.
+func (b *Backend) Drain() error {
+	var first error
+	for {
+		queues, err := b.Queues()
+		if err != nil {
+			return err
+		}
+		sort.Strings(queues)
+
+		queue, job, err := b.Pop(queues)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return first
+		}
+		if err := goworker.Perform(queue, job); err != nil && first == nil {
+			first = fmt.Errorf("%s in queue %s: %v", jobClass(job), queue, err)
+		}
+	}
+}
+
+// AssertEnqueued reports an error to t unless a job of
+// class with args waits in queue. Args are compared as
+// JSON, as the worker function would get them.
+func (b *Backend) AssertEnqueued(t testing.TB, queue string, class string, args []interface{}) {
+	t.Helper()
+
+	jobs, err := b.Jobs(queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := roundTrip(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		if job.Class == class && reflect.DeepEqual(job.Args, expected) {
+			return
+		}
+	}
+	t.Errorf("expecting %s%v to be enqueued in %s, but got %s", class, args, queue, describe(jobs))
+}
+
+// AssertNotEnqueued reports an error to t if a job of
+// class waits in queue.
+func (b *Backend) AssertNotEnqueued(t testing.TB, queue string, class string) {
+	t.Helper()
+
+	jobs, err := b.Jobs(queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		if job.Class == class {
+			t.Errorf("expecting no %s to be enqueued in %s, but got %s", class, queue, describe(jobs))
+			return
+		}
+	}
+}
+
+// Jobs returns the jobs waiting in queue in the backend of
+// Setup.
+func Jobs(queue string) []*Job {
+	jobs, err := backend().Jobs(queue)
+	if err != nil {
+		panic(err)
+	}
+	return jobs
+}
+
+// Scheduled returns the jobs of EnqueueAt for queue which
+// are not due yet in the backend of Setup.
+func Scheduled(queue string) []*Job {
+	return backend().Scheduled(queue)
+}
+
+// Drain runs the jobs of the backend of Setup.
+func Drain() error {
+	return backend().Drain()
+}
+
+// Now returns the time of the clock of the backend of
+// Setup.
+func Now() time.Time {
+	return backend().Now()
+}
+
+// Advance moves the clock of the backend of Setup.
+func Advance(d time.Duration) error {
+	return backend().Advance(d)
+}
+
+// AssertEnqueued reports an error to t unless a job of
+// class with args waits in queue in the backend of Setup.
+func AssertEnqueued(t testing.TB, queue string, class string, args []interface{}) {
+	t.Helper()
+	backend().AssertEnqueued(t, queue, class, args)
+}
+
+// AssertNotEnqueued reports an error to t if a job of
+// class waits in queue in the backend of Setup.
+func AssertNotEnqueued(t testing.TB, queue string, class string) {
+	t.Helper()
+	backend().AssertNotEnqueued(t, queue, class)
+}
+
+// Decodes a job of queue as a worker would.
+func decodeJob(queue string, buffer []byte) (*Job, error) {
+	var payload struct {
+		Class string        `json:"class"`
+		Args  []interface{} `json:"args"`
+		ID    string        `json:"id"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buffer))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	return &Job{
+		Queue: queue,
+		Class: payload.Class,
+		Args:  payload.Args,
+		ID:    payload.ID,
+		raw:   buffer,
+	}, nil
+}
+
+// Returns args as a worker function would get them.
+func roundTrip(args []interface{}) ([]interface{}, error) {
+	buffer, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buffer))
+	decoder.UseNumber()
+
+	var result []interface{}
+	err = decoder.Decode(&result)
+	return result, err
+}
+
+// Returns the class of a job, for the errors of Drain.
+func jobClass(buffer []byte) string {
+	var payload struct {
+		Class string `json:"class"`
+	}
+	json.Unmarshal(buffer, &payload)
+	return payload.Class
+}
+
+func describe(jobs []*Job) string {
+	if len(jobs) == 0 {
+		return "no job"
+	}
+	var buffer bytes.Buffer
+	for i, job := range jobs {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		fmt.Fprintf(&buffer, "%s%v", job.Class, job.Args)
+	}
+	return buffer.String()
+}
@@ -0,0 +1,99 @@
+package goworkertest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yudppp/goworker"
+)
+
+func TestEnqueue(t *testing.T) {
+	Setup(t)
+
+	if err := goworker.Enqueue("test", "TestEnqueue", []interface{}{1, "a"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := goworker.Enqueue("test", "TestEnqueue", []interface{}{1, "a"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := Jobs("test")
+	if len(jobs) != 1 || jobs[0].Class != "TestEnqueue" || jobs[0].ID == "" {
+		t.Fatalf("expecting 1 job of TestEnqueue, but got %v", jobs)
+	}
+	AssertEnqueued(t, "test", "TestEnqueue", []interface{}{1, "a"})
+	AssertNotEnqueued(t, "test", "TestOther")
+}
+
+func TestDrain(t *testing.T) {
+	Setup(t)
+
+	var ran []string
+	goworker.Register("TestDrain", func(queue string, args ...interface{}) error {
+		ran = append(ran, queue)
+		if queue == "a" {
+			return goworker.Enqueue("c", "TestDrain", nil, false)
+		}
+		return errors.New("failed")
+	})
+
+	goworker.Enqueue("b", "TestDrain", nil, false)
+	goworker.Enqueue("a", "TestDrain", nil, false)
+
+	if err := Drain(); err == nil || err.Error() != "TestDrain in queue b: failed" {
+		t.Errorf("expecting the error of the job of b, but got %v", err)
+	}
+	if len(ran) != 3 || ran[0] != "a" || ran[1] != "b" || ran[2] != "c" {
+		t.Errorf("expecting the jobs of a, b and c, but got %v", ran)
+	}
+	if jobs := Jobs("c"); len(jobs) != 0 {
+		t.Errorf("expecting the queues to be empty, but got %v", jobs)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	Setup(t)
+
+	start := Now()
+	goworker.EnqueueAt(start.Add(2*time.Hour), "test", "TestLater", nil)
+	goworker.EnqueueAt(start.Add(time.Hour), "test", "TestSoon", nil)
+	goworker.EnqueueAt(start.Add(-time.Hour), "test", "TestNow", nil)
+
+	AssertEnqueued(t, "test", "TestNow", nil)
+	if jobs := Scheduled("test"); len(jobs) != 2 || jobs[0].Class != "TestSoon" {
+		t.Fatalf("expecting 2 scheduled jobs, TestSoon first, but got %v", jobs)
+	}
+
+	if err := Advance(90 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !Now().Equal(start.Add(90 * time.Minute)) {
+		t.Errorf("expecting the clock to advance by 90m, but got %v", Now().Sub(start))
+	}
+	AssertEnqueued(t, "test", "TestSoon", nil)
+	AssertNotEnqueued(t, "test", "TestLater")
+	if jobs := Scheduled("test"); len(jobs) != 1 {
+		t.Errorf("expecting 1 scheduled job left, but got %v", jobs)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	t.Run("first", func(t *testing.T) {
+		Setup(t)
+		goworker.Enqueue("test", "TestCleanup", nil, false)
+	})
+	t.Run("second", func(t *testing.T) {
+		Setup(t)
+		if jobs := Jobs("test"); len(jobs) != 0 {
+			t.Errorf("expecting the jobs of the previous test to be gone, but got %v", jobs)
+		}
+	})
+
+	defer func() {
+		if r := recover(); r != errorNoSetup {
+			t.Errorf("expecting %v after the cleanup, but got %v", errorNoSetup, r)
+		}
+	}()
+	Jobs("test")
+}
@@ -0,0 +1,202 @@
+package goworker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errorPollerStopped    = errors.New("The poller is not running.")
+	errorRedisUnavailable = errors.New("Redis is unavailable.")
+	errorShuttingDown     = errors.New("The process is shutting down.")
+	errorPaused           = errors.New("Polling is paused.")
+)
+
+// State of the process reported by the health endpoints.
+// Times are Unix times in nanoseconds.
+var (
+	pollerAlive  int32
+	paused       int32
+	shuttingDown int32
+	redisUp      int32
+	lastPoll     int64
+	lastRedis    int64
+
+	statusMutex   sync.Mutex
+	statusWorkers = make(map[string]*work)
+)
+
+func init() {
+	httpMux.HandleFunc("/healthz", healthzHandler)
+	httpMux.HandleFunc("/readyz", readyzHandler)
+	httpMux.HandleFunc("/status", statusHandler)
+}
+
+// Pause stops the poller from fetching new jobs until
+// Resume is called. Running jobs are not interrupted and
+// the process reports itself as not ready.
+func Pause() {
+	atomic.StoreInt32(&paused, 1)
+}
+
+// Resume restarts polling after Pause.
+func Resume() {
+	atomic.StoreInt32(&paused, 0)
+}
+
+func isPaused() bool {
+	return atomic.LoadInt32(&paused) == 1
+}
+
+// Records the outcome of a round-trip to Redis.
+func redisRoundTrip(err error) {
+	if err != nil {
+		atomic.StoreInt32(&redisUp, 0)
+		return
+	}
+	atomic.StoreInt32(&redisUp, 1)
+	atomic.StoreInt64(&lastRedis, time.Now().UnixNano())
+}
+
+// Sets the job which worker is running, nil when idle.
+func setWorkerStatus(worker string, work *work) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	statusWorkers[worker] = work
+}
+
+func removeWorkerStatus(worker string) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	delete(statusWorkers, worker)
+}
+
+// Returns nil if the poller loop runs and Redis answered
+// within the liveness timeout. A stale round-trip is
+// checked again with a PING, as the poller does not talk
+// to Redis while every worker is busy.
+func checkLiveness() error {
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		return nil
+	}
+	if atomic.LoadInt32(&pollerAlive) == 0 {
+		return errorPollerStopped
+	}
+	if time.Since(time.Unix(0, atomic.LoadInt64(&lastRedis))) < time.Duration(cfg.livenessTimeout) {
+		return nil
+	}
+
+	b := currentBackend
+	if b == nil {
+		return errorRedisUnavailable
+	}
+	err := b.Ping()
+	redisRoundTrip(err)
+	return err
+}
+
+// Returns nil if the process can take jobs: connected to
+// Redis, polling, not paused and not shutting down.
+func checkReadiness() error {
+	switch {
+	case atomic.LoadInt32(&shuttingDown) == 1:
+		return errorShuttingDown
+	case atomic.LoadInt32(&pollerAlive) == 0:
+		return errorPollerStopped
+	case isPaused():
+		return errorPaused
+	case atomic.LoadInt32(&redisUp) == 0:
+		return errorRedisUnavailable
+	}
+	return nil
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeCheck(w, checkLiveness())
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeCheck(w, checkReadiness())
+}
+
+func writeCheck(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+type workerStatus struct {
+	Worker  string  `json:"worker"`
+	Job     *work   `json:"job"`
+	Runtime float64 `json:"runtime,omitempty"`
+}
+
+type status struct {
+	Hostname     string         `json:"hostname"`
+	Pid          int            `json:"pid"`
+	Queues       []string       `json:"queues"`
+	Live         bool           `json:"live"`
+	Ready        bool           `json:"ready"`
+	Paused       bool           `json:"paused"`
+	ShuttingDown bool           `json:"shutting_down"`
+	LastPoll     *time.Time     `json:"last_poll"`
+	LastRedis    *time.Time     `json:"last_redis"`
+	Workers      []workerStatus `json:"workers"`
+}
+
+func currentStatus() status {
+	hostname, _ := os.Hostname()
+	s := status{
+		Hostname:     hostname,
+		Pid:          os.Getpid(),
+		Queues:       cfg.queues,
+		Live:         checkLiveness() == nil,
+		Ready:        checkReadiness() == nil,
+		Paused:       isPaused(),
+		ShuttingDown: atomic.LoadInt32(&shuttingDown) == 1,
+		LastPoll:     unixNanoTime(atomic.LoadInt64(&lastPoll)),
+		LastRedis:    unixNanoTime(atomic.LoadInt64(&lastRedis)),
+		Workers:      []workerStatus{},
+	}
+
+	now := time.Now()
+	statusMutex.Lock()
+	for worker, work := range statusWorkers {
+		ws := workerStatus{Worker: worker}
+		if work != nil {
+			job := *work
+			ws.Job = &job
+			ws.Runtime = now.Sub(work.RunAt).Seconds()
+		}
+		s.Workers = append(s.Workers, ws)
+	}
+	statusMutex.Unlock()
+
+	sort.Slice(s.Workers, func(i, j int) bool {
+		return s.Workers[i].Worker < s.Workers[j].Worker
+	})
+	return s
+}
+
+func unixNanoTime(nano int64) *time.Time {
+	if nano == 0 {
+		return nil
+	}
+	t := time.Unix(0, nano)
+	return &t
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentStatus())
+}
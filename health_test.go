@@ -0,0 +1,77 @@
+package goworker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecks(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	currentBackend = NewRedisBackend(p)
+
+	defer func() {
+		atomic.StoreInt32(&pollerAlive, 0)
+		atomic.StoreInt32(&shuttingDown, 0)
+		Resume()
+	}()
+
+	tests := []struct {
+		setup func()
+		live  int
+		ready int
+	}{
+		{func() {}, 503, 503},
+		{func() { atomic.StoreInt32(&pollerAlive, 1); redisRoundTrip(nil) }, 200, 200},
+		{func() { Pause() }, 200, 503},
+		{func() { Resume(); atomic.StoreInt64(&lastRedis, 0) }, 200, 200},
+		{func() { atomic.StoreInt32(&shuttingDown, 1) }, 200, 503},
+	}
+	for i, tt := range tests {
+		tt.setup()
+		for path, expected := range map[string]int{"/healthz": tt.live, "/readyz": tt.ready} {
+			recorder := httptest.NewRecorder()
+			httpMux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+			if recorder.Code != expected {
+				t.Errorf("%d %s: expected %d, actual %d %s", i, path, expected, recorder.Code, recorder.Body.String())
+			}
+		}
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	setWorkerStatus("host:1-0:test_status", nil)
+	setWorkerStatus("host:1-1:test_status", &work{
+		Queue:   "test_status",
+		RunAt:   time.Now().Add(-time.Minute),
+		Payload: payload{Class: "TestStatus", Args: []interface{}{"a"}},
+	})
+	defer removeWorkerStatus("host:1-0:test_status")
+	defer removeWorkerStatus("host:1-1:test_status")
+
+	recorder := httptest.NewRecorder()
+	httpMux.ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+
+	var s struct {
+		Workers []struct {
+			Worker  string
+			Job     *work
+			Runtime float64
+		}
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &s); err != nil {
+		t.Fatalf("Status: invalid JSON %s: %v", recorder.Body.String(), err)
+	}
+	if len(s.Workers) != 2 {
+		t.Fatalf("Status: expected 2 workers, got %s", recorder.Body.String())
+	}
+	if s.Workers[0].Job != nil {
+		t.Errorf("Status: expected an idle worker, got %v", s.Workers[0].Job)
+	}
+	if s.Workers[1].Job == nil || s.Workers[1].Job.Payload.Class != "TestStatus" || s.Workers[1].Runtime < 60 {
+		t.Errorf("Status: expected a worker running TestStatus for a minute, got %s", recorder.Body.String())
+	}
+}
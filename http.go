@@ -0,0 +1,34 @@
+package goworker
+
+import (
+	"net"
+	"net/http"
+)
+
+// httpMux serves the endpoints of the httpAddr option.
+var httpMux = http.NewServeMux()
+
+func init() {
+	httpMux.Handle("/metrics", MetricsHandler())
+}
+
+// Starts serving httpMux on the httpAddr option, if set.
+// The returned listener is closed to stop the server.
+func startHTTPServer() (net.Listener, error) {
+	if cfg.httpAddr == "" {
+		return nil, nil
+	}
+
+	listener, err := net.Listen("tcp", cfg.httpAddr)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Serving HTTP on %s", listener.Addr())
+
+	go func() {
+		// Serve returns an error once the listener is
+		// closed, which is expected when Work returns.
+		http.Serve(listener, httpMux)
+	}()
+	return listener, nil
+}
@@ -0,0 +1,38 @@
+package goworker
+
+import (
+	"context"
+	"fmt"
+)
+
+// Perform runs a job popped from queue, as the JSON of
+// its payload, with the worker function registered for
+// its class in the calling goroutine, as a worker would,
+// and returns its error. Nothing is written to the
+// backend: neither the stats nor the failure of the job.
+// It is used by inline mode and by the goworkertest
+// package.
+func Perform(queue string, buffer []byte) error {
+	job, err := decodeJob(queue, buffer)
+	if err != nil {
+		return err
+	}
+
+	workerFunc, ok := workers[job.Payload.Class]
+	if !ok {
+		return fmt.Errorf("No worker for %s in queue %s with args %v", job.Payload.Class, queue, job.Payload.Args)
+	}
+	return runJob(job, workerFunc)
+}
+
+// Runs the job of class with args at once, for inline
+// mode. The job goes through JSON like the enqueued ones,
+// so that its worker function gets the same arguments,
+// with json.Number for numbers.
+func runInline(ctx context.Context, queue string, class string, args []interface{}) error {
+	buffer, err := newJob(ctx, class, args)
+	if err != nil {
+		return err
+	}
+	return Perform(queue, buffer)
+}
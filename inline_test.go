@@ -0,0 +1,52 @@
+package goworker
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestInline(t *testing.T) {
+	Configure(map[string]string{"inline": "true"})
+	defer Configure(map[string]string{"inline": "false"})
+
+	var got []interface{}
+	Register("TestInline", func(queue string, args ...interface{}) error {
+		got = args
+		if len(args) > 0 && args[0] == "fail" {
+			return errors.New("failed")
+		}
+		if len(args) > 0 && args[0] == "panic" {
+			panic("panicked")
+		}
+		return nil
+	})
+	defer delete(workers, "TestInline")
+
+	b := NewMemoryBackend()
+	if err := EnqueueWithBackend(b, "test_inline", "TestInline", []interface{}{1, "a"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != json.Number("1") || got[1] != "a" {
+		t.Errorf("expecting the arguments to go through JSON, but got %#v", got)
+	}
+	if size, _ := b.QueueSize("test_inline"); size != 0 {
+		t.Errorf("expecting no job to be enqueued, but got %d", size)
+	}
+
+	tests := []struct {
+		class string
+		args  []interface{}
+		err   string
+	}{
+		{"TestInline", []interface{}{"fail"}, "failed"},
+		{"TestInline", []interface{}{"panic"}, "panicked"},
+		{"TestInlineMissing", nil, "No worker for TestInlineMissing in queue test_inline with args []"},
+	}
+	for _, tt := range tests {
+		err := Enqueue("test_inline", tt.class, tt.args, false)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Enqueue(%s, %v): expected %q, actual %v", tt.class, tt.args, tt.err, err)
+		}
+	}
+}
@@ -1,6 +1,57 @@
 package goworker
 
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
 type job struct {
 	Queue   string
 	Payload payload
+
+	// The job as popped from the backend, to acknowledge
+	// it.
+	raw []byte
+
+	// The concurrency slots held by the job while it
+	// runs, nil unless its class or queue is limited.
+	slots *slots
+
+	// The renewal of the claim of the job while it runs,
+	// nil unless its backend is a ClaimRenewer.
+	claim *claim
+}
+
+// Decodes the job popped from queue, keeping the numbers
+// of its arguments as json.Number.
+func decodeJob(queue string, reply []byte) (*job, error) {
+	job := &job{Queue: queue, raw: reply}
+
+	decoder := json.NewDecoder(bytes.NewReader(reply))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&job.Payload); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Runs job with workerFunc between the hooks of the
+// metrics and of the tracer, and returns the error of the
+// job, or of its panic.
+func runJob(job *job, workerFunc workerFunc) (err error) {
+	start := time.Now()
+	jobStarted(job)
+	span := startJobSpan(job)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(r)
+		}
+		jobFinished(job, time.Since(start), err)
+		endJobSpan(span, err)
+	}()
+
+	return workerFunc(job.Queue, job.Payload.Args...)
 }
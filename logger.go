@@ -0,0 +1,170 @@
+package goworker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cihub/seelog"
+)
+
+// Level is the severity of a log line.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelCritical
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "critical"}
+
+var (
+	errorInvalidLevel = errors.New("Invalid log level.")
+)
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelCritical {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+func parseLevel(value string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(value, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, errorInvalidLevel
+}
+
+// Field is a structured value attached to a log line,
+// like the worker, queue, class, job id or duration of a
+// job.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the log lines of goworker which are at
+// or above the logLevel option. Log may be called from
+// several goroutines at once.
+type Logger interface {
+	Log(level Level, msg string, fields []Field)
+}
+
+var (
+	outputMutex sync.RWMutex
+
+	// output and outputLevel are the logger and the
+	// logLevel option, read by every goroutine logging.
+	output      Logger
+	outputLevel = LevelInfo
+)
+
+// SetLogger replaces the logger goworker writes to, which
+// by default writes to stdout with seelog.
+func SetLogger(l Logger) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	output = l
+}
+
+func setLogLevel(level Level) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	outputLevel = level
+}
+
+func initLogger() error {
+	l, err := seelog.LoggerFromWriterWithMinLevel(os.Stdout, seelog.TraceLvl)
+	if err != nil {
+		return err
+	}
+	SetLogger(NewSeelogLogger(l))
+	return nil
+}
+
+type seelogLogger struct {
+	logger seelog.LoggerInterface
+}
+
+// NewSeelogLogger returns a Logger writing to l. Fields are
+// appended to the message as key=value pairs.
+func NewSeelogLogger(l seelog.LoggerInterface) Logger {
+	return &seelogLogger{logger: l}
+}
+
+func (l *seelogLogger) Log(level Level, msg string, fields []Field) {
+	for _, field := range fields {
+		msg += fmt.Sprintf(" %s=%v", field.Key, field.Value)
+	}
+	switch level {
+	case LevelDebug:
+		l.logger.Debug(msg)
+	case LevelInfo:
+		l.logger.Info(msg)
+	case LevelWarn:
+		l.logger.Warn(msg)
+	case LevelError:
+		l.logger.Error(msg)
+	default:
+		l.logger.Critical(msg)
+	}
+}
+
+// entry is what goworker logs through. It carries the
+// fields of the process or job being logged about.
+type entry struct {
+	fields []Field
+}
+
+var logger entry
+
+func (e entry) With(key string, value interface{}) entry {
+	fields := make([]Field, len(e.fields), len(e.fields)+1)
+	copy(fields, e.fields)
+	return entry{fields: append(fields, Field{Key: key, Value: value})}
+}
+
+func (e entry) logf(level Level, format string, args ...interface{}) {
+	outputMutex.RLock()
+	out, minLevel := output, outputLevel
+	outputMutex.RUnlock()
+
+	if level < minLevel || out == nil {
+		return
+	}
+	out.Log(level, fmt.Sprintf(format, args...), e.fields)
+}
+
+func (e entry) Debugf(format string, args ...interface{}) {
+	e.logf(LevelDebug, format, args...)
+}
+
+func (e entry) Infof(format string, args ...interface{}) {
+	e.logf(LevelInfo, format, args...)
+}
+
+func (e entry) Warnf(format string, args ...interface{}) {
+	e.logf(LevelWarn, format, args...)
+}
+
+func (e entry) Errorf(format string, args ...interface{}) {
+	e.logf(LevelError, format, args...)
+}
+
+func (e entry) Criticalf(format string, args ...interface{}) {
+	e.logf(LevelCritical, format, args...)
+}
+
+func (e entry) Critical(msg string) {
+	e.logf(LevelCritical, "%s", msg)
+}
@@ -0,0 +1,45 @@
+//go:build go1.21
+// +build go1.21
+
+package goworker
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Level of critical log lines, above slog.LevelError.
+const slogLevelCritical = slog.LevelError + 4
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to l. Fields are
+// written as attributes, and critical lines at
+// slog.LevelError+4.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{logger: l}
+}
+
+func (l *slogLogger) Log(level Level, msg string, fields []Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, field := range fields {
+		attrs[i] = slog.Any(field.Key, field.Value)
+	}
+	l.logger.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	}
+	return slogLevelCritical
+}
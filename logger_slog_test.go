@@ -0,0 +1,23 @@
+//go:build go1.21
+// +build go1.21
+
+package goworker
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewTextHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	l.Log(LevelCritical, "failed", []Field{{"queue", "high"}, {"class", "MyClass"}})
+
+	actual := buffer.String()
+	if !strings.Contains(actual, `level=ERROR+4 msg=failed queue=high class=MyClass`) {
+		t.Errorf("SlogLogger: unexpected line %s", actual)
+	}
+}
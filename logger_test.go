@@ -0,0 +1,87 @@
+package goworker
+
+import (
+	"fmt"
+	"testing"
+)
+
+type recordedLine struct {
+	level  Level
+	msg    string
+	fields []Field
+}
+
+type recordingLogger struct {
+	lines []recordedLine
+}
+
+func (l *recordingLogger) Log(level Level, msg string, fields []Field) {
+	l.lines = append(l.lines, recordedLine{level, msg, fields})
+}
+
+var parseLevelTests = []struct {
+	v        string
+	expected Level
+	err      error
+}{
+	{"debug", LevelDebug, nil},
+	{"INFO", LevelInfo, nil},
+	{"warn", LevelWarn, nil},
+	{"error", LevelError, nil},
+	{"critical", LevelCritical, nil},
+	{"verbose", 0, errorInvalidLevel},
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, tt := range parseLevelTests {
+		actual, err := parseLevel(tt.v)
+		if actual != tt.expected || err != tt.err {
+			t.Errorf("Level: parse %s expected %v %v, actual %v %v", tt.v, tt.expected, tt.err, actual, err)
+		}
+	}
+}
+
+func TestEntryLog(t *testing.T) {
+	recorder := &recordingLogger{}
+	previous := output
+	SetLogger(recorder)
+	defer SetLogger(previous)
+
+	w := &worker{process: process{Hostname: "hostname", Pid: 12345, Id: "1", Queues: []string{"high"}}}
+	job := &job{Queue: "high", Payload: payload{Class: "MyClass", ID: "abc"}}
+
+	w.jobLog(job).Debugf("hidden %d", 1)
+	w.jobLog(job).With("duration", "1s").Infof("shown %d", 2)
+	w.jobIDLog(job).Infof("shown in %s", job.Queue)
+	logger.Critical("100%")
+
+	expected := []recordedLine{
+		{
+			LevelInfo,
+			"shown 2",
+			[]Field{
+				{"worker", "hostname:12345-1:high"},
+				{"queue", "high"},
+				{"class", "MyClass"},
+				{"job_id", "abc"},
+				{"duration", "1s"},
+			},
+		},
+		{
+			LevelInfo,
+			"shown in high",
+			[]Field{
+				{"worker", "hostname:12345-1:high"},
+				{"job_id", "abc"},
+			},
+		},
+		{
+			LevelCritical,
+			"100%",
+			nil,
+		},
+	}
+	if fmt.Sprint(recorder.lines) != fmt.Sprint(expected) {
+		t.Errorf("Logger: expected %v, actual %v", expected, recorder.lines)
+	}
+}
@@ -0,0 +1,494 @@
+package goworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryBackend keeps the queues, workers, stats and
+// failures in the process, for tests and for programs
+// which run their jobs without Redis. Jobs are lost when
+// the process exits.
+type memoryBackend struct {
+	mu       sync.Mutex
+	queues   map[string][][]byte
+	known    map[string]bool
+	paused   map[string]bool
+	workers  map[string]bool
+	work     map[string][]byte
+	started  map[string]time.Time
+	stats    map[string]int
+	failures [][]byte
+
+	// slots holds the expiry of the leases of the
+	// concurrency slots, by name and token.
+	slots map[string]map[string]time.Time
+
+	// rates holds the tokens of the rate limits by name,
+	// oldest first.
+	rates map[string][]memoryRateToken
+
+	// concurrency holds the remote concurrency by process,
+	// with an empty process for every process.
+	concurrency map[string]int
+}
+
+type memoryRateToken struct {
+	token string
+	at    time.Time
+}
+
+var (
+	memoryBackendsMutex sync.Mutex
+	memoryBackends      = map[string]*memoryBackend{}
+)
+
+// NewMemoryBackend returns an empty backend which keeps
+// everything in memory. Failures are kept up to the
+// failureMaxCount option and pruned after the
+// failureMaxAge option; they are not sampled.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		queues:  make(map[string][][]byte),
+		known:   make(map[string]bool),
+		paused:  make(map[string]bool),
+		workers: make(map[string]bool),
+		work:    make(map[string][]byte),
+		started: make(map[string]time.Time),
+		stats:   make(map[string]int),
+		slots:   make(map[string]map[string]time.Time),
+		rates:   make(map[string][]memoryRateToken),
+
+		concurrency: make(map[string]int),
+	}
+}
+
+// Returns the memory backend of the memory:// URI with
+// name, shared by every caller in the process.
+func memoryBackendFor(name string) Backend {
+	memoryBackendsMutex.Lock()
+	defer memoryBackendsMutex.Unlock()
+
+	b, ok := memoryBackends[name]
+	if !ok {
+		b = NewMemoryBackend().(*memoryBackend)
+		memoryBackends[name] = b
+	}
+	return b
+}
+
+func (b *memoryBackend) Push(queue string, job []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.known[queue] = true
+	b.queues[queue] = append(b.queues[queue], job)
+	return nil
+}
+
+func (b *memoryBackend) Requeue(queue string, job []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.queues[queue] = append([][]byte{job}, b.queues[queue]...)
+	return nil
+}
+
+func (b *memoryBackend) Pop(queues []string) (string, []byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, queue := range queues {
+		if jobs := b.queues[queue]; len(jobs) > 0 {
+			b.queues[queue] = jobs[1:]
+			return queue, jobs[0], nil
+		}
+	}
+	return "", nil, nil
+}
+
+func (b *memoryBackend) Queues() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queues := make([]string, 0, len(b.known))
+	for queue := range b.known {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
+
+func (b *memoryBackend) QueueSize(queue string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.queues[queue]), nil
+}
+
+func (b *memoryBackend) Peek(queue string, offset, limit int) ([][]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	jobs := b.queues[queue]
+	if offset >= len(jobs) {
+		return nil, nil
+	}
+	jobs = jobs[offset:]
+	if limit > 0 && limit < len(jobs) {
+		jobs = jobs[:limit]
+	}
+	return append([][]byte(nil), jobs...), nil
+}
+
+func (b *memoryBackend) ClearQueue(queue string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cleared := len(b.queues[queue])
+	delete(b.queues, queue)
+	return cleared, nil
+}
+
+func (b *memoryBackend) PauseQueue(queue string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.paused[queue] = true
+	return nil
+}
+
+func (b *memoryBackend) ResumeQueue(queue string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.paused, queue)
+	return nil
+}
+
+func (b *memoryBackend) PausedQueues() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queues := make([]string, 0, len(b.paused))
+	for queue := range b.paused {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
+
+func (b *memoryBackend) AcquireSlot(name, token string, limit int, lease time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	slots := b.slots[name]
+	if slots == nil {
+		slots = make(map[string]time.Time)
+		b.slots[name] = slots
+	}
+	for held, expiry := range slots {
+		if !expiry.After(now) {
+			delete(slots, held)
+		}
+	}
+	if _, ok := slots[token]; !ok && len(slots) >= limit {
+		return false, nil
+	}
+	slots[token] = now.Add(lease)
+	return true, nil
+}
+
+func (b *memoryBackend) RenewSlot(name, token string, lease time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.slots[name][token]; ok {
+		b.slots[name][token] = time.Now().Add(lease)
+	}
+	return nil
+}
+
+func (b *memoryBackend) ReleaseSlot(name, token string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.slots[name], token)
+	return nil
+}
+
+func (b *memoryBackend) TakeRate(name, token string, limit int, window time.Duration) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	tokens := b.rateTokens(name, now.Add(-window))
+	if len(tokens) >= limit {
+		return tokens[0].at.Add(window).Sub(now), nil
+	}
+	b.rates[name] = append(tokens, memoryRateToken{token, now})
+	return 0, nil
+}
+
+func (b *memoryBackend) ReturnRate(name, token string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tokens := b.rates[name]
+	for i, t := range tokens {
+		if t.token == token {
+			b.rates[name] = append(tokens[:i:i], tokens[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) RateUsage(name string, window time.Duration) (int, time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tokens := b.rateTokens(name, time.Now().Add(-window))
+	if len(tokens) == 0 {
+		return 0, time.Time{}, nil
+	}
+	return len(tokens), tokens[0].at, nil
+}
+
+// Drops the tokens of name counted up to start and returns
+// the others.
+func (b *memoryBackend) rateTokens(name string, start time.Time) []memoryRateToken {
+	tokens := b.rates[name]
+	for len(tokens) > 0 && !tokens[0].at.After(start) {
+		tokens = tokens[1:]
+	}
+	b.rates[name] = tokens
+	return tokens
+}
+
+func (b *memoryBackend) SetRemoteConcurrency(process string, concurrency int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if concurrency > 0 {
+		b.concurrency[process] = concurrency
+	} else {
+		delete(b.concurrency, process)
+	}
+	return nil
+}
+
+func (b *memoryBackend) RemoteConcurrency(process string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if concurrency, ok := b.concurrency[process]; ok {
+		return concurrency, nil
+	}
+	return b.concurrency[""], nil
+}
+
+func (b *memoryBackend) RegisterWorker(worker string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.workers[worker] = true
+	b.stats["processed:"+worker] = 0
+	b.stats["failed:"+worker] = 0
+	return nil
+}
+
+func (b *memoryBackend) UnregisterWorker(worker string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.workers, worker)
+	delete(b.stats, "processed:"+worker)
+	delete(b.stats, "failed:"+worker)
+	delete(b.work, worker)
+	delete(b.started, worker)
+	return nil
+}
+
+func (b *memoryBackend) StartWork(worker string, work []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if work != nil {
+		b.work[worker] = work
+	}
+	b.started[worker] = time.Now()
+	return nil
+}
+
+func (b *memoryBackend) FinishWork(worker string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.work, worker)
+	delete(b.started, worker)
+	return nil
+}
+
+func (b *memoryBackend) Workers() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	workers := make([]string, 0, len(b.workers))
+	for worker := range b.workers {
+		workers = append(workers, worker)
+	}
+	sort.Strings(workers)
+	return workers, nil
+}
+
+func (b *memoryBackend) WorkerJob(worker string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.work[worker], nil
+}
+
+func (b *memoryBackend) IncrStats(stats ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, stat := range stats {
+		b.stats[stat]++
+	}
+	return nil
+}
+
+func (b *memoryBackend) Stat(stat string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.stats[stat], nil
+}
+
+func (b *memoryBackend) SaveFailure(failure *Failure) error {
+	buffer, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = append(b.failures, buffer)
+	if excess := len(b.failures) - cfg.failureMaxCount; cfg.failureMaxCount > 0 && excess > 0 {
+		b.failures = b.failures[excess:]
+	}
+	return nil
+}
+
+// Calls fn with every failure, oldest first, until fn
+// returns false. The failures are read from a copy so that
+// fn can call the other methods.
+func (b *memoryBackend) eachFailure(fn func(*Failure) bool) error {
+	b.mu.Lock()
+	entries := append([][]byte(nil), b.failures...)
+	b.mu.Unlock()
+
+	for i, entry := range entries {
+		failure := &Failure{}
+		if err := json.Unmarshal(entry, failure); err != nil {
+			logger.Warnf("Skipping unreadable failure %d: %v", i, err)
+			continue
+		}
+		failure.Index = i
+		failure.raw = entry
+		if !fn(failure) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) Failures(offset, limit int, filter *FailureFilter) ([]*Failure, error) {
+	return collectFailures(b.eachFailure, offset, limit, filter)
+}
+
+func (b *memoryBackend) FailureCount() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.failures), nil
+}
+
+func (b *memoryBackend) RetryFailure(failure *Failure) error {
+	now := time.Now()
+	updated, job, err := retriedFailure(failure, now)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.storedFailure(failure) {
+		return errorFailureChanged
+	}
+	b.failures[failure.Index] = updated
+	b.known[failure.Queue] = true
+	b.queues[failure.Queue] = append(b.queues[failure.Queue], job)
+
+	failure.RetriedAt, _ = parseFailureTime(now.Format(resqueRetriedAtLayout))
+	failure.raw = updated
+	return nil
+}
+
+func (b *memoryBackend) RemoveFailure(failure *Failure) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.storedFailure(failure) {
+		return errorFailureChanged
+	}
+	b.failures = append(b.failures[:failure.Index], b.failures[failure.Index+1:]...)
+	return nil
+}
+
+// Returns whether failure is still stored at its index.
+// It is called with the mutex held.
+func (b *memoryBackend) storedFailure(failure *Failure) bool {
+	return failure.Index >= 0 && failure.Index < len(b.failures) &&
+		bytes.Equal(b.failures[failure.Index], failure.raw)
+}
+
+func (b *memoryBackend) ClearFailures() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = nil
+	return nil
+}
+
+func (b *memoryBackend) PruneFailures(cutoff time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.failures[:0]
+	for _, entry := range b.failures {
+		failure := &Failure{}
+		if err := json.Unmarshal(entry, failure); err == nil && failure.FailedAt.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	pruned := len(b.failures) - len(kept)
+	b.failures = kept
+	return pruned, nil
+}
+
+func (b *memoryBackend) Ping() error {
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,201 @@
+package goworker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackendQueues(t *testing.T) {
+	b := NewMemoryBackend()
+
+	b.Push("low", []byte("1"))
+	b.Push("high", []byte("2"))
+	b.Push("high", []byte("3"))
+	b.Requeue("high", []byte("0"))
+
+	if queues, _ := b.Queues(); len(queues) != 2 || queues[0] != "high" || queues[1] != "low" {
+		t.Errorf("expecting queues high and low, but got %v", queues)
+	}
+	if jobs, _ := b.Peek("high", 1, 1); len(jobs) != 1 || string(jobs[0]) != "2" {
+		t.Errorf("expecting to peek job 2, but got %q", jobs)
+	}
+
+	tests := []struct {
+		queue string
+		job   string
+	}{
+		{"high", "0"},
+		{"high", "2"},
+		{"high", "3"},
+		{"low", "1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		queue, job, err := b.Pop([]string{"high", "low"})
+		if err != nil || queue != tt.queue || string(job) != tt.job {
+			t.Errorf("Pop: expected %s %q, actual %s %q %v", tt.queue, tt.job, queue, job, err)
+		}
+	}
+}
+
+func TestMemoryBackendFailures(t *testing.T) {
+	b := NewMemoryBackend()
+
+	cfg.failureMaxCount = 3
+	defer func() { cfg.failureMaxCount = 0 }()
+
+	for i, e := range []string{"old", "first", "second", "third"} {
+		b.SaveFailure(&Failure{
+			FailedAt:  time.Now().Add(time.Duration(i-4) * time.Hour),
+			Queue:     "test_memory",
+			Class:     "TestMemory",
+			Args:      []interface{}{e},
+			Exception: "errors.errorString",
+			Error:     e,
+		})
+	}
+
+	failures, err := b.Failures(0, 0, nil)
+	if err != nil || len(failures) != 3 || failures[0].Error != "first" {
+		t.Fatalf("expecting the three last failures, but got %v %v", failures, err)
+	}
+
+	if err := b.RetryFailure(failures[1]); err != nil {
+		t.Fatal(err)
+	}
+	if failures[1].RetriedAt.IsZero() {
+		t.Error("expecting the retried failure to have a retried_at")
+	}
+	if _, job, _ := b.Pop([]string{"test_memory"}); string(job) != `{"args":["second"],"attempt":1,"class":"TestMemory"}` {
+		t.Errorf("expecting the job of the failure to be requeued, but got %s", job)
+	}
+
+	if err := b.RemoveFailure(failures[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RemoveFailure(failures[2]); err != errorFailureChanged {
+		t.Errorf("expecting %v after the failure moved, but got %v", errorFailureChanged, err)
+	}
+
+	pruned, err := b.PruneFailures(time.Now().Add(-90 * time.Minute))
+	if err != nil || pruned != 1 {
+		t.Errorf("expecting 1 failure pruned, but got %d %v", pruned, err)
+	}
+	if count, _ := b.FailureCount(); count != 1 {
+		t.Errorf("expecting 1 failure left, but got %d", count)
+	}
+}
+
+func TestWorkWithMemoryBackend(t *testing.T) {
+	b := newBackend("memory://test_work")
+	if b != newBackend("memory://test_work") {
+		t.Fatal("expecting the memory backend to be shared by its URI")
+	}
+
+	defer Configure(map[string]string{"queues": "", "exitOnComplete": "false"})
+	Configure(map[string]string{"queues": "test_memory_work", "exitOnComplete": "true"})
+
+	var ran []interface{}
+	Register("TestMemoryWork", func(queue string, args ...interface{}) error {
+		ran = append(ran, args...)
+		return nil
+	})
+	defer delete(workers, "TestMemoryWork")
+
+	if err := EnqueueWithBackend(b, "test_memory_work", "TestMemoryWork", []interface{}{"a"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := WorkWithBackend(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ran) != 1 || ran[0] != "a" {
+		t.Errorf("expecting the job to run with a, but got %v", ran)
+	}
+	if processed, _ := b.Stat("processed"); processed != 1 {
+		t.Errorf("expecting 1 processed job, but got %d", processed)
+	}
+}
+
+// backendFailures saves failures to a backend.
+type backendFailures struct {
+	Backend
+}
+
+func (b backendFailures) Save(failure *Failure) error {
+	return b.SaveFailure(failure)
+}
+
+func TestPollerFailsUndecodableJobs(t *testing.T) {
+	b := NewMemoryBackend()
+	previous := cfg.failureBackend
+	SetFailureBackend(backendFailures{b})
+	defer SetFailureBackend(previous)
+
+	b.Push("test_undecodable", []byte(`{"class":`))
+	b.Push("test_undecodable", []byte(`{"class":"TestUndecodable","args":[]}`))
+	p, err := newPoller([]string{"test_undecodable"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := p.getJob(b)
+	if err != nil || job == nil || job.Payload.Class != "TestUndecodable" {
+		t.Fatalf("expecting the job behind the undecodable one, but got %v %v", job, err)
+	}
+	failures, err := b.Failures(0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].Queue != "test_undecodable" || fmt.Sprint(failures[0].Args) != `[{"class":]` {
+		t.Errorf("expecting the failure of the undecodable job, but got %v", failures)
+	}
+	if failed, _ := b.Stat("failed"); failed != 1 {
+		t.Errorf("expecting 1 failed job, but got %d", failed)
+	}
+}
+
+func TestRetriedFailureKeepsPayload(t *testing.T) {
+	b := NewMemoryBackend()
+	previous := cfg.failureBackend
+	SetFailureBackend(backendFailures{b})
+	defer SetFailureBackend(previous)
+
+	b.Push("test_retried", []byte(`{"class":"TestRetried","args":[1],"id":"abc","trace":{"traceparent":"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}`))
+	p, err := newPoller([]string{"test_retried"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorker("1", []string{"test_retried"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job fails, is retried, fails again and is
+	// retried again.
+	for i := 0; i < 2; i++ {
+		job, err := p.getJob(b)
+		if err != nil || job == nil {
+			t.Fatalf("%d: expecting the job, but got %v %v", i, job, err)
+		}
+		w.run(b, job, func(queue string, args ...interface{}) error {
+			return fmt.Errorf("failed")
+		})
+		failures, err := b.Failures(0, 0, &FailureFilter{})
+		if err != nil || len(failures) != i+1 {
+			t.Fatalf("%d: expecting %d failures, but got %v %v", i, i+1, failures, err)
+		}
+		if err := b.RetryFailure(failures[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := p.getJob(b)
+	if err != nil || job == nil {
+		t.Fatalf("expecting the retried job, but got %v %v", job, err)
+	}
+	if job.Payload.Attempt != 2 || job.Payload.ID != "abc" || job.Payload.Trace["traceparent"] == "" {
+		t.Errorf("expecting the second retry with its id and trace, but got %+v", job.Payload)
+	}
+}
@@ -0,0 +1,205 @@
+package goworker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Buckets in seconds of the histograms of job durations
+// and poller fetch latencies.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Buckets in seconds of the histogram of the time jobs
+// spend in their queue.
+var queueTimeBuckets = []float64{.01, .1, .5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
+var (
+	jobsProcessed = newMetric("goworker_jobs_processed_total", "Jobs which ran without error.", "counter", nil, "queue", "class")
+	jobsFailed    = newMetric("goworker_jobs_failed_total", "Jobs which failed.", "counter", nil, "queue", "class")
+	jobsRetried   = newMetric("goworker_jobs_retried_total", "Retried failed jobs started by workers.", "counter", nil, "queue", "class")
+	jobsDeferred  = newMetric("goworker_jobs_deferred_total", "Jobs delayed or pushed back to the tail of their queue, over their concurrency or rate limit.", "counter", nil, "queue", "class", "limit")
+	jobDuration   = newMetric("goworker_job_duration_seconds", "Time spent running jobs.", "histogram", durationBuckets, "queue", "class")
+	jobQueueTime  = newMetric("goworker_job_queue_time_seconds", "Time jobs spent in their queue before running.", "histogram", queueTimeBuckets, "queue")
+	pollerFetch   = newMetric("goworker_poller_fetch_duration_seconds", "Time spent by the poller fetching a job from the queues.", "histogram", durationBuckets)
+	pollerEmpty   = newMetric("goworker_poller_empty_polls_total", "Polls which found every queue empty.", "counter", nil)
+
+	workersBusy  int64
+	workersTotal int64
+)
+
+// metric is a counter or histogram with labels, written
+// in the Prometheus text format.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values  []string
+	value   float64
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+func newMetric(name, help, kind string, buckets []float64, labels ...string) *metric {
+	return &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		buckets: buckets,
+		labels:  labels,
+		series:  make(map[string]*series),
+	}
+}
+
+func (m *metric) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: values, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) inc(values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(values).value++
+}
+
+func (m *metric) observe(value float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(values)
+	for i, bound := range m.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.samples++
+}
+
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		labels := formatLabels(m.labels, s.values)
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels, formatValue(s.value))
+			continue
+		}
+		names := append(append([]string(nil), m.labels...), "le")
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, append(append([]string(nil), s.values...), formatValue(bound))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, append(append([]string(nil), s.values...), "+Inf")), s.samples)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labels, s.samples)
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatValue(value))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Records that a worker started running job.
+func jobStarted(job *job) {
+	atomic.AddInt64(&workersBusy, 1)
+	if job.Payload.retried() {
+		jobsRetried.inc(job.Queue, job.Payload.Class)
+	}
+	if job.Payload.EnqueuedAt > 0 {
+		enqueuedAt := time.Unix(0, int64(job.Payload.EnqueuedAt*float64(time.Second)))
+		jobQueueTime.observe(time.Since(enqueuedAt).Seconds(), job.Queue)
+	}
+}
+
+// Records that a worker finished running job after
+// duration, with the error returned by the job.
+func jobFinished(job *job, duration time.Duration, err error) {
+	atomic.AddInt64(&workersBusy, -1)
+	jobDuration.observe(duration.Seconds(), job.Queue, job.Payload.Class)
+	jobTimings.Add(job.Payload.Class, duration)
+	if err != nil {
+		jobsFailed.inc(job.Queue, job.Payload.Class)
+		jobErrors.Add(job.Payload.Class, 1)
+	} else {
+		jobsProcessed.inc(job.Queue, job.Payload.Class)
+	}
+}
+
+// MetricsHandler returns a handler serving the metrics of
+// the workers of this process and of the Redis pool in the
+// Prometheus text format. The handler is served on
+// /metrics by the httpAddr option.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := bufio.NewWriter(rw)
+		defer w.Flush()
+
+		for _, m := range []*metric{jobsProcessed, jobsFailed, jobsRetried, jobsDeferred, jobDuration, jobQueueTime, pollerFetch, pollerEmpty} {
+			m.write(w)
+		}
+
+		busy := atomic.LoadInt64(&workersBusy)
+		writeGauge(w, "goworker_workers_busy", "Workers running a job.", float64(busy))
+		writeGauge(w, "goworker_workers_idle", "Workers waiting for a job.", float64(atomic.LoadInt64(&workersTotal)-busy))
+
+		if p := pool; p != nil {
+			capacity, available, _, waitCount, waitTime, _ := p.Stats()
+			writeGauge(w, "goworker_redis_pool_capacity", "Capacity of the Redis connection pool.", float64(capacity))
+			writeGauge(w, "goworker_redis_pool_available", "Redis connections available in the pool.", float64(available))
+			fmt.Fprintf(w, "# HELP goworker_redis_pool_wait_count_total Waits for a Redis connection from the pool.\n# TYPE goworker_redis_pool_wait_count_total counter\ngoworker_redis_pool_wait_count_total %d\n", waitCount)
+			fmt.Fprintf(w, "# HELP goworker_redis_pool_wait_seconds_total Time spent waiting for a Redis connection from the pool.\n# TYPE goworker_redis_pool_wait_seconds_total counter\ngoworker_redis_pool_wait_seconds_total %s\n", formatValue(waitTime.Seconds()))
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package goworker
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricWrite(t *testing.T) {
+	counter := newMetric("test_total", "Test counter.", "counter", nil, "queue")
+	counter.inc("high")
+	counter.inc("high")
+	counter.inc(`a"b`)
+
+	histogram := newMetric("test_seconds", "Test histogram.", "histogram", []float64{1, 5}, "queue")
+	histogram.observe(0.5, "high")
+	histogram.observe(3, "high")
+
+	var buffer bytes.Buffer
+	counter.write(&buffer)
+	histogram.write(&buffer)
+
+	expected := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{queue="a\"b"} 1
+test_total{queue="high"} 2
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{queue="high",le="1"} 1
+test_seconds_bucket{queue="high",le="5"} 2
+test_seconds_bucket{queue="high",le="+Inf"} 2
+test_seconds_sum{queue="high"} 3.5
+test_seconds_count{queue="high"} 2
+`
+	if buffer.String() != expected {
+		t.Errorf("Metric: expected\n%s\nactual\n%s", expected, buffer.String())
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	job := &job{Queue: "test_metrics", Payload: payload{Class: "TestMetrics", EnqueuedAt: float64(time.Now().Unix())}}
+	jobStarted(job)
+	jobFinished(job, time.Second, nil)
+	jobStarted(job)
+	jobFinished(job, time.Second, errors.New("boom"))
+	job.Payload.Attempt = 1
+	jobStarted(job)
+	jobFinished(job, time.Second, nil)
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`goworker_jobs_processed_total{queue="test_metrics",class="TestMetrics"} 2`,
+		`goworker_jobs_failed_total{queue="test_metrics",class="TestMetrics"} 1`,
+		`goworker_jobs_retried_total{queue="test_metrics",class="TestMetrics"} 1`,
+		`goworker_job_duration_seconds_count{queue="test_metrics",class="TestMetrics"} 3`,
+		`goworker_job_queue_time_seconds_count{queue="test_metrics"} 3`,
+		`goworker_workers_busy 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("MetricsHandler: expected line %s in\n%s", line, body)
+		}
+	}
+}
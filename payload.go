@@ -1,6 +1,58 @@
 package goworker
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
 type payload struct {
 	Class string        `json:"class"`
 	Args  []interface{} `json:"args"`
+	ID    string        `json:"id,omitempty"`
+
+	// JID is the id of the jobs of Sidekiq.
+	JID string `json:"jid,omitempty"`
+
+	// EnqueuedAt is the Unix time in seconds at which
+	// goworker enqueued the job.
+	EnqueuedAt float64 `json:"enqueued_at,omitempty"`
+
+	// Trace is the trace context of the code which enqueued
+	// the job, written by the Tracer.
+	Trace map[string]string `json:"trace,omitempty"`
+
+	// Attempt counts the runs of the job, incremented when
+	// its failure is retried. It is 0 for the first run.
+	Attempt int `json:"attempt,omitempty"`
+
+	// RetryCount is set by Sidekiq on the jobs which failed,
+	// from 0 for the first failure.
+	RetryCount *int `json:"retry_count,omitempty"`
+}
+
+// Returns the id of the job, from goworker or Sidekiq.
+func (p payload) id() string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return p.JID
+}
+
+// Returns the number of the current run of the job,
+// starting at 1.
+func (p payload) attempt() int {
+	return p.Attempt + 1
+}
+
+// Returns whether the job is the retry of a failed job.
+func (p payload) retried() bool {
+	return p.Attempt > 0 || p.RetryCount != nil
+}
+
+// Returns a random id for a new job. Resque ignores it,
+// goworker uses it to follow the job in the logs.
+func newJobID() string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	return hex.EncodeToString(id)
 }
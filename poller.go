@@ -1,19 +1,54 @@
 package goworker
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
+	"sync/atomic"
 	"time"
-
-	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
 )
 
 type poller struct {
 	process
 	isStrict bool
+
+	// err is the error which stopped the poller, read
+	// once the jobs channel is closed.
+	err error
+
+	// stopped is closed along with the jobs channel.
+	stopped chan struct{}
+
+	// paused holds the queues paused on the backend, read
+	// again every pausedRefresh.
+	paused   map[string]bool
+	pausedAt time.Time
+
+	// limiter holds the concurrency slots of the limited
+	// classes and queues, and rates counts their jobs, nil
+	// if there is none.
+	limiter *limiter
+	rates   *rateLimiter
+
+	// deferring is set when the last job popped was
+	// deferred. deferred counts the jobs pushed back in a
+	// row since a job was last run, and deferLimit is the
+	// size of the queue of the first of them. cycled is set
+	// once as many were pushed back, as every job of the
+	// queue was likely popped since, whatever the other
+	// processes popped meanwhile, and the poller waits for
+	// a free slot, or for rateWait, the smallest wait of
+	// the jobs over a rate limit, unless slotWait is set
+	// as a job was over a concurrency limit.
+	deferring  bool
+	deferred   int
+	deferLimit int
+	cycled     bool
+	rateWait   time.Duration
+	slotWait   bool
 }
 
+// How often pollers read the paused queues again.
+const pausedRefresh = time.Second
+
 func newPoller(queues []string, isStrict bool) (*poller, error) {
 	process, err := newProcess("poller", queues)
 	if err != nil {
@@ -25,57 +60,230 @@ func newPoller(queues []string, isStrict bool) (*poller, error) {
 	}, nil
 }
 
-func (p *poller) getJob(conn *redisConn) (*job, error) {
-	for _, queue := range p.queues(p.isStrict) {
-		logger.Debugf("Checking %s", queue)
+func (p *poller) getJob(b Backend) (*job, error) {
+	queues, err := p.unpaused(b, p.queues(p.isStrict))
+	if err != nil || len(queues) == 0 {
+		return nil, err
+	}
+	p.log().Debugf("Checking %v", queues)
 
-		reply, err := conn.Do("LPOP", fmt.Sprintf("%squeue:%s", cfg.namespace, queue))
-		if err != nil {
+	for {
+		queue, reply, err := b.Pop(queues)
+		if err != nil || reply == nil {
 			return nil, err
 		}
-		if reply != nil {
-			logger.Debugf("Found job on %s", queue)
+		p.log().Debugf("Found job on %s", queue)
+
+		job, err := decodeJob(queue, reply)
+		if err != nil {
+			if err := p.failUndecodable(b, queue, reply, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.limiter != nil || p.rates != nil {
+			if job, err = p.limit(b, job); job == nil {
+				return nil, err
+			}
+		}
+		job.claim = renewClaim(b, job)
+		return job, nil
+	}
+}
 
-			job := &job{Queue: queue}
+// Saves the failure of a job popped from queue which could
+// not be decoded, with the job as its only argument, and
+// acknowledges it, as it would fail again.
+func (p *poller) failUndecodable(b Backend, queue string, reply []byte, err error) error {
+	p.log().Errorf("Error on decoding job of %s: %v", queue, err)
 
-			decoder := json.NewDecoder(bytes.NewReader(reply.([]byte)))
-			decoder.UseNumber()
+	failure := &Failure{
+		FailedAt:  time.Now(),
+		Queue:     queue,
+		Args:      []interface{}{string(reply)},
+		Exception: exceptionName(err),
+		Error:     err.Error(),
+		Worker:    p.String(),
+		job:       reply,
+	}
+	if err := cfg.failureBackend.Save(failure); err != nil {
+		p.log().Errorf("Error on saving failure of %v: %v", p, err)
+	}
+	jobsFailed.inc(queue, "")
 
-			if err := decoder.Decode(&job.Payload); err != nil {
-				return nil, err
+	if err := p.fail(b); err != nil {
+		return err
+	}
+	if acknowledger, ok := b.(Acknowledger); ok {
+		return acknowledger.Ack(queue, reply)
+	}
+	return nil
+}
+
+// Returns job with its concurrency slots and counted by
+// its rate limits, or defers it and returns nil if it is
+// over a limit, so that the jobs behind it are run
+// meanwhile.
+func (p *poller) limit(b Backend, job *job) (*job, error) {
+	if p.limiter != nil {
+		slots, err := p.limiter.acquire(job)
+		if err != nil {
+			return nil, p.requeue(b, job, err)
+		}
+		if slots == nil {
+			return nil, p.deferJob(b, job, 0, "concurrency")
+		}
+		job.slots = slots
+	}
+	if p.rates != nil {
+		wait, err := p.rates.take(job)
+		if err != nil || wait > 0 {
+			if job.slots != nil {
+				job.slots.free()
+				job.slots = nil
+			}
+			if err != nil {
+				return nil, p.requeue(b, job, err)
 			}
-			return job, nil
+			return nil, p.deferJob(b, job, wait, "rate")
+		}
+	}
+	p.deferred = 0
+	return job, nil
+}
+
+// Puts job back at the head of its queue after err, not to
+// lose it, and returns err.
+func (p *poller) requeue(b Backend, job *job, err error) error {
+	if err := b.Requeue(job.Queue, job.raw); err != nil {
+		p.log().Criticalf("Error requeueing %v: %v", job, err)
+	}
+	return err
+}
+
+// Delays job by wait with the backends which store delayed
+// jobs, or else pushes it back to the tail of its queue.
+func (p *poller) deferJob(b Backend, job *job, wait time.Duration, limit string) error {
+	scheduler, ok := b.(Scheduler)
+	if wait > 0 && ok {
+		p.log().With("queue", job.Queue).Debugf("Delaying %v by %v, over its %s limit", job.Payload.Class, wait, limit)
+		if err := scheduler.PushAt(job.Queue, job.raw, time.Now().Add(wait)); err != nil {
+			return p.requeue(b, job, err)
+		}
+	} else {
+		p.log().With("queue", job.Queue).Debugf("Deferring %v, over its %s limit", job.Payload.Class, limit)
+		if err := b.Push(job.Queue, job.raw); err != nil {
+			return p.requeue(b, job, err)
+		}
+
+		if p.deferred == 0 {
+			// The queue holds the job pushed back, unless
+			// its size cannot be read: the poller then
+			// waits after each job.
+			p.deferLimit, _ = b.QueueSize(job.Queue)
+			p.rateWait = 0
+			p.slotWait = false
+		}
+		if wait == 0 {
+			p.slotWait = true
+		} else if p.rateWait == 0 || wait < p.rateWait {
+			p.rateWait = wait
+		}
+		p.deferred++
+		if p.deferred >= p.deferLimit {
+			p.deferred = 0
+			p.cycled = true
+		}
+	}
+	p.deferring = true
+
+	if acknowledger, ok := b.(Acknowledger); ok {
+		if err := acknowledger.Ack(job.Queue, job.raw); err != nil {
+			return err
+		}
+	}
+	jobsDeferred.inc(job.Queue, job.Payload.Class, limit)
+	return nil
+}
+
+// Returns how long the poller waits once every job popped
+// was deferred: the smallest wait of the jobs over a rate
+// limit, or at most deferredWait if a job waits for a
+// concurrency slot.
+func (p *poller) cycleWait() time.Duration {
+	if p.rateWait > 0 && (!p.slotWait || p.rateWait < deferredWait) {
+		return p.rateWait
+	}
+	return deferredWait
+}
+
+// Returns queues without the queues paused on the backend.
+func (p *poller) unpaused(b Backend, queues []string) ([]string, error) {
+	admin, ok := b.(QueueAdmin)
+	if !ok {
+		return queues, nil
+	}
+	if time.Since(p.pausedAt) >= pausedRefresh {
+		paused, err := admin.PausedQueues()
+		if err != nil {
+			return nil, err
 		}
+		p.paused = make(map[string]bool, len(paused))
+		for _, queue := range paused {
+			p.paused[queue] = true
+		}
+		p.pausedAt = time.Now()
+	}
+	if len(p.paused) == 0 {
+		return queues, nil
 	}
 
-	return nil, nil
+	var unpaused []string
+	for _, queue := range queues {
+		if !p.paused[queue] {
+			unpaused = append(unpaused, queue)
+		}
+	}
+	return unpaused, nil
 }
 
-func (p *poller) poll(pool *pools.ResourcePool, interval time.Duration, quit <-chan bool) <-chan *job {
+func (p *poller) poll(b Backend, interval time.Duration, quit <-chan struct{}) <-chan *job {
 	jobs := make(chan *job)
+	p.stopped = make(chan struct{})
 
-	resource, err := pool.Get()
+	err := retry(quit, func() error {
+		if err := p.open(b); err != nil {
+			return err
+		}
+		return p.start(b)
+	})
 	if err != nil {
-		logger.Criticalf("Error on getting connection in poller %s", p)
-	} else {
-		conn := resource.(*redisConn)
-		p.open(conn)
-		p.start(conn)
-		pool.Put(conn)
+		p.log().Criticalf("Error on opening poller %s: %v", p, err)
+		if _, ok := err.(*outageError); ok {
+			p.err = err
+			close(jobs)
+			close(p.stopped)
+			return jobs
+		}
+	}
+
+	atomic.StoreInt32(&pollerAlive, 1)
+
+	// A nil channel, for the backends which do not wake the
+	// poller, is never ready.
+	var wakeup <-chan string
+	if waker, ok := b.(Waker); ok {
+		wakeup = waker.Wakeup()
 	}
 
 	go func() {
 		defer func() {
+			atomic.StoreInt32(&pollerAlive, 0)
 			close(jobs)
+			close(p.stopped)
 
-			resource, err := pool.Get()
-			if err != nil {
-				logger.Criticalf("Error on getting connection in poller %s", p)
-			} else {
-				conn := resource.(*redisConn)
-				p.finish(conn)
-				p.close(conn)
-				pool.Put(conn)
+			if err := p.close(b); err != nil {
+				p.log().Errorf("Error on closing poller %s: %v", p, err)
 			}
 		}()
 
@@ -84,52 +292,101 @@ func (p *poller) poll(pool *pools.ResourcePool, interval time.Duration, quit <-c
 			case <-quit:
 				return
 			default:
-				resource, err := pool.Get()
-				if err != nil {
-					logger.Criticalf("Error on getting connection in poller %s", p)
-					return
+				if isPaused() {
+					// Keep checking the backend for the
+					// health endpoints.
+					if err := p.retry(quit, b.Ping); err != nil {
+						return
+					}
+					p.log().Debugf("Paused for %v", interval)
+
+					select {
+					case <-quit:
+						return
+					case <-time.After(interval):
+					}
+					continue
 				}
-				conn := resource.(*redisConn)
 
-				job, err := p.getJob(conn)
+				var job *job
+				start := time.Now()
+				err := p.retry(quit, func() (err error) {
+					start = time.Now()
+					job, err = p.getJob(b)
+					return err
+				})
+				pollerFetch.observe(time.Since(start).Seconds())
+				atomic.StoreInt64(&lastPoll, start.UnixNano())
 				if err != nil {
-					logger.Errorf("Error on %v getting job from %v: %v", p, p.Queues, err)
+					if p.err != nil || isConnError(err) {
+						return
+					}
+					p.log().Errorf("Error on %v getting job from %v: %v", p, p.Queues, err)
+				} else if job == nil && !p.deferring {
+					pollerEmpty.inc()
+					pollerTimings.Record("Empty", start)
+				} else if job != nil {
+					queueJobs.Add(job.Queue, 1)
+					pollerTimings.Record("Fetch", start)
 				}
 				if job != nil {
-					conn.Send("INCR", fmt.Sprintf("%sstat:processed:%v", cfg.namespace, p))
-					conn.Flush()
-					pool.Put(conn)
+					if err := p.retry(quit, func() error {
+						return b.IncrStats(fmt.Sprintf("processed:%v", p))
+					}); err != nil {
+						p.log().Errorf("Error on counting job of %v: %v", p, err)
+					}
 					select {
 					case jobs <- job:
 					case <-quit:
-						buf, err := json.Marshal(job.Payload)
+						// The job is requeued as popped, which
+						// the backends keeping the jobs popped
+						// look it up by, and retried within the
+						// outage budget even though goworker is
+						// stopping, not to lose it.
+						err := retry(nil, func() error {
+							return b.Requeue(job.Queue, job.raw)
+						})
 						if err != nil {
-							logger.Criticalf("Error requeueing %v: %v", job, err)
-							return
+							p.log().Criticalf("Error requeueing %v: %v", job, err)
 						}
-						resource, err := pool.Get()
-						if err != nil {
-							logger.Criticalf("Error on getting connection in poller %s", p)
-						}
-
-						conn := resource.(*redisConn)
-						conn.Send("LPUSH", fmt.Sprintf("%squeue:%s", cfg.namespace, job.Queue), buf)
-						conn.Flush()
+						job.slots.release()
+						job.claim.release()
 						return
 					}
+				} else if p.deferring {
+					p.deferring = false
+					if p.cycled {
+						p.cycled = false
+						wait := p.cycleWait()
+						p.log().Debugf("Waiting for a concurrency slot or rate limit for %v", wait)
+
+						// A nil channel, without concurrency
+						// limits, is never ready.
+						var released <-chan struct{}
+						if p.limiter != nil {
+							released = p.limiter.released
+						}
+						select {
+						case <-quit:
+							return
+						case <-released:
+						case <-time.After(wait):
+						}
+					}
 				} else {
-					pool.Put(conn)
 					if cfg.exitOnComplete {
 						return
 					}
-					logger.Debugf("Sleeping for %v", interval)
-					logger.Debugf("Waiting for %v", p.Queues)
+					p.log().Debugf("Sleeping for %v", interval)
+					p.log().Debugf("Waiting for %v", p.Queues)
 
 					timeout := time.After(interval)
 					select {
 					case <-quit:
 						return
 					case <-timeout:
+					case queue := <-wakeup:
+						p.log().Debugf("Woken up by a job on %s", queue)
 					}
 				}
 
@@ -139,3 +396,14 @@ func (p *poller) poll(pool *pools.ResourcePool, interval time.Duration, quit <-c
 
 	return jobs
 }
+
+// Runs fn like retry, keeping the error which stops the
+// poller once the outage budget is spent.
+func (p *poller) retry(quit <-chan struct{}, fn func() error) error {
+	err := retry(quit, fn)
+	if _, ok := err.(*outageError); ok {
+		p.log().Criticalf("Error on polling %v: %v", p.Queues, err)
+		p.err = err
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package goworker
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var postgresConnErrorTests = []struct {
+	err       error
+	connError bool
+	ok        bool
+}{
+	{&pq.Error{Code: "08006"}, true, true},
+	{&pq.Error{Code: "57P01"}, true, true},
+	{&pq.Error{Code: "42P01"}, false, true},
+	{sql.ErrNoRows, false, true},
+	{errors.New("dial tcp: connection refused"), false, false},
+}
+
+func TestPostgresConnError(t *testing.T) {
+	for _, tt := range postgresConnErrorTests {
+		connError, ok := postgresConnError(tt.err)
+		if connError != tt.connError || ok != tt.ok {
+			t.Errorf("PostgresConnError(%v): expected %v %v, actual %v %v", tt.err, tt.connError, tt.ok, connError, ok)
+		}
+	}
+}
+
+// Returns the Postgres backend of the database of the
+// GOWORKER_POSTGRES_URI environment variable, skipping the
+// test without it.
+func testPostgresBackend(t *testing.T) *postgresBackend {
+	uri := os.Getenv("GOWORKER_POSTGRES_URI")
+	if uri == "" {
+		t.Skip("GOWORKER_POSTGRES_URI is not set")
+	}
+	b := NewPostgresBackend(uri).(*postgresBackend)
+	if err := b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`TRUNCATE goworker_queues, goworker_jobs, goworker_paused_queues, goworker_workers, goworker_stats, goworker_failures`)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPostgresBackendInvalidURI(t *testing.T) {
+	b := NewPostgresBackend("postgres://%zz")
+	defer b.Close()
+
+	if err := b.Ping(); err == nil {
+		t.Error("expecting an error for the invalid uri")
+	}
+	if _, _, err := b.Pop([]string{"test_postgres"}); err == nil {
+		t.Error("expecting Pop to return the error of the uri")
+	}
+}
+
+func TestPostgresBackendQueues(t *testing.T) {
+	b := testPostgresBackend(t)
+	defer b.Close()
+
+	wakeup := b.Wakeup()
+
+	b.Push("low", []byte(`{"class":"A"}`))
+	b.Push("high", []byte(`{"class":"B"}`))
+	b.Requeue("high", []byte(`{"class":"C"}`))
+
+	select {
+	case <-wakeup:
+	case <-time.After(5 * time.Second):
+		t.Error("expecting a wakeup after a push")
+	}
+
+	if size, _ := b.QueueSize("high"); size != 2 {
+		t.Errorf("expecting 2 jobs in high, but got %d", size)
+	}
+	if jobs, _ := b.Peek("high", 1, 1); len(jobs) != 1 || string(jobs[0]) != `{"class":"B"}` {
+		t.Errorf("expecting to peek job B, but got %q", jobs)
+	}
+
+	for _, expected := range []string{"C", "B", "A", ""} {
+		_, job, err := b.Pop([]string{"high", "low"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (expected == "" && job != nil) || (expected != "" && string(job) != `{"class":"`+expected+`"}`) {
+			t.Errorf("expecting job %q, but got %s", expected, job)
+		}
+	}
+}
+
+func TestPostgresBackendClaims(t *testing.T) {
+	b := testPostgresBackend(t)
+	defer b.Close()
+	restarted := &postgresBackend{db: b.db, uri: b.uri, consumer: "restarted", created: true}
+
+	// A job is deleted once acknowledged.
+	b.Push("test_claims", []byte(`{"class":"A"}`))
+	_, acked, _ := b.Pop([]string{"test_claims"})
+	if err := b.Ack("test_claims", acked); err != nil {
+		t.Fatal(err)
+	}
+	if _, job, _ := restarted.Pop([]string{"test_claims"}); job != nil {
+		t.Errorf("expecting the acknowledged job to be deleted, but got %s", job)
+	}
+
+	// A job claimed by a process which crashed is fetched
+	// again once its claim timed out, unless it is renewed.
+	cfg.postgresClaimTimeout = intervalOption(time.Second)
+	defer Configure(map[string]string{"postgresClaimTimeout": "300"})
+
+	b.Push("test_claims", []byte(`{"class":"B"}`))
+	_, running, _ := b.Pop([]string{"test_claims"})
+	claim := renewClaim(b, &job{Queue: "test_claims", raw: running})
+	time.Sleep(1500 * time.Millisecond)
+	if _, job, _ := restarted.Pop([]string{"test_claims"}); job != nil {
+		t.Errorf("expecting the running job not to be fetched, but got %s", job)
+	}
+	claim.release()
+	time.Sleep(1500 * time.Millisecond)
+	if _, job, _ := restarted.Pop([]string{"test_claims"}); string(job) != `{"class":"B"}` {
+		t.Errorf("expecting job B to be fetched again, but got %s", job)
+	}
+}
+
+func TestPostgresBackendFailures(t *testing.T) {
+	b := testPostgresBackend(t)
+	defer b.Close()
+
+	for i, e := range []string{"old", "new"} {
+		if err := b.SaveFailure(&Failure{
+			FailedAt:  time.Now().Add(time.Duration(i-2) * time.Hour),
+			Queue:     "test_postgres",
+			Class:     "TestPostgres",
+			Args:      []interface{}{e},
+			Exception: "errors.errorString",
+			Error:     e,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failures, err := b.Failures(0, 0, nil)
+	if err != nil || len(failures) != 2 {
+		t.Fatalf("expecting 2 failures, but got %v %v", failures, err)
+	}
+	if err := b.RetryFailure(failures[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RetryFailure(&Failure{key: failures[1].key, raw: []byte("{}")}); err != errorFailureChanged {
+		t.Errorf("expecting %v for a changed failure, but got %v", errorFailureChanged, err)
+	}
+	if _, job, _ := b.Pop([]string{"test_postgres"}); string(job) != `{"args":["new"],"attempt":1,"class":"TestPostgres"}` {
+		t.Errorf("expecting the job of the failure to be requeued, but got %s", job)
+	}
+
+	if pruned, err := b.PruneFailures(time.Now().Add(-90 * time.Minute)); err != nil || pruned != 1 {
+		t.Errorf("expecting 1 failure pruned, but got %d %v", pruned, err)
+	}
+}
+
+func TestEnqueueTx(t *testing.T) {
+	b := testPostgresBackend(t)
+	defer b.Close()
+
+	for _, commit := range []bool{false, true} {
+		tx, err := b.db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := EnqueueTx(tx, "test_enqueue_tx", "TestEnqueueTx", []interface{}{1}, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if size, _ := b.QueueSize("test_enqueue_tx"); size != 1 {
+		t.Errorf("expecting the job of the committed transaction only, but got %d jobs", size)
+	}
+}
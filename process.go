@@ -5,7 +5,6 @@ import (
 	"math/rand"
 	"os"
 	"strings"
-	"time"
 )
 
 type process struct {
@@ -33,46 +32,29 @@ func (p *process) String() string {
 	return fmt.Sprintf("%s:%d-%s:%s", p.Hostname, p.Pid, p.Id, strings.Join(p.Queues, ","))
 }
 
-func (p *process) open(conn *redisConn) error {
-	conn.Send("SADD", fmt.Sprintf("%sworkers", cfg.namespace), p)
-	conn.Send("SET", fmt.Sprintf("%sstat:processed:%v", cfg.namespace, p), "0")
-	conn.Send("SET", fmt.Sprintf("%sstat:failed:%v", cfg.namespace, p), "0")
-	conn.Flush()
-
-	return nil
+func (p *process) log() entry {
+	return logger.With("worker", p.String())
 }
 
-func (p *process) close(conn *redisConn) error {
-	logger.Infof("%v shutdown", p)
-	conn.Send("SREM", fmt.Sprintf("%sworkers", cfg.namespace), p)
-	conn.Send("DEL", fmt.Sprintf("%sstat:processed:%s", cfg.namespace, p))
-	conn.Send("DEL", fmt.Sprintf("%sstat:failed:%s", cfg.namespace, p))
-	conn.Flush()
-
-	return nil
+func (p *process) open(b Backend) error {
+	return b.RegisterWorker(p.String())
 }
 
-func (p *process) start(conn *redisConn) error {
-	conn.Send("SET", fmt.Sprintf("%sworker:%s:started", cfg.namespace, p), time.Now().String())
-	conn.Flush()
-
-	return nil
+func (p *process) close(b Backend) error {
+	p.log().Infof("%v shutdown", p)
+	return b.UnregisterWorker(p.String())
 }
 
-func (p *process) finish(conn *redisConn) error {
-	conn.Send("DEL", fmt.Sprintf("%sworker:%s", cfg.namespace, p))
-	conn.Send("DEL", fmt.Sprintf("%sworker:%s:started", cfg.namespace, p))
-	conn.Flush()
-
-	return nil
+func (p *process) start(b Backend) error {
+	return b.StartWork(p.String(), nil)
 }
 
-func (p *process) fail(conn *redisConn) error {
-	conn.Send("INCR", fmt.Sprintf("%sstat:failed", cfg.namespace))
-	conn.Send("INCR", fmt.Sprintf("%sstat:failed:%s", cfg.namespace, p))
-	conn.Flush()
+func (p *process) finish(b Backend) error {
+	return b.FinishWork(p.String())
+}
 
-	return nil
+func (p *process) fail(b Backend) error {
+	return b.IncrStats("failed", fmt.Sprintf("failed:%s", p))
 }
 
 func (p *process) queues(strict bool) []string {
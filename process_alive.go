@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package goworker
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns whether a process of this host runs with pid.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,8 @@
+package goworker
+
+// Returns whether a process of this host runs with pid.
+// Processes can not be probed on Windows, so workers are
+// never pruned.
+func processAlive(pid int) bool {
+	return true
+}
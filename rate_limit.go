@@ -0,0 +1,216 @@
+package goworker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimiters is implemented by the backends which count
+// the jobs run within sliding windows across processes,
+// like the Redis backends. Each job is counted under a
+// token of its own.
+type RateLimiters interface {
+	// TakeRate counts token under name unless limit
+	// tokens were counted within the last window. It then
+	// returns how long until the oldest one leaves the
+	// window instead.
+	TakeRate(name, token string, limit int, window time.Duration) (wait time.Duration, err error)
+
+	// ReturnRate removes a token counted by TakeRate.
+	ReturnRate(name, token string) error
+
+	// RateUsage returns the number of tokens counted
+	// within the last window, and when the oldest was.
+	RateUsage(name string, window time.Duration) (count int, oldest time.Time, err error)
+}
+
+var (
+	errorInvalidRateLimit = errors.New("The rate limit must be a positive number of jobs per duration, as in Class=100/1m.")
+	errorNoRateLimiters   = errors.New("The backend can not limit the rate of jobs.")
+)
+
+// RateLimit is a rate limit of the classRate or queueRate
+// options with its usage, as returned by RateLimits.
+type RateLimit struct {
+	// Class or Queue is the class or queue limited.
+	Class string `json:"class,omitempty"`
+	Queue string `json:"queue,omitempty"`
+
+	Limit  int           `json:"limit"`
+	Window time.Duration `json:"-"`
+
+	// Count is the number of jobs run within the last
+	// window, across processes.
+	Count int `json:"count"`
+
+	// NextAt is when the next job can run, or nil if one
+	// can run now.
+	NextAt *time.Time `json:"next_at"`
+}
+
+// MarshalJSON writes the window in seconds.
+func (r *RateLimit) MarshalJSON() ([]byte, error) {
+	type plain RateLimit
+	return json.Marshal(&struct {
+		*plain
+		Window float64 `json:"window"`
+	}{(*plain)(r), r.Window.Seconds()})
+}
+
+type rateLimit struct {
+	limit  int
+	window time.Duration
+}
+
+type rateLimitsOption map[string]rateLimit
+
+// Parses limits like Class=100/1m,Other=5/1s.
+func (l *rateLimitsOption) parse(value string) error {
+	limits := make(rateLimitsOption)
+	for _, nameAndLimit := range strings.Split(value, ",") {
+		if nameAndLimit == "" {
+			continue
+		}
+		parts := strings.SplitN(nameAndLimit, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return errorInvalidRateLimit
+		}
+		rate := strings.SplitN(parts[1], "/", 2)
+		if len(rate) != 2 {
+			return errorInvalidRateLimit
+		}
+		limit, err := strconv.Atoi(rate[0])
+		if err != nil || limit <= 0 {
+			return errorInvalidRateLimit
+		}
+		window, err := time.ParseDuration(rate[1])
+		if err != nil || window < time.Millisecond {
+			return errorInvalidRateLimit
+		}
+		limits[parts[0]] = rateLimit{limit, window}
+	}
+	*l = limits
+	return nil
+}
+
+// rateLimiter counts the jobs of the rate limited classes
+// and queues on the backend.
+type rateLimiter struct {
+	classes rateLimitsOption
+	queues  rateLimitsOption
+
+	backend RateLimiters
+	prefix  string
+}
+
+// Returns the rate limiter of the rate options for b, or
+// nil if no class or queue is limited.
+func newRateLimiter(b Backend) (*rateLimiter, error) {
+	if len(cfg.classRate) == 0 && len(cfg.queueRate) == 0 {
+		return nil, nil
+	}
+	backend, ok := b.(RateLimiters)
+	if !ok {
+		return nil, errorNoRateLimiters
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{
+		classes: cfg.classRate,
+		queues:  cfg.queueRate,
+		backend: backend,
+		prefix:  fmt.Sprintf("%s:%d:", hostname, os.Getpid()),
+	}, nil
+}
+
+// Counts job under its class and queue, and returns zero,
+// or how long until it can run if it is over a limit. It
+// is then not counted.
+func (l *rateLimiter) take(job *job) (time.Duration, error) {
+	var taken [][2]string
+	giveBack := func() {
+		for _, rate := range taken {
+			if err := l.backend.ReturnRate(rate[0], rate[1]); err != nil {
+				logger.Errorf("Error on returning rate token %s: %v", rate[0], err)
+			}
+		}
+	}
+
+	for _, name := range []string{"class:" + job.Payload.Class, "queue:" + job.Queue} {
+		limit, ok := l.limit(name)
+		if !ok {
+			continue
+		}
+		token := l.prefix + strconv.FormatInt(atomic.AddInt64(&tokens, 1), 10)
+		wait, err := l.backend.TakeRate(name, token, limit.limit, limit.window)
+		if err != nil || wait > 0 {
+			giveBack()
+			return wait, err
+		}
+		taken = append(taken, [2]string{name, token})
+	}
+	return 0, nil
+}
+
+func (l *rateLimiter) limit(name string) (rateLimit, bool) {
+	if strings.HasPrefix(name, "class:") {
+		limit, ok := l.classes[strings.TrimPrefix(name, "class:")]
+		return limit, ok
+	}
+	limit, ok := l.queues[strings.TrimPrefix(name, "queue:")]
+	return limit, ok
+}
+
+// RateLimits returns the rate limits of the classRate and
+// queueRate options, classes first, with the number of
+// jobs run within their window across processes.
+func RateLimits() (limits []*RateLimit, err error) {
+	for class, limit := range cfg.classRate {
+		limits = append(limits, &RateLimit{Class: class, Limit: limit.limit, Window: limit.window})
+	}
+	for queue, limit := range cfg.queueRate {
+		limits = append(limits, &RateLimit{Queue: queue, Limit: limit.limit, Window: limit.window})
+	}
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		if limits[i].Queue != limits[j].Queue {
+			return limits[i].Queue < limits[j].Queue
+		}
+		return limits[i].Class < limits[j].Class
+	})
+
+	err = withBackend(func(b Backend) error {
+		backend, ok := b.(RateLimiters)
+		if !ok {
+			return errorNoRateLimiters
+		}
+		for _, limit := range limits {
+			name := "class:" + limit.Class
+			if limit.Queue != "" {
+				name = "queue:" + limit.Queue
+			}
+			count, oldest, err := backend.RateUsage(name, limit.Window)
+			if err != nil {
+				return err
+			}
+			limit.Count = count
+			if count >= limit.Limit {
+				nextAt := oldest.Add(limit.Window)
+				limit.NextAt = &nextAt
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,199 @@
+package goworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rateLimitsTests = []struct {
+	v        string
+	expected rateLimitsOption
+	err      error
+}{
+	{"", rateLimitsOption{}, nil},
+	{"SendSMS=100/1m", rateLimitsOption{"SendSMS": {100, time.Minute}}, nil},
+	{"SendSMS=100/1m,Other=5/1.5s", rateLimitsOption{"SendSMS": {100, time.Minute}, "Other": {5, 1500 * time.Millisecond}}, nil},
+	{"SendSMS=100", nil, errorInvalidRateLimit},
+	{"SendSMS=0/1m", nil, errorInvalidRateLimit},
+	{"SendSMS=100/m", nil, errorInvalidRateLimit},
+	{"=100/1m", nil, errorInvalidRateLimit},
+}
+
+func TestRateLimitsOptionParse(t *testing.T) {
+	for _, tt := range rateLimitsTests {
+		var actual rateLimitsOption
+		err := actual.parse(tt.v)
+		if err != tt.err {
+			t.Errorf("RateLimits(%q): expected error %v, actual %v", tt.v, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("RateLimits(%q): expected %v, actual %v", tt.v, tt.expected, actual)
+		}
+	}
+}
+
+var unlimitedRate = map[string]string{
+	"classRate": "",
+	"queueRate": "",
+}
+
+func TestRateLimiters(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+
+	for name, b := range map[string]RateLimiters{
+		"memory": NewMemoryBackend().(RateLimiters),
+		"redis":  NewRedisBackend(p).(RateLimiters),
+	} {
+		for _, token := range []string{"a", "b", "c", "d"} {
+			b.ReturnRate("test", token)
+		}
+
+		for _, token := range []string{"a", "b"} {
+			if wait, err := b.TakeRate("test", token, 2, 200*time.Millisecond); err != nil || wait != 0 {
+				t.Fatalf("%s: expecting token %s to be counted, but got %v %v", name, token, wait, err)
+			}
+		}
+		if wait, _ := b.TakeRate("test", "c", 2, 200*time.Millisecond); wait <= 0 || wait > 200*time.Millisecond {
+			t.Errorf("%s: expecting to wait for the window, but got %v", name, wait)
+		}
+		if count, oldest, err := b.RateUsage("test", 200*time.Millisecond); err != nil || count != 2 || time.Since(oldest) > time.Second {
+			t.Errorf("%s: expecting 2 tokens, but got %d at %v %v", name, count, oldest, err)
+		}
+
+		b.ReturnRate("test", "b")
+		if wait, _ := b.TakeRate("test", "c", 2, 200*time.Millisecond); wait != 0 {
+			t.Errorf("%s: expecting a returned token to be free, but got %v", name, wait)
+		}
+
+		time.Sleep(250 * time.Millisecond)
+		if wait, _ := b.TakeRate("test", "d", 2, 200*time.Millisecond); wait != 0 {
+			t.Errorf("%s: expecting the tokens out of the window to be free, but got %v", name, wait)
+		}
+		if count, _, _ := b.RateUsage("test", 200*time.Millisecond); count != 1 {
+			t.Errorf("%s: expecting 1 token within the window, but got %d", name, count)
+		}
+		b.ReturnRate("test", "d")
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	Configure(map[string]string{"classRate": "A=1/1m", "queueRate": "test_rate=10/1s"})
+	defer Configure(unlimitedRate)
+	b := NewMemoryBackend()
+	SetBackend(b)
+	defer SetBackend(nil)
+
+	l, err := newRateLimiter(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.take(testJob("test_rate", "A")); wait != 0 {
+		t.Fatalf("expecting the job to run, but got %v", wait)
+	}
+	if wait, _ := l.take(testJob("test", "A")); wait == 0 {
+		t.Error("expecting a second job of A to wait")
+	}
+
+	limits, err := RateLimits()
+	if err != nil || len(limits) != 2 {
+		t.Fatalf("expecting 2 rate limits, but got %v %v", limits, err)
+	}
+	if limits[0].Class != "A" || limits[0].Count != 1 || limits[0].NextAt == nil {
+		t.Errorf("expecting the limit of A to be reached, but got %+v", limits[0])
+	}
+	if limits[1].Queue != "test_rate" || limits[1].Count != 1 || limits[1].NextAt != nil {
+		t.Errorf("expecting 1 job of test_rate, but got %+v", limits[1])
+	}
+
+	buffer, _ := json.Marshal(limits[1])
+	if string(buffer) != `{"queue":"test_rate","limit":10,"count":1,"next_at":null,"window":1}` {
+		t.Errorf("expecting the window in seconds, but got %s", buffer)
+	}
+}
+
+// schedulingBackend is a memory backend which stores the
+// delayed jobs of PushAt.
+type schedulingBackend struct {
+	*memoryBackend
+	delayed map[string]time.Time
+}
+
+func (b *schedulingBackend) PushAt(queue string, job []byte, at time.Time) error {
+	b.delayed[string(job)] = at
+	return nil
+}
+
+func TestPollerDelaysRateLimitedJobs(t *testing.T) {
+	Configure(map[string]string{"classRate": "A=1/1m"})
+	defer Configure(unlimitedRate)
+
+	b := &schedulingBackend{NewMemoryBackend().(*memoryBackend), make(map[string]time.Time)}
+	for _, job := range []string{`{"class":"A","args":[1]}`, `{"class":"A","args":[2]}`, `{"class":"B","args":[]}`} {
+		b.Push("test_rate", []byte(job))
+	}
+	p, err := newPoller([]string{"test_rate"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.rates, err = newRateLimiter(b); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"A", "", "B", ""} {
+		p.deferring = false
+		job, err := p.getJob(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var class string
+		if job != nil {
+			class = job.Payload.Class
+		}
+		if class != expected || p.deferring != (job == nil && expected == "" && i == 1) {
+			t.Errorf("%d: expecting job %q, but got %q", i, expected, class)
+		}
+	}
+	at, ok := b.delayed[`{"class":"A","args":[2]}`]
+	if !ok || time.Until(at) < 59*time.Second || time.Until(at) > time.Minute {
+		t.Errorf("expecting the second job of A to be delayed by a minute, but got %v", b.delayed)
+	}
+
+	var metrics bytes.Buffer
+	jobsDeferred.write(&metrics)
+	if line := `goworker_jobs_deferred_total{queue="test_rate",class="A",limit="rate"} `; !strings.Contains(metrics.String(), line) {
+		t.Errorf("expecting the deferral to be counted as %s, but got\n%s", line, metrics.String())
+	}
+}
+
+func TestPollerWaitsForRateLimitedJobs(t *testing.T) {
+	Configure(map[string]string{"classRate": "A=1/1m"})
+	defer Configure(unlimitedRate)
+
+	b := NewMemoryBackend()
+	for _, job := range []string{`{"class":"A","args":[1]}`, `{"class":"A","args":[2]}`} {
+		b.Push("test_rate_wait", []byte(job))
+	}
+	p, err := newPoller([]string{"test_rate_wait"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.rates, err = newRateLimiter(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, _ := p.getJob(b); job == nil {
+		t.Fatal("expecting the first job of A")
+	}
+	if job, _ := p.getJob(b); job != nil || !p.cycled {
+		t.Fatalf("expecting the poller to wait once the second job of A was deferred, but got %v", job)
+	}
+	// The memory backend does not store delayed jobs, so
+	// the poller waits until the job may run.
+	if wait := p.cycleWait(); wait < 59*time.Second || wait > time.Minute {
+		t.Errorf("expecting to wait a minute, but got %v", wait)
+	}
+}
@@ -1,8 +1,13 @@
 package goworker
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"io/ioutil"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -11,6 +16,7 @@ import (
 
 var (
 	errorInvalidScheme = errors.New("Invalid Redis database URI scheme.")
+	errorInvalidCA     = errors.New("No certificate found in the CA bundle.")
 	pool               *pools.ResourcePool
 )
 
@@ -40,34 +46,54 @@ func redisConnFromUri(uriString string) (*redisConn, error) {
 
 	var network string
 	var host string
+	var username string
 	var password string
 	var db string
+	var useTLS bool
 
 	switch uri.Scheme {
-	case "redis":
+	case "redis", "rediss":
 		network = "tcp"
 		host = uri.Host
-		if uri.User != nil {
-			password, _ = uri.User.Password()
-		}
 		if len(uri.Path) > 1 {
 			db = uri.Path[1:]
 		}
+		useTLS = uri.Scheme == "rediss"
 	case "unix":
 		network = "unix"
 		host = uri.Path
+	case "redis+cluster", "rediss+cluster":
+		return clusterConnFromUri(uriString, uri)
+	case "redis+sentinel":
+		var name string
+		name, db = parseSentinelPath(uri.Path)
+		if name == "" {
+			return nil, errorNoSentinelMaster
+		}
+		network = "tcp"
+		host, err = resolveSentinelMaster(strings.Split(uri.Host, ","), name, uri.Query().Get("sentinel_password"))
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, errorInvalidScheme
 	}
+	if uri.User != nil {
+		username = uri.User.Username()
+		password, _ = uri.User.Password()
+	}
 
-	conn, err := redis.Dial(network, host)
+	options, err := dialOptions(uri.Query(), useTLS)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := redis.Dial(network, host, options...)
 	if err != nil {
 		return nil, err
 	}
 
 	if password != "" {
-		_, err := conn.Do("AUTH", password)
-		if err != nil {
+		if err := auth(conn, username, password); err != nil {
 			conn.Close()
 			return nil, err
 		}
@@ -83,3 +109,99 @@ func redisConnFromUri(uriString string) (*redisConn, error) {
 
 	return &redisConn{Conn: conn}, nil
 }
+
+// Authenticates conn as the ACL user username of Redis 6.
+// As URIs used to carry an ignored username, like the h of
+// Heroku, the password alone is tried when Redis is older
+// and rejects the username. Any other error, like a wrong
+// password, is returned.
+func auth(conn redis.Conn, username string, password string) error {
+	if username != "" {
+		_, err := conn.Do("AUTH", username, password)
+		e, ok := err.(redis.Error)
+		if !ok || !strings.HasPrefix(string(e), "ERR wrong number of arguments") {
+			return err
+		}
+	}
+	_, err := conn.Do("AUTH", password)
+	return err
+}
+
+// Returns the options to dial Redis with, from the query
+// parameters of the URI, which override the options of
+// the configuration:
+//
+//	dial_timeout, read_timeout, write_timeout: in seconds
+//	tls_ca: path to the PEM bundle of the CAs to trust
+//	tls_cert, tls_key: paths to the PEM client certificate and key
+//	tls_server_name: name to verify the certificate against
+//	tls_skip_verify: true to skip the verification
+func dialOptions(query url.Values, useTLS bool) ([]redis.DialOption, error) {
+	get := func(key string, value string) string {
+		if v := query.Get(key); v != "" {
+			return v
+		}
+		return value
+	}
+
+	var options []redis.DialOption
+	for _, timeout := range []struct {
+		key    string
+		value  intervalOption
+		option func(time.Duration) redis.DialOption
+	}{
+		{"dial_timeout", cfg.dialTimeout, redis.DialConnectTimeout},
+		{"read_timeout", cfg.readTimeout, redis.DialReadTimeout},
+		{"write_timeout", cfg.writeTimeout, redis.DialWriteTimeout},
+	} {
+		d := timeout.value
+		if v := query.Get(timeout.key); v != "" {
+			if err := d.parse(v); err != nil {
+				return nil, err
+			}
+		}
+		if d > 0 {
+			options = append(options, timeout.option(time.Duration(d)))
+		}
+	}
+
+	if !useTLS {
+		return options, nil
+	}
+	skipVerify, err := strconv.ParseBool(get("tls_skip_verify", strconv.FormatBool(cfg.tlsSkipVerify)))
+	if err != nil {
+		return nil, err
+	}
+	config, err := newTLSConfig(get("tls_ca", cfg.tlsCA), get("tls_cert", cfg.tlsCert), get("tls_key", cfg.tlsKey), get("tls_server_name", cfg.tlsServerName), skipVerify)
+	if err != nil {
+		return nil, err
+	}
+	return append(options, redis.DialUseTLS(true), redis.DialTLSConfig(config), redis.DialTLSSkipVerify(skipVerify)), nil
+}
+
+func newTLSConfig(ca string, cert string, key string, serverName string, skipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errorInvalidCA
+		}
+	}
+
+	if cert != "" || key != "" {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
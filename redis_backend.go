@@ -0,0 +1,418 @@
+package goworker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+// redisBackend is the default Backend, which stores
+// everything in Redis with the keys of Resque under the
+// namespace option.
+type redisBackend struct {
+	pool      *pools.ResourcePool
+	closePool bool
+}
+
+// NewRedisBackend returns the Redis backend using pool,
+// which is not closed with the backend.
+func NewRedisBackend(pool *pools.ResourcePool) Backend {
+	return &redisBackend{pool: pool}
+}
+
+func (b *redisBackend) key(format string, args ...interface{}) string {
+	return cfg.namespace + fmt.Sprintf(format, args...)
+}
+
+func (b *redisBackend) Push(queue string, job []byte) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("SADD", b.key("queues"), queue)
+		conn.Send("RPUSH", b.key("queue:%s", queue), job)
+		return flush(conn)
+	})
+}
+
+func (b *redisBackend) Requeue(queue string, job []byte) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("LPUSH", b.key("queue:%s", queue), job)
+		return err
+	})
+}
+
+func (b *redisBackend) Pop(queues []string) (queue string, job []byte, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		for _, queue = range queues {
+			reply, err := redis.Bytes(conn.Do("LPOP", b.key("queue:%s", queue)))
+			if err == redis.ErrNil {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			job = reply
+			return nil
+		}
+		queue = ""
+		return nil
+	})
+	return
+}
+
+func (b *redisBackend) Queues() (queues []string, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		queues, err = redis.Strings(conn.Do("SMEMBERS", b.key("queues")))
+		return err
+	})
+	sort.Strings(queues)
+	return
+}
+
+func (b *redisBackend) QueueSize(queue string) (size int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		size, err = redis.Int(conn.Do("LLEN", b.key("queue:%s", queue)))
+		return err
+	})
+	return
+}
+
+func (b *redisBackend) Peek(queue string, offset, limit int) (jobs [][]byte, err error) {
+	stop := -1
+	if limit > 0 {
+		stop = offset + limit - 1
+	}
+	err = withConn(b.pool, func(conn *redisConn) error {
+		jobs, err = redis.ByteSlices(conn.Do("LRANGE", b.key("queue:%s", queue), offset, stop))
+		return err
+	})
+	return
+}
+
+func (b *redisBackend) ClearQueue(queue string) (cleared int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("MULTI")
+		conn.Send("LLEN", b.key("queue:%s", queue))
+		conn.Send("DEL", b.key("queue:%s", queue))
+		replies, err := redis.Values(conn.Do("EXEC"))
+		if err == nil {
+			cleared, err = redis.Int(replies[0], nil)
+		}
+		return err
+	})
+	return
+}
+
+// PauseQueue sets the pause:queue:<name> key of the
+// resque-pause plugin, so that the Resque workers using it
+// pause the queue too.
+func (b *redisBackend) PauseQueue(queue string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("SET", b.key("pause:queue:%s", queue), "true")
+		return err
+	})
+}
+
+func (b *redisBackend) ResumeQueue(queue string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", b.key("pause:queue:%s", queue))
+		return err
+	})
+}
+
+func (b *redisBackend) PausedQueues() (paused []string, err error) {
+	queues, err := b.Queues()
+	if err != nil || len(queues) == 0 {
+		return nil, err
+	}
+	err = withConn(b.pool, func(conn *redisConn) error {
+		for _, queue := range queues {
+			conn.Send("EXISTS", b.key("pause:queue:%s", queue))
+		}
+		exist, err := redis.Ints(conn.Do(""))
+		if err != nil {
+			return err
+		}
+		for i, queue := range queues {
+			if exist[i] == 1 {
+				paused = append(paused, queue)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Takes a slot of the sorted set of a concurrency limit,
+// whose scores are the expiry of the leases in
+// milliseconds, once the expired ones are removed. A token
+// which already holds a slot keeps it.
+//
+// KEYS: slots sorted set
+// ARGV: token, limit, now, expiry, lease
+var acquireSlotScript = redis.NewScript(1, `
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[3])
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) or redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[1], ARGV[4], ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], ARGV[5])
+	return 1
+end
+return 0
+`)
+
+func (b *redisBackend) AcquireSlot(name, token string, limit int, lease time.Duration) (acquired bool, err error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	ms := int64(lease / time.Millisecond)
+	err = withConn(b.pool, func(conn *redisConn) error {
+		acquired, err = redis.Bool(acquireSlotScript.Do(conn.Conn, b.key("concurrency:%s", name),
+			token, limit, now, now+ms, ms))
+		return err
+	})
+	return
+}
+
+func (b *redisBackend) RenewSlot(name, token string, lease time.Duration) error {
+	ms := int64(lease / time.Millisecond)
+	expiry := time.Now().UnixNano()/int64(time.Millisecond) + ms
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("ZADD", b.key("concurrency:%s", name), "XX", expiry, token)
+		conn.Send("PEXPIRE", b.key("concurrency:%s", name), ms)
+		return flush(conn)
+	})
+}
+
+func (b *redisBackend) ReleaseSlot(name, token string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("ZREM", b.key("concurrency:%s", name), token)
+		return err
+	})
+}
+
+// Counts a token in the sorted set of a rate limit, whose
+// scores are the times of the tokens in milliseconds, once
+// the ones out of the window are removed. Over the limit,
+// it returns the milliseconds until the oldest token
+// leaves the window instead.
+//
+// KEYS: tokens sorted set
+// ARGV: token, limit, now, window
+var takeRateScript = redis.NewScript(1, `
+local now = tonumber(ARGV[3])
+local window = tonumber(ARGV[4])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+if redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[1], now, ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], window)
+	return 0
+end
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+return tonumber(oldest[2]) + window - now
+`)
+
+func (b *redisBackend) TakeRate(name, token string, limit int, window time.Duration) (wait time.Duration, err error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	err = withConn(b.pool, func(conn *redisConn) error {
+		ms, err := redis.Int64(takeRateScript.Do(conn.Conn, b.key("rate:%s", name),
+			token, limit, now, int64(window/time.Millisecond)))
+		wait = time.Duration(ms) * time.Millisecond
+		return err
+	})
+	return
+}
+
+func (b *redisBackend) ReturnRate(name, token string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("ZREM", b.key("rate:%s", name), token)
+		return err
+	})
+}
+
+func (b *redisBackend) RateUsage(name string, window time.Duration) (count int, oldest time.Time, err error) {
+	start := fmt.Sprintf("(%d", (time.Now().UnixNano()-int64(window))/int64(time.Millisecond))
+	err = withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("ZCOUNT", b.key("rate:%s", name), start, "+inf")
+		conn.Send("ZRANGEBYSCORE", b.key("rate:%s", name), start, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+		replies, err := redis.Values(conn.Do(""))
+		if err != nil {
+			return err
+		}
+		if count, err = redis.Int(replies[0], nil); err != nil {
+			return err
+		}
+		first, err := redis.Strings(replies[1], nil)
+		if err != nil || len(first) < 2 {
+			return err
+		}
+		ms, err := strconv.ParseInt(first[1], 10, 64)
+		oldest = time.Unix(0, ms*int64(time.Millisecond))
+		return err
+	})
+	return
+}
+
+// SetRemoteConcurrency stores the concurrency of process,
+// or of every process, which is removed if zero.
+func (b *redisBackend) SetRemoteConcurrency(process string, concurrency int) error {
+	key := b.key("remote_concurrency")
+	if process != "" {
+		key = b.key("remote_concurrency:%s", process)
+	}
+	return withConn(b.pool, func(conn *redisConn) error {
+		var err error
+		if concurrency > 0 {
+			_, err = conn.Do("SET", key, concurrency)
+		} else {
+			_, err = conn.Do("DEL", key)
+		}
+		return err
+	})
+}
+
+func (b *redisBackend) RemoteConcurrency(process string) (concurrency int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("GET", b.key("remote_concurrency:%s", process))
+		conn.Send("GET", b.key("remote_concurrency"))
+		values, err := redis.Values(conn.Do(""))
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			if value != nil {
+				concurrency, err = redis.Int(value, nil)
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Returns the pool of the backend, to resize it and to
+// save the failures and read the stats of the pool.
+func (b *redisBackend) resourcePool() *pools.ResourcePool {
+	return b.pool
+}
+
+func (b *redisBackend) RegisterWorker(worker string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("SADD", b.key("workers"), worker)
+		conn.Send("SET", b.key("stat:processed:%s", worker), "0")
+		conn.Send("SET", b.key("stat:failed:%s", worker), "0")
+		return flush(conn)
+	})
+}
+
+func (b *redisBackend) UnregisterWorker(worker string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("SREM", b.key("workers"), worker)
+		conn.Send("DEL", b.key("stat:processed:%s", worker))
+		conn.Send("DEL", b.key("stat:failed:%s", worker))
+		conn.Send("DEL", b.key("worker:%s", worker))
+		conn.Send("DEL", b.key("worker:%s:started", worker))
+		return flush(conn)
+	})
+}
+
+func (b *redisBackend) StartWork(worker string, work []byte) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		if work != nil {
+			conn.Send("SET", b.key("worker:%s", worker), work)
+		}
+		conn.Send("SET", b.key("worker:%s:started", worker), time.Now().String())
+		return flush(conn)
+	})
+}
+
+func (b *redisBackend) FinishWork(worker string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("DEL", b.key("worker:%s", worker))
+		conn.Send("DEL", b.key("worker:%s:started", worker))
+		return flush(conn)
+	})
+}
+
+func (b *redisBackend) Workers() (workers []string, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		workers, err = redis.Strings(conn.Do("SMEMBERS", b.key("workers")))
+		return err
+	})
+	sort.Strings(workers)
+	return
+}
+
+func (b *redisBackend) WorkerJob(worker string) (work []byte, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		work, err = redis.Bytes(conn.Do("GET", b.key("worker:%s", worker)))
+		if err == redis.ErrNil {
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+func (b *redisBackend) IncrStats(stats ...string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		for _, stat := range stats {
+			conn.Send("INCR", b.key("stat:%s", stat))
+		}
+		return flush(conn)
+	})
+}
+
+func (b *redisBackend) Stat(stat string) (value int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		value, err = redis.Int(conn.Do("GET", b.key("stat:%s", stat)))
+		if err == redis.ErrNil {
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+func (b *redisBackend) SaveFailure(failure *Failure) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		return pushFailure(conn, b.key("failed"), failure)
+	})
+}
+
+func (b *redisBackend) Failures(offset, limit int, filter *FailureFilter) ([]*Failure, error) {
+	return FailuresWithPool(b.pool, offset, limit, filter)
+}
+
+func (b *redisBackend) FailureCount() (int, error) {
+	return FailureCountWithPool(b.pool)
+}
+
+func (b *redisBackend) RetryFailure(failure *Failure) error {
+	return RetryFailureWithPool(b.pool, failure)
+}
+
+func (b *redisBackend) RemoveFailure(failure *Failure) error {
+	return RemoveFailureWithPool(b.pool, failure)
+}
+
+func (b *redisBackend) ClearFailures() error {
+	return ClearFailuresWithPool(b.pool)
+}
+
+func (b *redisBackend) PruneFailures(cutoff time.Time) (int, error) {
+	return pruneFailures(b.pool, cutoff)
+}
+
+func (b *redisBackend) Ping() error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("PING")
+		return err
+	})
+}
+
+func (b *redisBackend) Close() error {
+	if b.closePool {
+		b.pool.Close()
+	}
+	return nil
+}
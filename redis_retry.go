@@ -0,0 +1,154 @@
+package goworker
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+// Bounds of the delay between two attempts to reach Redis.
+const (
+	reconnectMinDelay = 100 * time.Millisecond
+	reconnectMaxDelay = 10 * time.Second
+)
+
+var errorRedisOutage = errors.New("Redis has been unavailable for longer than the outage budget.")
+
+// outageError is returned once Redis has been unavailable
+// for longer than the outageBudget option.
+type outageError struct {
+	err error
+}
+
+func (e *outageError) Error() string {
+	return errorRedisOutage.Error() + " Last error: " + e.err.Error()
+}
+
+func (e *outageError) Unwrap() error {
+	return e.err
+}
+
+// backoff computes exponentially growing delays with
+// jitter, so that workers do not reconnect in lockstep.
+type backoff struct {
+	attempt uint
+}
+
+func (b *backoff) next() time.Duration {
+	delay := reconnectMaxDelay
+	if b.attempt < 16 {
+		if d := reconnectMinDelay << b.attempt; d < reconnectMaxDelay {
+			delay = d
+		}
+	}
+	b.attempt++
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Returns whether err means that Redis, or the backend,
+// could not be reached, rather than that it rejected a
+// command or that the data was invalid. A READONLY reply
+// comes from a master demoted by a failover: the
+// connection is dropped and reopened to the new master.
+func isConnError(err error) bool {
+	if err == nil || err == pools.CLOSED_ERR {
+		return false
+	}
+	if connError, ok := postgresConnError(err); ok {
+		return connError
+	}
+	if connError, ok := sqliteConnError(err); ok {
+		return connError
+	}
+	if e, ok := err.(redis.Error); ok {
+		return strings.HasPrefix(string(e), "LOADING ") || strings.HasPrefix(string(e), "READONLY ")
+	}
+
+	var netError net.Error
+	switch {
+	case errors.As(err, &netError):
+		return true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, driver.ErrBadConn):
+		return true
+	case err == errorNoClusterNode, err == errorClusterConnClose, err == errorNoSentinel:
+		// Every node or sentinel failed to answer.
+		return true
+	}
+	return false
+}
+
+// Runs fn with a connection of p, once. A connection
+// broken by fn is closed rather than put back in the pool.
+func withConn(p *pools.ResourcePool, fn func(conn *redisConn) error) error {
+	resource, err := p.Get()
+	if err != nil {
+		return err
+	}
+	conn := resource.(*redisConn)
+
+	err = fn(conn)
+	if isConnError(err) || conn.Err() != nil {
+		conn.Close()
+		p.Put(nil)
+	} else {
+		p.Put(conn)
+	}
+	return err
+}
+
+// Runs fn with a connection of p, retrying with exponential
+// backoff while Redis cannot be reached, like retry.
+func withRetry(p *pools.ResourcePool, done <-chan struct{}, fn func(conn *redisConn) error) error {
+	return retry(done, func() error {
+		return withConn(p, fn)
+	})
+}
+
+// Runs fn, retrying with exponential backoff while it
+// fails because the backend cannot be reached. It gives up
+// when done is closed, returning the last error, or when
+// the outage budget is spent, returning an *outageError.
+// Other errors of fn are returned as is.
+func retry(done <-chan struct{}, fn func() error) error {
+	var b backoff
+	var since time.Time
+
+	for {
+		err := fn()
+		if !isConnError(err) {
+			redisRoundTrip(nil)
+			return err
+		}
+		redisRoundTrip(err)
+
+		if since.IsZero() {
+			since = time.Now()
+		}
+		budget := time.Duration(cfg.outageBudget)
+		if budget > 0 && time.Since(since) >= budget {
+			return &outageError{err}
+		}
+
+		delay := b.next()
+		logger.Warnf("Error on reaching the backend, retrying in %v: %v", delay, err)
+		select {
+		case <-done:
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Returns the replies of the commands sent on conn, or the
+// first error among them.
+func flush(conn *redisConn) error {
+	_, err := conn.Do("")
+	return err
+}
@@ -0,0 +1,106 @@
+package goworker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+func TestBackoff(t *testing.T) {
+	var b backoff
+	for i, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		if d := b.next(); d < max/2 || d > max {
+			t.Errorf("Backoff(%d): expected a delay between %v and %v, actual %v", i, max/2, max, d)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		b.next()
+	}
+	if d := b.next(); d < reconnectMaxDelay/2 || d > reconnectMaxDelay {
+		t.Errorf("Backoff: expected a delay capped by %v, actual %v", reconnectMaxDelay, d)
+	}
+}
+
+var isConnErrorTests = []struct {
+	err      error
+	expected bool
+}{
+	{nil, false},
+	{io.EOF, true},
+	{io.ErrUnexpectedEOF, true},
+	{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+	{errorNoClusterNode, true},
+	{errors.New("invalid character 'x' looking for beginning of value"), false},
+	{redis.Error("ERR unknown command"), false},
+	{redis.Error("LOADING Redis is loading the dataset in memory"), true},
+	{redis.Error("READONLY You can't write against a read only replica."), true},
+	{pools.CLOSED_ERR, false},
+}
+
+func TestIsConnError(t *testing.T) {
+	for _, tt := range isConnErrorTests {
+		if actual := isConnError(tt.err); actual != tt.expected {
+			t.Errorf("IsConnError(%v): expected %v, actual %v", tt.err, tt.expected, actual)
+		}
+	}
+}
+
+func TestWithRetryOutageBudget(t *testing.T) {
+	budget := cfg.outageBudget
+	defer func() { cfg.outageBudget = budget }()
+	Configure(map[string]string{"outageBudget": "0.3"})
+
+	// Nothing listens on port 1.
+	p := newRedisPool("redis://127.0.0.1:1/", 1, 1, time.Minute)
+	defer p.Close()
+
+	calls := 0
+	err := withRetry(p, nil, func(conn *redisConn) error {
+		calls++
+		return nil
+	})
+	if _, ok := err.(*outageError); !ok {
+		t.Errorf("expecting an outage error, but got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("expecting no call without a connection, but got %d", calls)
+	}
+}
+
+func TestWithRetryRecovers(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+
+	calls := 0
+	err := withRetry(p, nil, func(conn *redisConn) error {
+		calls++
+		if calls < 3 {
+			conn.Conn.Close()
+		}
+		_, err := conn.Do("PING")
+		return err
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expecting a success on the third call, but got %v after %d calls", err, calls)
+	}
+}
+
+func TestEnqueueWithoutRedis(t *testing.T) {
+	p := newRedisPool("redis://127.0.0.1:1/", 1, 1, time.Minute)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	for _, dedupe := range []bool{false, true} {
+		if err := EnqueueWithPoolContext(ctx, p, "test_outage", "TestOutage", nil, dedupe); err == nil {
+			t.Errorf("expecting an error without Redis (dedupe %v)", dedupe)
+		}
+	}
+}
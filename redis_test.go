@@ -0,0 +1,192 @@
+package goworker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var dialOptionsTests = []struct {
+	query    string
+	useTLS   bool
+	expected int
+}{
+	{"", false, 1},
+	{"dial_timeout=0", false, 0},
+	{"read_timeout=1.5&write_timeout=2", false, 3},
+	{"tls_server_name=redis", true, 4},
+}
+
+func TestDialOptions(t *testing.T) {
+	for _, tt := range dialOptionsTests {
+		query, _ := url.ParseQuery(tt.query)
+		options, err := dialOptions(query, tt.useTLS)
+		if err != nil {
+			t.Errorf("DialOptions(%s): error %s", tt.query, err)
+		} else if len(options) != tt.expected {
+			t.Errorf("DialOptions(%s): expected %d options, actual %d", tt.query, tt.expected, len(options))
+		}
+	}
+
+	query, _ := url.ParseQuery("read_timeout=soon")
+	if _, err := dialOptions(query, false); err == nil {
+		t.Error("DialOptions: expected an error for an invalid timeout")
+	}
+	query, _ = url.ParseQuery("tls_ca=/nonexistent.pem")
+	if _, err := dialOptions(query, true); err == nil {
+		t.Error("DialOptions: expected an error for a missing CA bundle")
+	}
+}
+
+// Writes a self-signed certificate for localhost and its
+// key to dir, and returns their paths.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := filepath.Join(dir, "cert.pem")
+	ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return cert, keyFile
+}
+
+func TestRedissConn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key := writeTestCertificate(t, dir)
+
+	certificate, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Proxies the TLS connections to the test Redis.
+	uri, _ := url.Parse(cfg.uri)
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", uri.Host)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			go func() {
+				io.Copy(server, client)
+				server.Close()
+			}()
+			go func() {
+				io.Copy(client, server)
+				client.Close()
+			}()
+		}
+	}()
+
+	conn, err := redisConnFromUri(fmt.Sprintf("rediss://%s/?tls_ca=%s&tls_server_name=localhost&read_timeout=5", listener.Addr(), cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		t.Errorf("expecting a PING over TLS, but got %v", err)
+	}
+
+	if _, err := redisConnFromUri(fmt.Sprintf("rediss://%s/", listener.Addr())); err == nil {
+		t.Error("expecting an error for an untrusted certificate")
+	}
+}
+
+// authConn replies to AUTH like a Redis server whose
+// default user has the password secret, and which knows
+// the ACL users of users unless it is older than 6.
+type authConn struct {
+	redis.Conn
+	users    map[string]string
+	commands [][]interface{}
+}
+
+func (c *authConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.commands = append(c.commands, args)
+	switch {
+	case len(args) == 2 && c.users == nil:
+		return nil, redis.Error("ERR wrong number of arguments for 'auth' command")
+	case len(args) == 2 && c.users[args[0].(string)] != args[1]:
+		return nil, redis.Error("WRONGPASS invalid username-password pair or user is disabled.")
+	case len(args) == 1 && args[0] != "secret":
+		return nil, redis.Error("ERR invalid password")
+	}
+	return "OK", nil
+}
+
+var authTests = []struct {
+	users    map[string]string
+	username string
+	password string
+	commands int
+	err      bool
+}{
+	{map[string]string{"app": "secret"}, "app", "secret", 1, false},
+	{map[string]string{"app": "secret"}, "app", "wrong", 1, true},
+	{map[string]string{"app": "secret"}, "revoked", "secret", 1, true},
+	{nil, "h", "secret", 2, false},
+	{nil, "h", "wrong", 2, true},
+	{nil, "", "secret", 1, false},
+}
+
+func TestAuth(t *testing.T) {
+	for _, tt := range authTests {
+		conn := &authConn{users: tt.users}
+		err := auth(conn, tt.username, tt.password)
+		if (err != nil) != tt.err || len(conn.commands) != tt.commands {
+			t.Errorf("Auth(%s, %s): expected error %v after %d commands, actual %v after %v", tt.username, tt.password, tt.err, tt.commands, err, conn.commands)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package goworker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Timeout of the requests to a sentinel, after which the
+// next sentinel is asked.
+const sentinelTimeout = 3 * time.Second
+
+var (
+	errorNoSentinelMaster = errors.New("A redis+sentinel URI must name the master, as in redis+sentinel://host:26379/mymaster.")
+	errorNoSentinel       = errors.New("No sentinel knows the master.")
+)
+
+// Returns the master name and the database of the path of
+// a redis+sentinel URI, /name or /name/db.
+func parseSentinelPath(path string) (name string, db string) {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	name = parts[0]
+	if len(parts) == 2 {
+		db = parts[1]
+	}
+	return
+}
+
+// Asks the sentinels in turn for the address of the master
+// name. It is called for every new connection, so that
+// connections discarded after a failover, because they
+// failed or their server replied READONLY, are opened to
+// the new master.
+func resolveSentinelMaster(sentinels []string, name string, password string) (string, error) {
+	err := errorNoSentinel
+	for _, sentinel := range sentinels {
+		var addr string
+		if addr, err = askSentinel(sentinel, name, password); err == nil {
+			return addr, nil
+		}
+		logger.Warnf("Error on asking sentinel %s for master %s: %v", sentinel, name, err)
+	}
+	return "", err
+}
+
+func askSentinel(sentinel string, name string, password string) (string, error) {
+	conn, err := redis.Dial("tcp", sentinel,
+		redis.DialConnectTimeout(sentinelTimeout),
+		redis.DialReadTimeout(sentinelTimeout),
+		redis.DialWriteTimeout(sentinelTimeout))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if password != "" {
+		if _, err := conn.Do("AUTH", password); err != nil {
+			return "", err
+		}
+	}
+
+	reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", name))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("Sentinel %s does not know master %s.", sentinel, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("Sentinel %s replied %v for master %s.", sentinel, reply, name)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
@@ -0,0 +1,117 @@
+package goworker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a fake Redis server answering commands with the
+// RESP replies of reply, and returns its address.
+func startFakeRedis(t *testing.T, reply func(args []string) string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeRedis(conn, reply)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveFakeRedis(conn net.Conn, reply func(args []string) string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		// Commands are arrays of bulk strings.
+		var count int
+		if _, err := fmt.Fscanf(reader, "*%d\r\n", &count); err != nil {
+			return
+		}
+		args := make([]string, count)
+		for i := range args {
+			var size int
+			fmt.Fscanf(reader, "$%d\r\n", &size)
+			buffer := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, buffer); err != nil {
+				return
+			}
+			args[i] = string(buffer[:size])
+		}
+
+		fmt.Fprint(conn, reply(args))
+	}
+}
+
+// Starts a fake sentinel which knows master mymaster at
+// masterAddr, and returns its address.
+func startFakeSentinel(t *testing.T, masterAddr string) string {
+	return startFakeRedis(t, func(args []string) string {
+		if len(args) == 3 && strings.ToUpper(args[0]) == "SENTINEL" && args[2] == "mymaster" {
+			host, port, _ := net.SplitHostPort(masterAddr)
+			return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		}
+		return "*-1\r\n"
+	})
+}
+
+func TestParseSentinelPath(t *testing.T) {
+	for path, expected := range map[string][2]string{
+		"":             {"", ""},
+		"/mymaster":    {"mymaster", ""},
+		"/mymaster/":   {"mymaster", ""},
+		"/mymaster/3":  {"mymaster", "3"},
+		"mymaster/3/x": {"mymaster", "3/x"},
+	} {
+		name, db := parseSentinelPath(path)
+		if name != expected[0] || db != expected[1] {
+			t.Errorf("ParseSentinelPath(%q): expected %v, actual %s %s", path, expected, name, db)
+		}
+	}
+}
+
+func TestSentinelConn(t *testing.T) {
+	uri, _ := url.Parse(cfg.uri)
+	sentinel := startFakeSentinel(t, uri.Host)
+
+	// The first sentinel is down.
+	conn, err := redisConnFromUri(fmt.Sprintf("redis+sentinel://127.0.0.1:1,%s/mymaster", sentinel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		t.Errorf("expecting a connection to the master, but got %v", err)
+	}
+
+	if _, err := redisConnFromUri(fmt.Sprintf("redis+sentinel://%s/unknown", sentinel)); err == nil {
+		t.Error("expecting an error for an unknown master")
+	}
+	if _, err := redisConnFromUri(fmt.Sprintf("redis+sentinel://%s/", sentinel)); err != errorNoSentinelMaster {
+		t.Errorf("expecting %v without a master name, but got %v", errorNoSentinelMaster, err)
+	}
+}
+
+func TestSentinelPool(t *testing.T) {
+	uri, _ := url.Parse(cfg.uri)
+	p := newRedisPool(fmt.Sprintf("redis+sentinel://%s/mymaster", startFakeSentinel(t, uri.Host)), 1, 1, time.Minute)
+	defer p.Close()
+
+	if err := withRetry(p, nil, func(conn *redisConn) error {
+		_, err := conn.Do("PING")
+		return err
+	}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,664 @@
+package goworker
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+const (
+	// Period of the heartbeat of the process, and of the
+	// enqueueing of the due retries and scheduled jobs, as
+	// in Sidekiq.
+	sidekiqBeatInterval = 5 * time.Second
+
+	// Lifetime of the process hashes, which expire when the
+	// process stops beating.
+	sidekiqProcessTTL = 60 * time.Second
+
+	// Retries of the jobs which do not give theirs.
+	sidekiqMaxRetries = 25
+
+	// Bounds of the dead set, as in Sidekiq.
+	sidekiqDeadMaxJobs = 10000
+	sidekiqDeadTimeout = 180 * 24 * time.Hour
+)
+
+// Moves the jobs of a sorted set which are due to their
+// queue, like the scheduled poller of Sidekiq.
+//
+// KEYS: retry or schedule set, queues set
+// ARGV: now, prefix of the queue keys
+var sidekiqEnqueueScript = redis.NewScript(2, `
+local jobs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 100)
+for _, job in ipairs(jobs) do
+	if redis.call("ZREM", KEYS[1], job) == 1 then
+		local queue = cjson.decode(job)["queue"]
+		redis.call("SADD", KEYS[2], queue)
+		redis.call("LPUSH", ARGV[2] .. queue, job)
+	end
+end
+return #jobs
+`)
+
+// Moves a job from a sorted set to its queue, unless it
+// was removed from the set.
+//
+// KEYS: retry or dead set, queue, queues set
+// ARGV: job, queue name
+var sidekiqRetryScript = redis.NewScript(3, `
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("SADD", KEYS[3], ARGV[2])
+redis.call("LPUSH", KEYS[2], ARGV[1])
+return 1
+`)
+
+// sidekiqBackend reads and writes the keys of Sidekiq
+// instead of those of Resque, so that goworker runs the
+// jobs enqueued by Sidekiq clients and shows in the Sidekiq
+// web UI. Jobs are pushed to the left of the queue:<name>
+// lists and popped from the right. Failed jobs go to the
+// retry set, with the delays of Sidekiq, then to the dead
+// set, and the due retries and scheduled jobs are moved to
+// their queue by every process.
+//
+// The process is registered in the processes set with a
+// heartbeat, and the jobs of its workers in its work hash.
+// Only the processed and failed stats exist in Sidekiq,
+// with their daily counts.
+type sidekiqBackend struct {
+	*redisBackend
+
+	hostname string
+	identity string
+	started  time.Time
+
+	mutex   sync.Mutex
+	workers map[string]bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewSidekiqBackend returns the Sidekiq backend using pool,
+// which is not closed with the backend.
+func NewSidekiqBackend(pool *pools.ResourcePool) Backend {
+	return newSidekiqBackend(&redisBackend{pool: pool})
+}
+
+func newSidekiqBackend(b *redisBackend) *sidekiqBackend {
+	hostname, _ := os.Hostname()
+	nonce := make([]byte, 6)
+	rand.Read(nonce)
+	return &sidekiqBackend{
+		redisBackend: b,
+		hostname:     hostname,
+		identity:     fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(nonce)),
+		started:      time.Now(),
+		workers:      make(map[string]bool),
+	}
+}
+
+// Returns time as the floating Unix time of Sidekiq.
+func sidekiqTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// Returns the floating Unix time of Sidekiq as a time.
+func parseSidekiqTime(value interface{}) time.Time {
+	var seconds float64
+	switch value := value.(type) {
+	case json.Number:
+		seconds, _ = value.Float64()
+	case float64:
+		seconds = value
+	default:
+		return time.Time{}
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// Decodes a job keeping its numbers as they are.
+func decodeSidekiqJob(job []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(job))
+	decoder.UseNumber()
+
+	var msg map[string]interface{}
+	if err := decoder.Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Returns a job of goworker in the format of Sidekiq, with
+// its jid, queue, retry, created_at and enqueued_at.
+func sidekiqJob(queue string, job []byte, enqueuedAt time.Time) ([]byte, error) {
+	msg, err := decodeSidekiqJob(job)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := msg["jid"]; !ok {
+		if id, ok := msg["id"]; ok {
+			msg["jid"] = id
+		} else {
+			msg["jid"] = newJobID()
+		}
+	}
+	delete(msg, "id")
+	if _, ok := msg["retry"]; !ok {
+		msg["retry"] = true
+	}
+	if _, ok := msg["created_at"]; !ok {
+		msg["created_at"] = sidekiqTime(enqueuedAt)
+	}
+	msg["queue"] = queue
+	msg["enqueued_at"] = sidekiqTime(enqueuedAt)
+	return json.Marshal(msg)
+}
+
+func (b *sidekiqBackend) Push(queue string, job []byte) error {
+	job, err := sidekiqJob(queue, job, time.Now())
+	if err != nil {
+		return err
+	}
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("SADD", b.key("queues"), queue)
+		conn.Send("LPUSH", b.key("queue:%s", queue), job)
+		return flush(conn)
+	})
+}
+
+// PushAt adds a job to the schedule set, from which it is
+// moved to queue at.
+func (b *sidekiqBackend) PushAt(queue string, job []byte, at time.Time) error {
+	job, err := sidekiqJob(queue, job, time.Now())
+	if err != nil {
+		return err
+	}
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("ZADD", b.key("schedule"), sidekiqTime(at), job)
+		return err
+	})
+}
+
+// Requeue puts the job back to the right of its list, from
+// which Sidekiq pops.
+func (b *sidekiqBackend) Requeue(queue string, job []byte) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("RPUSH", b.key("queue:%s", queue), job)
+		return err
+	})
+}
+
+func (b *sidekiqBackend) Pop(queues []string) (queue string, job []byte, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		for _, queue = range queues {
+			reply, err := redis.Bytes(conn.Do("RPOP", b.key("queue:%s", queue)))
+			if err == redis.ErrNil {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			job = reply
+			return nil
+		}
+		queue = ""
+		return nil
+	})
+	return
+}
+
+// Peek returns the jobs from the right of the list, next to
+// be popped first.
+func (b *sidekiqBackend) Peek(queue string, offset, limit int) (jobs [][]byte, err error) {
+	start := 0
+	if limit > 0 {
+		start = -(offset + limit)
+	}
+	err = withConn(b.pool, func(conn *redisConn) error {
+		jobs, err = redis.ByteSlices(conn.Do("LRANGE", b.key("queue:%s", queue), start, -(offset + 1)))
+		return err
+	})
+	for i, j := 0, len(jobs)-1; i < j; i, j = i+1, j-1 {
+		jobs[i], jobs[j] = jobs[j], jobs[i]
+	}
+	return
+}
+
+// Scheduled returns the jobs of the schedule set which
+// are not due yet.
+func (b *sidekiqBackend) Scheduled(offset, limit int) (jobs []*ScheduledJob, err error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	err = withConn(b.pool, func(conn *redisConn) error {
+		reply, err := redis.Values(conn.Do("ZRANGEBYSCORE", b.key("schedule"), "("+fmt.Sprint(sidekiqTime(time.Now())), "+inf", "WITHSCORES", "LIMIT", offset, limit))
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(reply); i += 2 {
+			entry, _ := redis.Bytes(reply[i], nil)
+			score, _ := redis.Float64(reply[i+1], nil)
+			msg, err := decodeSidekiqJob(entry)
+			if err != nil {
+				return err
+			}
+			queue, _ := msg["queue"].(string)
+			job, err := newScheduledJob(queue, parseSidekiqTime(score), entry)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	return
+}
+
+// RegisterWorker starts the heartbeat of the process with
+// its first worker.
+func (b *sidekiqBackend) RegisterWorker(worker string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.workers[worker] = true
+	if b.stop == nil {
+		b.stop = make(chan struct{})
+		b.stopped = make(chan struct{})
+		go b.heartbeat(b.stop, b.stopped)
+	}
+	return nil
+}
+
+// UnregisterWorker stops the heartbeat of the process with
+// its last worker, and removes the process.
+func (b *sidekiqBackend) UnregisterWorker(worker string) error {
+	b.mutex.Lock()
+	delete(b.workers, worker)
+	last := len(b.workers) == 0 && b.stop != nil
+	if last {
+		close(b.stop)
+		<-b.stopped
+		b.stop = nil
+	}
+	b.mutex.Unlock()
+
+	return withConn(b.pool, func(conn *redisConn) error {
+		if last {
+			conn.Send("SREM", b.key("processes"), b.identity)
+			conn.Send("DEL", b.key("%s", b.identity), b.key("%s:work", b.identity))
+		} else {
+			conn.Send("HDEL", b.key("%s:work", b.identity), worker)
+		}
+		return flush(conn)
+	})
+}
+
+// Beats every sidekiqBeatInterval until stop is closed,
+// enqueueing the due jobs of the retry and schedule sets.
+func (b *sidekiqBackend) heartbeat(stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(sidekiqBeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.beat(); err != nil {
+			logger.Errorf("Error on the heartbeat of %s: %v", b.identity, err)
+		}
+		if err := b.enqueueDue(); err != nil {
+			logger.Errorf("Error on enqueueing the due jobs: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Writes the process hash read by the Sidekiq web UI.
+func (b *sidekiqBackend) beat() error {
+	info, err := json.Marshal(map[string]interface{}{
+		"hostname":    b.hostname,
+		"started_at":  sidekiqTime(b.started),
+		"pid":         os.Getpid(),
+		"tag":         "",
+		"concurrency": currentConcurrency(),
+		"queues":      []string(cfg.queues),
+		"labels":      []string{"goworker"},
+		"identity":    b.identity,
+		"version":     "goworker",
+	})
+	if err != nil {
+		return err
+	}
+
+	return withConn(b.pool, func(conn *redisConn) error {
+		busy, err := redis.Int(conn.Do("HLEN", b.key("%s:work", b.identity)))
+		if err != nil {
+			return err
+		}
+		conn.Send("SADD", b.key("processes"), b.identity)
+		conn.Send("HMSET", b.key("%s", b.identity),
+			"info", info, "busy", busy, "beat", sidekiqTime(time.Now()), "quiet", "false", "rtt_us", 0, "rss", 0)
+		conn.Send("EXPIRE", b.key("%s", b.identity), int(sidekiqProcessTTL/time.Second))
+		conn.Send("EXPIRE", b.key("%s:work", b.identity), int(sidekiqProcessTTL/time.Second))
+		return flush(conn)
+	})
+}
+
+// Moves the due jobs of the retry and schedule sets to
+// their queues.
+func (b *sidekiqBackend) enqueueDue() error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		for _, set := range []string{"retry", "schedule"} {
+			for {
+				moved, err := redis.Int(sidekiqEnqueueScript.Do(conn.Conn, b.key("%s", set), b.key("queues"), sidekiqTime(time.Now()), b.key("queue:")))
+				if err != nil {
+					return err
+				}
+				if moved < 100 {
+					break
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// StartWork writes the job of worker in the work hash of
+// the process, as Sidekiq writes the jobs of its threads.
+func (b *sidekiqBackend) StartWork(worker string, work []byte) error {
+	if work == nil {
+		return nil
+	}
+	var w struct {
+		Queue   string          `json:"queue"`
+		RunAt   time.Time       `json:"run_at"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(work, &w); err != nil {
+		return err
+	}
+	entry, err := json.Marshal(map[string]interface{}{
+		"queue":   w.Queue,
+		"payload": string(w.Payload),
+		"run_at":  w.RunAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("HSET", b.key("%s:work", b.identity), worker, entry)
+		return err
+	})
+}
+
+func (b *sidekiqBackend) FinishWork(worker string) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("HDEL", b.key("%s:work", b.identity), worker)
+		return err
+	})
+}
+
+// Workers returns the identities of the Sidekiq processes,
+// goworker or not.
+func (b *sidekiqBackend) Workers() (workers []string, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		workers, err = redis.Strings(conn.Do("SMEMBERS", b.key("processes")))
+		return err
+	})
+	sort.Strings(workers)
+	return
+}
+
+// WorkerJob returns the job of a worker of this process.
+func (b *sidekiqBackend) WorkerJob(worker string) (work []byte, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		work, err = redis.Bytes(conn.Do("HGET", b.key("%s:work", b.identity), worker))
+		if err == redis.ErrNil {
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+// IncrStats increments the processed and failed stats and
+// their daily counts, ignoring the stats of the workers.
+func (b *sidekiqBackend) IncrStats(stats ...string) error {
+	date := time.Now().UTC().Format("2006-01-02")
+	return withConn(b.pool, func(conn *redisConn) error {
+		for _, stat := range stats {
+			if stat == "processed" || stat == "failed" {
+				conn.Send("INCR", b.key("stat:%s", stat))
+				conn.Send("INCR", b.key("stat:%s:%s", stat, date))
+			}
+		}
+		return flush(conn)
+	})
+}
+
+// Returns the delay of the retry after the failure count of
+// a job, as in Sidekiq.
+func sidekiqRetryDelay(count int) time.Duration {
+	return time.Duration(count*count*count*count+15+mathrand.Intn(10)*(count+1)) * time.Second
+}
+
+// Compresses a backtrace as Sidekiq does, into the base64
+// of the deflated JSON.
+func compressBacktrace(backtrace []string) (string, error) {
+	serialized, err := json.Marshal(backtrace)
+	if err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	writer.Write(serialized)
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+// SaveFailure schedules the retry of the failed job in the
+// retry set, or moves it to the dead set once it ran out of
+// retries, like the job retries of Sidekiq. Jobs whose
+// retry is false are dropped.
+func (b *sidekiqBackend) SaveFailure(failure *Failure) error {
+	job := failure.job
+	if job == nil {
+		var err error
+		if job, err = json.Marshal(&payload{Class: failure.Class, Args: failure.Args}); err != nil {
+			return err
+		}
+	}
+	msg, err := decodeSidekiqJob(job)
+	if err != nil {
+		return err
+	}
+
+	maxRetries := sidekiqMaxRetries
+	switch retry := msg["retry"].(type) {
+	case bool:
+		if !retry {
+			return nil
+		}
+	case json.Number:
+		if n, err := retry.Int64(); err == nil {
+			maxRetries = int(n)
+		}
+	}
+
+	queue := failure.Queue
+	if retryQueue, ok := msg["retry_queue"].(string); ok {
+		queue = retryQueue
+	}
+	msg["queue"] = queue
+	msg["error_message"] = failure.Error
+	msg["error_class"] = failure.Exception
+
+	count := 0
+	if retryCount, ok := msg["retry_count"].(json.Number); ok {
+		n, _ := retryCount.Int64()
+		count = int(n) + 1
+		msg["retried_at"] = sidekiqTime(failure.FailedAt)
+	} else {
+		msg["failed_at"] = sidekiqTime(failure.FailedAt)
+	}
+	msg["retry_count"] = count
+
+	if _, ok := msg["backtrace"]; ok && len(failure.Backtrace) > 0 {
+		if msg["error_backtrace"], err = compressBacktrace(failure.Backtrace); err != nil {
+			return err
+		}
+	}
+
+	entry, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return withConn(b.pool, func(conn *redisConn) error {
+		if count < maxRetries {
+			_, err := conn.Do("ZADD", b.key("retry"), sidekiqTime(failure.FailedAt.Add(sidekiqRetryDelay(count))), entry)
+			return err
+		}
+		now := time.Now()
+		conn.Send("ZADD", b.key("dead"), sidekiqTime(now), entry)
+		conn.Send("ZREMRANGEBYSCORE", b.key("dead"), "-inf", sidekiqTime(now.Add(-sidekiqDeadTimeout)))
+		conn.Send("ZREMRANGEBYRANK", b.key("dead"), 0, -(sidekiqDeadMaxJobs + 1))
+		return flush(conn)
+	})
+}
+
+// Returns the failure of a job of the retry or dead set.
+func sidekiqFailure(entry []byte) (*Failure, error) {
+	msg, err := decodeSidekiqJob(entry)
+	if err != nil {
+		return nil, err
+	}
+	failure := &Failure{
+		FailedAt:    parseSidekiqTime(msg["failed_at"]),
+		RetriedAt:   parseSidekiqTime(msg["retried_at"]),
+		Occurrences: 1,
+		raw:         entry,
+	}
+	failure.Queue, _ = msg["queue"].(string)
+	failure.Class, _ = msg["class"].(string)
+	failure.Args, _ = msg["args"].([]interface{})
+	failure.Exception, _ = msg["error_class"].(string)
+	failure.Error, _ = msg["error_message"].(string)
+	return failure, nil
+}
+
+// Calls fn with every job of the retry set, then of the
+// dead set, until fn returns false. The key of the
+// failures is their set.
+func (b *sidekiqBackend) eachFailure(fn func(*Failure) bool) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		for _, set := range []string{"retry", "dead"} {
+			key := b.key("%s", set)
+			for start := 0; ; start += failuresBatchSize {
+				entries, err := redis.ByteSlices(conn.Do("ZRANGE", key, start, start+failuresBatchSize-1))
+				if err != nil {
+					return err
+				}
+				for i, entry := range entries {
+					failure, err := sidekiqFailure(entry)
+					if err != nil {
+						logger.Warnf("Skipping unreadable job %d of %s: %v", start+i, key, err)
+						continue
+					}
+					failure.Index = start + i
+					failure.key = key
+					if !fn(failure) {
+						return nil
+					}
+				}
+				if len(entries) < failuresBatchSize {
+					break
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *sidekiqBackend) Failures(offset, limit int, filter *FailureFilter) ([]*Failure, error) {
+	return collectFailures(b.eachFailure, offset, limit, filter)
+}
+
+func (b *sidekiqBackend) FailureCount() (count int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("ZCARD", b.key("retry"))
+		conn.Send("ZCARD", b.key("dead"))
+		counts, err := redis.Ints(conn.Do(""))
+		if err == nil {
+			count = counts[0] + counts[1]
+		}
+		return err
+	})
+	return
+}
+
+// RetryFailure moves the job of a failure to its queue now,
+// like the retry buttons of the Sidekiq web UI.
+func (b *sidekiqBackend) RetryFailure(failure *Failure) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		moved, err := redis.Int(sidekiqRetryScript.Do(conn.Conn, failure.key, b.key("queue:%s", failure.Queue), b.key("queues"), failure.raw, failure.Queue))
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			return errorFailureChanged
+		}
+		return nil
+	})
+}
+
+func (b *sidekiqBackend) RemoveFailure(failure *Failure) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		removed, err := redis.Int(conn.Do("ZREM", failure.key, failure.raw))
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return errorFailureChanged
+		}
+		return nil
+	})
+}
+
+func (b *sidekiqBackend) ClearFailures() error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", b.key("retry"), b.key("dead"))
+		return err
+	})
+}
+
+// PruneFailures removes the jobs which died before cutoff.
+func (b *sidekiqBackend) PruneFailures(cutoff time.Time) (pruned int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		pruned, err = redis.Int(conn.Do("ZREMRANGEBYSCORE", b.key("dead"), "-inf", "("+fmt.Sprint(sidekiqTime(cutoff))))
+		return err
+	})
+	return
+}
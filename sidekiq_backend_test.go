@@ -0,0 +1,200 @@
+package goworker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var sidekiqRetryDelayTests = []struct {
+	count    int
+	min, max time.Duration
+}{
+	{0, 15 * time.Second, 24 * time.Second},
+	{1, 16 * time.Second, 34 * time.Second},
+	{5, 640 * time.Second, 694 * time.Second},
+}
+
+func TestSidekiqRetryDelay(t *testing.T) {
+	for _, tt := range sidekiqRetryDelayTests {
+		if delay := sidekiqRetryDelay(tt.count); delay < tt.min || delay > tt.max {
+			t.Errorf("SidekiqRetryDelay(%d): expected between %v and %v, actual %v", tt.count, tt.min, tt.max, delay)
+		}
+	}
+}
+
+func testSidekiqBackend(t *testing.T) *sidekiqBackend {
+	b := NewSidekiqBackend(newRedisPool(cfg.uri, 1, 1, time.Minute)).(*sidekiqBackend)
+	if err := withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", b.key("queue:test_sidekiq"), b.key("queues"), b.key("retry"), b.key("dead"), b.key("schedule"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSidekiqBackendQueues(t *testing.T) {
+	b := testSidekiqBackend(t)
+	defer b.pool.Close()
+
+	for _, class := range []string{"A", "B"} {
+		if err := b.Push("test_sidekiq", []byte(`{"class":"`+class+`","args":[],"id":"`+class+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if jobs, _ := b.Peek("test_sidekiq", 1, 1); len(jobs) != 1 {
+		t.Fatalf("expecting to peek 1 job, but got %q", jobs)
+	} else {
+		var msg map[string]interface{}
+		json.Unmarshal(jobs[0], &msg)
+		if msg["class"] != "B" || msg["jid"] != "B" || msg["queue"] != "test_sidekiq" || msg["retry"] != true || msg["enqueued_at"] == nil {
+			t.Errorf("expecting job B in the format of Sidekiq, but got %s", jobs[0])
+		}
+	}
+
+	queue, job, err := b.Pop([]string{"test_sidekiq_empty", "test_sidekiq"})
+	if err != nil || queue != "test_sidekiq" {
+		t.Fatalf("expecting a job of test_sidekiq, but got %s %v", queue, err)
+	}
+	var p payload
+	if err := json.Unmarshal(job, &p); err != nil || p.Class != "A" || p.id() != "A" {
+		t.Errorf("expecting job A first, but got %s %v", job, err)
+	}
+
+	// Scheduled jobs are moved to their queue once due.
+	b.PushAt("test_sidekiq", []byte(`{"class":"C","args":[]}`), time.Now().Add(-time.Second))
+	b.PushAt("test_sidekiq", []byte(`{"class":"D","args":[]}`), time.Now().Add(time.Hour))
+	if err := b.enqueueDue(); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := b.QueueSize("test_sidekiq"); size != 2 {
+		t.Errorf("expecting the due job to be enqueued, but got %d jobs", size)
+	}
+	if jobs, err := b.Scheduled(0, 0); err != nil || len(jobs) != 1 || jobs[0].Class != "D" || jobs[0].Queue != "test_sidekiq" {
+		t.Errorf("expecting job D to be scheduled, but got %v %v", jobs, err)
+	}
+}
+
+func TestSidekiqBackendProcess(t *testing.T) {
+	b := testSidekiqBackend(t)
+	defer b.pool.Close()
+
+	if err := b.RegisterWorker("w1"); err != nil {
+		t.Fatal(err)
+	}
+	work := []byte(`{"queue":"test_sidekiq","run_at":"2016-01-02T15:04:05Z","payload":{"class":"A","args":[]}}`)
+	if err := b.StartWork("w1", work); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.beat(); err != nil {
+		t.Fatal(err)
+	}
+
+	if processes, _ := b.Workers(); len(processes) != 1 || processes[0] != b.identity {
+		t.Errorf("expecting process %s, but got %q", b.identity, processes)
+	}
+	err := withConn(b.pool, func(conn *redisConn) error {
+		busy, err := redis.Int(conn.Do("HGET", b.key("%s", b.identity), "busy"))
+		if err != nil || busy != 1 {
+			t.Errorf("expecting 1 busy worker, but got %d %v", busy, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := b.WorkerJob("w1"); string(job) != `{"payload":"{\"class\":\"A\",\"args\":[]}","queue":"test_sidekiq","run_at":1451747045}` {
+		t.Errorf("expecting the job in the format of Sidekiq, but got %s", job)
+	}
+
+	if err := b.UnregisterWorker("w1"); err != nil {
+		t.Fatal(err)
+	}
+	if processes, _ := b.Workers(); len(processes) != 0 {
+		t.Errorf("expecting the process to be removed, but got %q", processes)
+	}
+}
+
+func TestSidekiqBackendFailures(t *testing.T) {
+	b := testSidekiqBackend(t)
+	defer b.pool.Close()
+
+	failure := func(job string) *Failure {
+		return &Failure{
+			FailedAt:  time.Now(),
+			Queue:     "test_sidekiq",
+			Class:     "TestSidekiq",
+			Exception: "errors.errorString",
+			Error:     "failed",
+			Backtrace: []string{"main.go:1"},
+			job:       []byte(job),
+		}
+	}
+	for _, job := range []string{
+		`{"class":"TestSidekiq","args":[1],"jid":"a","retry":true}`,
+		`{"class":"TestSidekiq","args":[2],"jid":"b","retry":2,"retry_count":1,"backtrace":true}`,
+		`{"class":"TestSidekiq","args":[3],"jid":"c","retry":false}`,
+	} {
+		if err := b.SaveFailure(failure(job)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count, _ := b.FailureCount(); count != 2 {
+		t.Fatalf("expecting 2 failures, but got %d", count)
+	}
+	failures, err := b.Failures(0, 0, nil)
+	if err != nil || len(failures) != 2 {
+		t.Fatalf("expecting 2 failures, but got %v %v", failures, err)
+	}
+	if failures[0].key != b.key("retry") || failures[1].key != b.key("dead") {
+		t.Errorf("expecting a retry then a dead job, but got %s and %s", failures[0].key, failures[1].key)
+	}
+
+	var msg map[string]interface{}
+	json.Unmarshal(failures[1].raw, &msg)
+	if msg["retry_count"] != float64(2) || msg["error_message"] != "failed" || msg["error_backtrace"] == nil || msg["retried_at"] == nil {
+		t.Errorf("expecting the dead job to record its failure, but got %s", failures[1].raw)
+	}
+
+	if err := b.RetryFailure(failures[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RetryFailure(failures[1]); err != errorFailureChanged {
+		t.Errorf("expecting %v for a retried failure, but got %v", errorFailureChanged, err)
+	}
+	if size, _ := b.QueueSize("test_sidekiq"); size != 1 {
+		t.Errorf("expecting the dead job to be enqueued, but got %d jobs", size)
+	}
+
+	if err := b.IncrStats("processed", "processed:w1"); err != nil {
+		t.Fatal(err)
+	}
+	if processed, _ := b.Stat("processed:w1"); processed != 0 {
+		t.Errorf("expecting no stats per worker, but got %d", processed)
+	}
+}
+
+func TestSidekiqBackendRequeuesOnStop(t *testing.T) {
+	b := testSidekiqBackend(t)
+	defer b.pool.Close()
+
+	// A job of Sidekiq keeps the fields which payload does
+	// not declare once requeued.
+	raw := `{"retry":false,"queue":"test_sidekiq","class":"TestSidekiqStop","args":[1],"jid":"abc","created_at":1.5,"enqueued_at":1.5,"tags":["a"]}`
+	if err := withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("LPUSH", b.key("queue:test_sidekiq"), raw)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stopPollerWithJob(t, b, "test_sidekiq")
+
+	if jobs, _ := b.Peek("test_sidekiq", 0, 0); len(jobs) != 1 || string(jobs[0]) != raw {
+		t.Errorf("expecting the job requeued as popped, but got %q", jobs)
+	}
+}
@@ -6,6 +6,12 @@
 // $CONCURRENCY jobs currently running, which
 // will continue to run until they are finished.
 //
+// To change the concurrency, send a HUP signal.
+// goworker then sets the concurrency returned by
+// the function of SetReloadFunc, or else that of
+// SetRemoteConcurrency. Workers are started, or
+// stopped once their current job is finished.
+//
 // Failure Modes
 //
 // Like Resque, goworker makes no guarantees
@@ -41,22 +47,35 @@ package goworker
 import (
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 )
 
-func signals() <-chan bool {
-	quit := make(chan bool)
+// Returns a channel closed on a QUIT, TERM or INT signal,
+// and one receiving HUP signals.
+func signals() (<-chan struct{}, <-chan struct{}) {
+	quit := make(chan struct{})
+	reload := make(chan struct{}, 1)
 
 	go func() {
-		signals := make(chan os.Signal)
+		signals := make(chan os.Signal, 1)
 		defer close(signals)
 
-		signal.Notify(signals, syscall.SIGQUIT, syscall.SIGTERM, os.Interrupt)
+		signal.Notify(signals, syscall.SIGQUIT, syscall.SIGTERM, os.Interrupt, syscall.SIGHUP)
 		defer signalStop(signals)
 
-		<-signals
-		quit <- true
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				break
+			}
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		}
+		atomic.StoreInt32(&shuttingDown, 1)
+		close(quit)
 	}()
 
-	return quit
+	return quit, reload
 }
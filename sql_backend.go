@@ -0,0 +1,88 @@
+package goworker
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// Calls fn with every failure of the failures table,
+// oldest first, until fn returns false. query selects the
+// id and the failure of a batch given its limit and offset.
+// The key of the failures is their id.
+func eachSQLFailure(db *sql.DB, query string, fn func(*Failure) bool) error {
+	for start := 0; ; start += failuresBatchSize {
+		rows, err := db.Query(query, failuresBatchSize, start)
+		if err != nil {
+			return err
+		}
+		var failures []*Failure
+		for i := 0; rows.Next(); i++ {
+			var id int64
+			var entry []byte
+			if err := rows.Scan(&id, &entry); err != nil {
+				rows.Close()
+				return err
+			}
+			failure := &Failure{}
+			if err := failure.UnmarshalJSON(entry); err != nil {
+				logger.Warnf("Skipping unreadable failure %d: %v", id, err)
+				failure = nil
+			} else {
+				failure.Index = start + i
+				failure.key = strconv.FormatInt(id, 10)
+				failure.raw = entry
+			}
+			failures = append(failures, failure)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, failure := range failures {
+			if failure != nil && !fn(failure) {
+				return nil
+			}
+		}
+		if len(failures) < failuresBatchSize {
+			return nil
+		}
+	}
+}
+
+// Runs the statement of a failure with its id and stored
+// failure as first arguments, and returns
+// errorFailureChanged if the failure was changed or
+// removed.
+func updateSQLFailure(tx *sql.Tx, failure *Failure, query string, args ...interface{}) error {
+	result, err := tx.Exec(query, append([]interface{}{failure.key, failure.raw}, args...)...)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		if err == nil {
+			err = errorFailureChanged
+		}
+		return err
+	}
+	return nil
+}
+
+// Returns the strings of the first column of query.
+func queryStrings(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,564 @@
+package goworker
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// Primary result codes of SQLite telling that the database
+// is locked by another connection.
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// Schema of the SQLite backend, created when the backend is
+// first used. Times are in nanoseconds since the epoch.
+var sqliteSchema = []string{
+	`PRAGMA journal_mode = WAL`,
+	`CREATE TABLE IF NOT EXISTS goworker_queues (
+		name TEXT PRIMARY KEY
+	)`,
+	`CREATE TABLE IF NOT EXISTS goworker_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		queue TEXT NOT NULL,
+		job BLOB NOT NULL,
+		requeued INTEGER NOT NULL DEFAULT 0,
+		run_at INTEGER NOT NULL,
+		claimed_by TEXT,
+		claimed_at INTEGER
+	)`,
+	`CREATE INDEX IF NOT EXISTS goworker_jobs_queue ON goworker_jobs (queue, run_at)`,
+	`CREATE TABLE IF NOT EXISTS goworker_paused_queues (
+		name TEXT PRIMARY KEY
+	)`,
+	`CREATE TABLE IF NOT EXISTS goworker_workers (
+		name TEXT PRIMARY KEY,
+		work BLOB,
+		started_at INTEGER
+	)`,
+	`CREATE TABLE IF NOT EXISTS goworker_stats (
+		name TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS goworker_failures (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		failed_at INTEGER NOT NULL,
+		failure BLOB NOT NULL
+	)`,
+}
+
+// sqliteBackend stores the queues, workers, stats and
+// failures in a SQLite file, for single node deployments
+// without a server. The file is in WAL mode, so that
+// enqueuers read and write while workers fetch jobs.
+//
+// Fetching a job claims it rather than deleting it, and the
+// job is deleted once it succeeds or its failure is saved.
+// The claim is renewed while the job runs, so that the jobs
+// claimed by a process which crashed are fetched again once
+// claimed for longer than the sqliteClaimTimeout option.
+type sqliteBackend struct {
+	db       *sql.DB
+	consumer string
+
+	// pending holds the ids of the claimed jobs until they
+	// are acknowledged.
+	pending pendingJobs
+
+	mutex   sync.Mutex
+	created bool
+}
+
+// NewSQLiteBackend returns the SQLite backend of the file
+// at path, which is created if it does not exist.
+func NewSQLiteBackend(path string) Backend {
+	// Writers wait for each other rather than failing with
+	// SQLITE_BUSY, and transactions take the write lock
+	// when they begin, not to deadlock on its upgrade.
+	db, _ := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=synchronous(NORMAL)&_txlock=immediate")
+	hostname, _ := os.Hostname()
+	return &sqliteBackend{
+		db:       db,
+		consumer: fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+	}
+}
+
+// Returns the path of the file of a sqlite URI: the path of
+// sqlite:///path/to/jobs.db is absolute, the one of
+// sqlite://jobs.db relative.
+func sqlitePath(u *url.URL) string {
+	return u.Host + u.Path
+}
+
+// Creates the tables unless done already.
+func (b *sqliteBackend) createTables() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.created {
+		return nil
+	}
+	for _, statement := range sqliteSchema {
+		if _, err := b.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	b.created = true
+	return nil
+}
+
+// Runs fn in a transaction, once the tables are created.
+func (b *sqliteBackend) withTx(fn func(tx *sql.Tx) error) error {
+	if err := b.createTables(); err != nil {
+		return err
+	}
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Runs fn with the database, once the tables are created.
+func (b *sqliteBackend) withDB(fn func(db *sql.DB) error) error {
+	if err := b.createTables(); err != nil {
+		return err
+	}
+	return fn(b.db)
+}
+
+func (b *sqliteBackend) push(queue string, job []byte, runAt time.Time) error {
+	return b.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT INTO goworker_queues (name) VALUES (?) ON CONFLICT DO NOTHING`, queue); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO goworker_jobs (queue, job, run_at) VALUES (?, ?, ?)`, queue, job, runAt.UnixNano())
+		return err
+	})
+}
+
+func (b *sqliteBackend) Push(queue string, job []byte) error {
+	return b.push(queue, job, time.Now())
+}
+
+// PushAt pushes a job which is not fetched before at.
+func (b *sqliteBackend) PushAt(queue string, job []byte, at time.Time) error {
+	return b.push(queue, job, at)
+}
+
+// Requeue releases the claim of a job fetched from queue,
+// and puts it before the other jobs of the queue.
+func (b *sqliteBackend) Requeue(queue string, job []byte) error {
+	id := b.pending.take(queue, job)
+	if id == "" {
+		return b.Push(queue, job)
+	}
+	return b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`UPDATE goworker_jobs SET requeued = 1, claimed_by = NULL, claimed_at = NULL WHERE id = ?`, id)
+		return err
+	})
+}
+
+// Pop claims the first job of queues which is due and not
+// claimed, or whose claim timed out.
+func (b *sqliteBackend) Pop(queues []string) (queue string, job []byte, err error) {
+	if len(queues) == 0 {
+		return
+	}
+
+	// The queues are ordered by their position in queues.
+	var in, order []string
+	var args []interface{}
+	for i, queue := range queues {
+		in = append(in, "?")
+		order = append(order, fmt.Sprintf("WHEN ? THEN %d", i))
+		args = append(args, queue)
+	}
+	now := time.Now()
+	args = append(append([]interface{}{b.consumer, now.UnixNano()}, args...), now.UnixNano(), now.Add(-time.Duration(cfg.sqliteClaimTimeout)).UnixNano())
+	args = append(args, args[2:2+len(queues)]...)
+
+	err = b.withDB(func(db *sql.DB) error {
+		var id int64
+		err := db.QueryRow(`
+			UPDATE goworker_jobs SET claimed_by = ?, claimed_at = ? WHERE id = (
+				SELECT id FROM goworker_jobs
+				WHERE queue IN (`+strings.Join(in, ", ")+`) AND run_at <= ?
+				AND (claimed_at IS NULL OR claimed_at < ?)
+				ORDER BY CASE queue `+strings.Join(order, " ")+` END, requeued DESC, id
+				LIMIT 1
+			)
+			RETURNING id, queue, job`, args...).Scan(&id, &queue, &job)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err == nil {
+			b.pending.add(queue, job, strconv.FormatInt(id, 10))
+		}
+		return err
+	})
+	return
+}
+
+// Ack deletes a job fetched from queue once it succeeded,
+// or once its failure was saved.
+func (b *sqliteBackend) Ack(queue string, job []byte) error {
+	id := b.pending.take(queue, job)
+	if id == "" {
+		return nil
+	}
+	err := b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`DELETE FROM goworker_jobs WHERE id = ?`, id)
+		return err
+	})
+	if err != nil {
+		b.pending.add(queue, job, id)
+	}
+	return err
+}
+
+// ClaimLease returns how long a fetched job stays claimed
+// unless its claim is renewed.
+func (b *sqliteBackend) ClaimLease() time.Duration {
+	return time.Duration(cfg.sqliteClaimTimeout)
+}
+
+// RenewClaim claims a job fetched from queue again, while
+// it runs.
+func (b *sqliteBackend) RenewClaim(queue string, job []byte) error {
+	ids := b.pending.get(queue, job)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	in := make([]string, len(ids))
+	args := []interface{}{time.Now().UnixNano(), b.consumer}
+	for i, id := range ids {
+		in[i] = "?"
+		args = append(args, id)
+	}
+	return b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`UPDATE goworker_jobs SET claimed_at = ? WHERE claimed_by = ? AND id IN (`+strings.Join(in, ", ")+`)`, args...)
+		return err
+	})
+}
+
+func (b *sqliteBackend) Queues() (queues []string, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		queues, err = queryStrings(db, `SELECT name FROM goworker_queues ORDER BY name`)
+		return err
+	})
+	return
+}
+
+// QueueSize returns the number of jobs which are due and
+// not claimed.
+func (b *sqliteBackend) QueueSize(queue string) (size int, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		return db.QueryRow(`
+			SELECT count(*) FROM goworker_jobs
+			WHERE queue = ? AND run_at <= ? AND claimed_at IS NULL`, queue, time.Now().UnixNano()).Scan(&size)
+	})
+	return
+}
+
+// Peek returns the jobs which are due and not claimed.
+func (b *sqliteBackend) Peek(queue string, offset, limit int) (jobs [][]byte, err error) {
+	if limit <= 0 {
+		// A negative limit returns every row.
+		limit = -1
+	}
+	err = b.withDB(func(db *sql.DB) error {
+		rows, err := db.Query(`
+			SELECT job FROM goworker_jobs
+			WHERE queue = ? AND run_at <= ? AND claimed_at IS NULL
+			ORDER BY requeued DESC, id
+			LIMIT ? OFFSET ?`, queue, time.Now().UnixNano(), limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var job []byte
+			if err := rows.Scan(&job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+		}
+		return rows.Err()
+	})
+	return
+}
+
+// Scheduled returns the jobs of PushAt which are not due
+// yet.
+func (b *sqliteBackend) Scheduled(offset, limit int) (jobs []*ScheduledJob, err error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	err = b.withDB(func(db *sql.DB) error {
+		rows, err := db.Query(`
+			SELECT queue, job, run_at FROM goworker_jobs
+			WHERE run_at > ? AND claimed_at IS NULL
+			ORDER BY run_at, id
+			LIMIT ? OFFSET ?`, time.Now().UnixNano(), limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var queue string
+			var job []byte
+			var runAt int64
+			if err := rows.Scan(&queue, &job, &runAt); err != nil {
+				return err
+			}
+			scheduled, err := newScheduledJob(queue, time.Unix(0, runAt), job)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, scheduled)
+		}
+		return rows.Err()
+	})
+	return
+}
+
+// ClearQueue removes the jobs of queue which are due and
+// not fetched, as counted by QueueSize.
+func (b *sqliteBackend) ClearQueue(queue string) (cleared int, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		result, err := db.Exec(`
+			DELETE FROM goworker_jobs
+			WHERE queue = ? AND run_at <= ? AND claimed_at IS NULL`, queue, time.Now().UnixNano())
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		cleared = int(rows)
+		return err
+	})
+	return
+}
+
+func (b *sqliteBackend) PauseQueue(queue string) error {
+	return b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`INSERT INTO goworker_paused_queues (name) VALUES (?) ON CONFLICT DO NOTHING`, queue)
+		return err
+	})
+}
+
+func (b *sqliteBackend) ResumeQueue(queue string) error {
+	return b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`DELETE FROM goworker_paused_queues WHERE name = ?`, queue)
+		return err
+	})
+}
+
+func (b *sqliteBackend) PausedQueues() (queues []string, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		queues, err = queryStrings(db, `SELECT name FROM goworker_paused_queues ORDER BY name`)
+		return err
+	})
+	return
+}
+
+func (b *sqliteBackend) RegisterWorker(worker string) error {
+	return b.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT INTO goworker_workers (name) VALUES (?) ON CONFLICT DO NOTHING`, worker); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO goworker_stats (name, value) VALUES (?, 0), (?, 0)
+			ON CONFLICT (name) DO UPDATE SET value = 0`, "processed:"+worker, "failed:"+worker)
+		return err
+	})
+}
+
+func (b *sqliteBackend) UnregisterWorker(worker string) error {
+	return b.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM goworker_workers WHERE name = ?`, worker); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM goworker_stats WHERE name IN (?, ?)`, "processed:"+worker, "failed:"+worker)
+		return err
+	})
+}
+
+func (b *sqliteBackend) StartWork(worker string, work []byte) error {
+	return b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`UPDATE goworker_workers SET work = coalesce(?, work), started_at = ? WHERE name = ?`, work, time.Now().UnixNano(), worker)
+		return err
+	})
+}
+
+func (b *sqliteBackend) FinishWork(worker string) error {
+	return b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`UPDATE goworker_workers SET work = NULL, started_at = NULL WHERE name = ?`, worker)
+		return err
+	})
+}
+
+func (b *sqliteBackend) Workers() (workers []string, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		workers, err = queryStrings(db, `SELECT name FROM goworker_workers ORDER BY name`)
+		return err
+	})
+	return
+}
+
+func (b *sqliteBackend) WorkerJob(worker string) (work []byte, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		err := db.QueryRow(`SELECT work FROM goworker_workers WHERE name = ?`, worker).Scan(&work)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+func (b *sqliteBackend) IncrStats(stats ...string) error {
+	return b.withTx(func(tx *sql.Tx) error {
+		for _, stat := range stats {
+			_, err := tx.Exec(`
+				INSERT INTO goworker_stats (name, value) VALUES (?, 1)
+				ON CONFLICT (name) DO UPDATE SET value = value + 1`, stat)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *sqliteBackend) Stat(stat string) (value int, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		err := db.QueryRow(`SELECT value FROM goworker_stats WHERE name = ?`, stat).Scan(&value)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+func (b *sqliteBackend) SaveFailure(failure *Failure) error {
+	buffer, err := failure.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return b.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT INTO goworker_failures (failed_at, failure) VALUES (?, ?)`, failure.FailedAt.UnixNano(), buffer); err != nil {
+			return err
+		}
+		if cfg.failureMaxCount <= 0 {
+			return nil
+		}
+		_, err := tx.Exec(`
+			DELETE FROM goworker_failures WHERE id <= (
+				SELECT id FROM goworker_failures ORDER BY id DESC LIMIT 1 OFFSET ?
+			)`, cfg.failureMaxCount)
+		return err
+	})
+}
+
+func (b *sqliteBackend) eachFailure(fn func(*Failure) bool) error {
+	return b.withDB(func(db *sql.DB) error {
+		return eachSQLFailure(db, `SELECT id, failure FROM goworker_failures ORDER BY id LIMIT ? OFFSET ?`, fn)
+	})
+}
+
+func (b *sqliteBackend) Failures(offset, limit int, filter *FailureFilter) ([]*Failure, error) {
+	return collectFailures(b.eachFailure, offset, limit, filter)
+}
+
+func (b *sqliteBackend) FailureCount() (count int, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		return db.QueryRow(`SELECT count(*) FROM goworker_failures`).Scan(&count)
+	})
+	return
+}
+
+func (b *sqliteBackend) RetryFailure(failure *Failure) error {
+	now := time.Now()
+	updated, job, err := retriedFailure(failure, now)
+	if err != nil {
+		return err
+	}
+
+	err = b.withTx(func(tx *sql.Tx) error {
+		err := updateSQLFailure(tx, failure, `UPDATE goworker_failures SET failure = ?3 WHERE id = ?1 AND failure = ?2`, updated)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO goworker_jobs (queue, job, run_at) VALUES (?, ?, ?)`, failure.Queue, job, now.UnixNano())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	failure.RetriedAt, _ = parseFailureTime(now.Format(resqueRetriedAtLayout))
+	failure.raw = updated
+	return nil
+}
+
+func (b *sqliteBackend) RemoveFailure(failure *Failure) error {
+	return b.withTx(func(tx *sql.Tx) error {
+		return updateSQLFailure(tx, failure, `DELETE FROM goworker_failures WHERE id = ? AND failure = ?`)
+	})
+}
+
+func (b *sqliteBackend) ClearFailures() error {
+	return b.withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`DELETE FROM goworker_failures`)
+		return err
+	})
+}
+
+func (b *sqliteBackend) PruneFailures(cutoff time.Time) (pruned int, err error) {
+	err = b.withDB(func(db *sql.DB) error {
+		result, err := db.Exec(`DELETE FROM goworker_failures WHERE failed_at < ?`, cutoff.UnixNano())
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		pruned = int(count)
+		return err
+	})
+	return
+}
+
+func (b *sqliteBackend) Ping() error {
+	return b.db.Ping()
+}
+
+func (b *sqliteBackend) Close() error {
+	return b.db.Close()
+}
+
+// Returns whether err is an error of SQLite, and whether it
+// means that the database is locked, as when the busy
+// timeout expired, so that the call is retried.
+func sqliteConnError(err error) (connError bool, ok bool) {
+	e, ok := err.(*sqlite.Error)
+	if !ok {
+		return false, false
+	}
+	code := e.Code() & 0xff
+	return code == sqliteBusy || code == sqliteLocked, true
+}
@@ -0,0 +1,232 @@
+package goworker
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSQLiteBackend(t *testing.T) (*sqliteBackend, func()) {
+	dir, err := ioutil.TempDir("", "goworker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBackend("sqlite://" + filepath.Join(dir, "jobs.db")).(*sqliteBackend)
+	return b, func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSQLiteBackendQueues(t *testing.T) {
+	b, cleanup := testSQLiteBackend(t)
+	defer cleanup()
+
+	b.Push("low", []byte("1"))
+	b.Push("high", []byte("2"))
+	b.Push("high", []byte("3"))
+	b.PushAt("high", []byte("later"), time.Now().Add(time.Hour))
+
+	if size, _ := b.QueueSize("high"); size != 2 {
+		t.Errorf("expecting 2 jobs due in high, but got %d", size)
+	}
+
+	queue, job, err := b.Pop([]string{"high", "low"})
+	if err != nil || queue != "high" || string(job) != "2" {
+		t.Fatalf("expecting job 2 of high, but got %s %q %v", queue, job, err)
+	}
+	if err := b.Requeue(queue, job); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		queue string
+		job   string
+	}{
+		{"high", "2"},
+		{"high", "3"},
+		{"low", "1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		queue, job, err := b.Pop([]string{"high", "low"})
+		if err != nil || queue != tt.queue || string(job) != tt.job {
+			t.Errorf("Pop: expected %s %q, actual %s %q %v", tt.queue, tt.job, queue, job, err)
+		}
+		if job != nil {
+			b.Ack(queue, job)
+		}
+	}
+
+	// A job claimed by a process which crashed is fetched
+	// again once its claim timed out.
+	b.Push("crash", []byte("4"))
+	b.Pop([]string{"crash"})
+	restarted := &sqliteBackend{db: b.db, consumer: "restarted", created: true}
+
+	if _, job, _ := restarted.Pop([]string{"crash"}); job != nil {
+		t.Errorf("expecting the claimed job not to be fetched, but got %q", job)
+	}
+	cfg.sqliteClaimTimeout = 0
+	defer Configure(map[string]string{"sqliteClaimTimeout": "300"})
+	if _, job, _ := restarted.Pop([]string{"crash"}); string(job) != "4" {
+		t.Errorf("expecting job 4 to be fetched again, but got %q", job)
+	}
+}
+
+func TestSQLiteBackendRenewsClaims(t *testing.T) {
+	b, cleanup := testSQLiteBackend(t)
+	defer cleanup()
+	restarted := &sqliteBackend{db: b.db, consumer: "restarted", created: true}
+
+	cfg.sqliteClaimTimeout = intervalOption(30 * time.Millisecond)
+	defer Configure(map[string]string{"sqliteClaimTimeout": "300"})
+
+	// The claim of a running job is renewed until it
+	// finishes.
+	b.Push("running", []byte("5"))
+	_, running, _ := b.Pop([]string{"running"})
+	claim := renewClaim(b, &job{Queue: "running", raw: running})
+
+	time.Sleep(60 * time.Millisecond)
+	if _, job, _ := restarted.Pop([]string{"running"}); job != nil {
+		t.Errorf("expecting the running job not to be fetched, but got %q", job)
+	}
+	claim.release()
+	time.Sleep(60 * time.Millisecond)
+	if _, job, _ := restarted.Pop([]string{"running"}); string(job) != "5" {
+		t.Errorf("expecting job 5 to be fetched again, but got %q", job)
+	}
+}
+
+func TestSQLiteBackendFailures(t *testing.T) {
+	b, cleanup := testSQLiteBackend(t)
+	defer cleanup()
+
+	for i, e := range []string{"old", "new"} {
+		if err := b.SaveFailure(&Failure{
+			FailedAt:  time.Now().Add(time.Duration(i-2) * time.Hour),
+			Queue:     "test_sqlite",
+			Class:     "TestSQLite",
+			Args:      []interface{}{e},
+			Exception: "errors.errorString",
+			Error:     e,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failures, err := b.Failures(0, 0, nil)
+	if err != nil || len(failures) != 2 {
+		t.Fatalf("expecting 2 failures, but got %v %v", failures, err)
+	}
+	if err := b.RetryFailure(failures[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RemoveFailure(&Failure{key: failures[1].key, raw: []byte("{}")}); err != errorFailureChanged {
+		t.Errorf("expecting %v for a changed failure, but got %v", errorFailureChanged, err)
+	}
+	if _, job, _ := b.Pop([]string{"test_sqlite"}); string(job) != `{"args":["new"],"attempt":1,"class":"TestSQLite"}` {
+		t.Errorf("expecting the job of the failure to be requeued, but got %s", job)
+	}
+
+	if pruned, err := b.PruneFailures(time.Now().Add(-90 * time.Minute)); err != nil || pruned != 1 {
+		t.Errorf("expecting 1 failure pruned, but got %d %v", pruned, err)
+	}
+
+	if err := b.IncrStats("processed", "processed"); err != nil {
+		t.Fatal(err)
+	}
+	if processed, _ := b.Stat("processed"); processed != 2 {
+		t.Errorf("expecting 2 processed jobs, but got %d", processed)
+	}
+}
+
+func TestSQLiteBackendAdmin(t *testing.T) {
+	b, cleanup := testSQLiteBackend(t)
+	defer cleanup()
+
+	b.Push("test_sqlite", []byte(`{"class":"Now","args":[]}`))
+	b.PushAt("test_sqlite", []byte(`{"class":"Later","args":[]}`), time.Now().Add(time.Hour))
+
+	jobs, err := b.Scheduled(0, 0)
+	if err != nil || len(jobs) != 1 || jobs[0].Class != "Later" || jobs[0].Queue != "test_sqlite" {
+		t.Errorf("expecting the Later job to be scheduled, but got %v %v", jobs, err)
+	}
+
+	if err := b.PauseQueue("test_sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	if paused, _ := b.PausedQueues(); len(paused) != 1 || paused[0] != "test_sqlite" {
+		t.Errorf("expecting test_sqlite to be paused, but got %v", paused)
+	}
+	b.ResumeQueue("test_sqlite")
+	if paused, _ := b.PausedQueues(); len(paused) != 0 {
+		t.Errorf("expecting test_sqlite to be resumed, but got %v", paused)
+	}
+
+	if cleared, err := b.ClearQueue("test_sqlite"); err != nil || cleared != 1 {
+		t.Errorf("expecting the due job to be cleared, but got %d %v", cleared, err)
+	}
+	if jobs, _ := b.Scheduled(0, 0); len(jobs) != 1 {
+		t.Errorf("expecting the scheduled job to be kept, but got %v", jobs)
+	}
+}
+
+func TestEnqueueAt(t *testing.T) {
+	b, cleanup := testSQLiteBackend(t)
+	defer cleanup()
+
+	if err := EnqueueAtWithBackend(NewMemoryBackend(), time.Now(), "test_enqueue_at", "TestEnqueueAt", nil); err != errorNoDelayedJobs {
+		t.Errorf("expecting %v for the memory backend, but got %v", errorNoDelayedJobs, err)
+	}
+
+	if err := EnqueueAtWithBackend(b, time.Now().Add(50*time.Millisecond), "test_enqueue_at", "TestEnqueueAt", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, job, _ := b.Pop([]string{"test_enqueue_at"}); job != nil {
+		t.Errorf("expecting the job not to be due, but got %s", job)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, job, _ := b.Pop([]string{"test_enqueue_at"}); job == nil {
+		t.Error("expecting the job to be due")
+	}
+}
+
+func TestSQLiteBackendDeletesFailedJobs(t *testing.T) {
+	b, cleanup := testSQLiteBackend(t)
+	defer cleanup()
+	previous := cfg.failureBackend
+	SetFailureBackend(backendFailures{b})
+	defer SetFailureBackend(previous)
+
+	cfg.sqliteClaimTimeout = 0
+	defer Configure(map[string]string{"sqliteClaimTimeout": "300"})
+
+	b.Push("failing", []byte(`{"class":"TestSQLiteFailing","args":[]}`))
+	p, err := newPoller([]string{"failing"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := p.getJob(b)
+	if err != nil || job == nil {
+		t.Fatalf("expecting the failing job, but got %v %v", job, err)
+	}
+	w, err := newWorker("1", []string{"failing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.run(b, job, func(queue string, args ...interface{}) error {
+		return errors.New("failed")
+	})
+
+	if count, _ := b.FailureCount(); count != 1 {
+		t.Errorf("expecting 1 failure, but got %d", count)
+	}
+	if _, job, _ := b.Pop([]string{"failing"}); job != nil {
+		t.Errorf("expecting the failed job to be deleted, but got %q", job)
+	}
+}
@@ -0,0 +1,52 @@
+package goworker
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yudppp/goworker/_vendor/vitess/go/stats"
+)
+
+// Number and interval of the samples of the job rates of
+// the queues.
+const (
+	rateSamples  = 15
+	rateInterval = time.Minute
+)
+
+// Stats published with expvar, served on /debug/vars by
+// the httpAddr option.
+var (
+	jobTimings    = stats.NewTimings("GoworkerJobTimings")
+	jobErrors     = stats.NewCounters("GoworkerJobErrors")
+	queueJobs     = stats.NewCounters("GoworkerQueueJobs")
+	pollerTimings = stats.NewTimings("GoworkerPollerTimings")
+
+	publishOnce sync.Once
+)
+
+func init() {
+	httpMux.Handle("/debug/vars", expvar.Handler())
+}
+
+// Publishes the stats which can only be published once
+// Work starts: the rates, which sample the counters in a
+// goroutine, and the stats of the Redis pool.
+func publishStats() {
+	publishOnce.Do(func() {
+		stats.NewRates("GoworkerQueueRates", queueJobs, rateSamples, rateInterval)
+		expvar.Publish("GoworkerRedisPool", stats.StrFunc(func() string {
+			if p := pool; p != nil {
+				return p.StatsJSON()
+			}
+			return "{}"
+		}))
+		expvar.Publish("GoworkerWorkers", stats.StrFunc(func() string {
+			busy := atomic.LoadInt64(&workersBusy)
+			return fmt.Sprintf(`{"Busy": %d, "Idle": %d}`, busy, atomic.LoadInt64(&workersTotal)-busy)
+		}))
+	})
+}
@@ -0,0 +1,37 @@
+package goworker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugVars(t *testing.T) {
+	publishStats()
+
+	job := &job{Queue: "test_stats", Payload: payload{Class: "TestStats"}}
+	jobStarted(job)
+	jobFinished(job, time.Millisecond, nil)
+
+	recorder := httptest.NewRecorder()
+	httpMux.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("DebugVars: invalid JSON %s: %v", recorder.Body.String(), err)
+	}
+	for _, name := range []string{"GoworkerJobTimings", "GoworkerJobErrors", "GoworkerQueueJobs", "GoworkerQueueRates", "GoworkerPollerTimings", "GoworkerRedisPool", "GoworkerWorkers"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("DebugVars: expected %s to be published", name)
+		}
+	}
+
+	var timings struct {
+		Histograms map[string]json.RawMessage
+	}
+	json.Unmarshal(vars["GoworkerJobTimings"], &timings)
+	if _, ok := timings.Histograms["TestStats"]; !ok {
+		t.Errorf("DebugVars: expected timings of TestStats, got %s", vars["GoworkerJobTimings"])
+	}
+}
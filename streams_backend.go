@@ -0,0 +1,439 @@
+package goworker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+// Consumer group of the streams backend, shared by every
+// goworker process reading the streams.
+const streamsGroup = "goworker"
+
+// Minimum delay between two trims of a stream for the
+// streamsRetention option.
+const streamsTrimInterval = time.Minute
+
+// streamsBackend stores each queue as a Redis Stream
+// instead of a Resque list, and consumes it with a consumer
+// group, one consumer per process. Jobs stay pending until
+// they succeed or their failure is saved, and they are
+// acknowledged: the jobs of a consumer which died are
+// claimed again by any consumer once they are idle for the
+// streamsClaimIdle option. Acknowledged jobs are kept in
+// the streams, to be replayed with XRANGE, for the
+// streamsRetention option. Workers, stats and failures are
+// stored like in the Redis backend.
+type streamsBackend struct {
+	*redisBackend
+
+	consumer string
+
+	// pending holds the entry ids of the popped jobs until
+	// they are acknowledged.
+	pending pendingJobs
+
+	mutex sync.Mutex
+	// groups holds the streams whose group was created,
+	// and trimmedAt when each stream was last trimmed.
+	groups    map[string]bool
+	trimmedAt map[string]time.Time
+}
+
+// NewStreamsBackend returns the streams backend using pool,
+// which is not closed with the backend.
+func NewStreamsBackend(pool *pools.ResourcePool) Backend {
+	return newStreamsBackend(&redisBackend{pool: pool})
+}
+
+func newStreamsBackend(b *redisBackend) *streamsBackend {
+	hostname, _ := os.Hostname()
+	return &streamsBackend{
+		redisBackend: b,
+		consumer:     fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		groups:       make(map[string]bool),
+		trimmedAt:    make(map[string]time.Time),
+	}
+}
+
+func (b *streamsBackend) streamKey(queue string) string {
+	return b.key("stream:%s", queue)
+}
+
+// Creates the group of the stream of queue, with the
+// stream, unless it was created already.
+func (b *streamsBackend) createGroup(conn *redisConn, queue string) error {
+	b.mutex.Lock()
+	created := b.groups[queue]
+	b.mutex.Unlock()
+	if created {
+		return nil
+	}
+
+	_, err := conn.Do("XGROUP", "CREATE", b.streamKey(queue), streamsGroup, "0", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	b.mutex.Lock()
+	b.groups[queue] = true
+	b.mutex.Unlock()
+	return nil
+}
+
+func (b *streamsBackend) Push(queue string, job []byte) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("SADD", b.key("queues"), queue)
+		conn.Send("XADD", b.streamKey(queue), "*", "job", job)
+		return flush(conn)
+	})
+}
+
+// Requeue adds the job again at the tail of the stream and
+// acknowledges the popped entry, so that the job does not
+// wait to be claimed.
+func (b *streamsBackend) Requeue(queue string, job []byte) error {
+	id := b.pending.take(queue, job)
+	return withConn(b.pool, func(conn *redisConn) error {
+		conn.Send("MULTI")
+		conn.Send("XADD", b.streamKey(queue), "*", "job", job)
+		if id != "" {
+			conn.Send("XACK", b.streamKey(queue), streamsGroup, id)
+		}
+		_, err := conn.Do("EXEC")
+		return err
+	})
+}
+
+// Pop claims the first job of queues which was pending for
+// longer than the streamsClaimIdle option, or else reads
+// the first new one.
+func (b *streamsBackend) Pop(queues []string) (queue string, job []byte, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		for _, queue = range queues {
+			if err := b.createGroup(conn, queue); err != nil {
+				return err
+			}
+
+			minIdle := int64(time.Duration(cfg.streamsClaimIdle) / time.Millisecond)
+			reply, err := redis.Values(conn.Do("XAUTOCLAIM", b.streamKey(queue), streamsGroup, b.consumer, minIdle, "0-0", "COUNT", 1))
+			if err != nil {
+				return b.checkGroup(queue, err)
+			}
+			var entries []streamEntry
+			if len(reply) > 1 {
+				if entries, err = streamEntries(reply[1], nil); err != nil {
+					return err
+				}
+			}
+
+			if len(entries) == 0 {
+				streams, err := redis.Values(conn.Do("XREADGROUP", "GROUP", streamsGroup, b.consumer, "COUNT", 1, "STREAMS", b.streamKey(queue), ">"))
+				if err == redis.ErrNil {
+					continue
+				}
+				if err != nil {
+					return b.checkGroup(queue, err)
+				}
+				// The reply holds the key and the entries
+				// of each stream read.
+				stream, err := redis.Values(streams[0], nil)
+				if err != nil {
+					return err
+				}
+				if len(stream) > 1 {
+					if entries, err = streamEntries(stream[1], nil); err != nil {
+						return err
+					}
+				}
+			}
+
+			if len(entries) > 0 {
+				job = entries[0].job
+				b.pending.add(queue, job, entries[0].id)
+				return nil
+			}
+		}
+		queue = ""
+		return nil
+	})
+	return
+}
+
+// Forgets the group of queue if err tells that it does not
+// exist anymore, as when the stream was deleted, so that it
+// is created again by the next Pop.
+func (b *streamsBackend) checkGroup(queue string, err error) error {
+	if strings.HasPrefix(err.Error(), "NOGROUP") {
+		b.mutex.Lock()
+		delete(b.groups, queue)
+		b.mutex.Unlock()
+	}
+	return err
+}
+
+// Ack acknowledges a job popped from queue, which is then
+// not claimed again.
+func (b *streamsBackend) Ack(queue string, job []byte) error {
+	id := b.pending.take(queue, job)
+	if id == "" {
+		return nil
+	}
+	err := withConn(b.pool, func(conn *redisConn) error {
+		_, err := conn.Do("XACK", b.streamKey(queue), streamsGroup, id)
+		return err
+	})
+	if err != nil {
+		// Keep the id so that the acknowledgement can be
+		// retried.
+		b.pending.add(queue, job, id)
+		return err
+	}
+
+	if b.trimDue(queue) {
+		if err := withConn(b.pool, func(conn *redisConn) error {
+			return b.trim(conn, queue)
+		}); err != nil {
+			logger.Warnf("Error on trimming the stream of %s: %v", queue, err)
+		}
+	}
+	return nil
+}
+
+// Returns whether the stream of queue is to be trimmed for
+// the streamsRetention option, and records that it is.
+func (b *streamsBackend) trimDue(queue string) bool {
+	if cfg.streamsRetention <= 0 {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if time.Since(b.trimmedAt[queue]) < streamsTrimInterval {
+		return false
+	}
+	b.trimmedAt[queue] = time.Now()
+	return true
+}
+
+// Trims the jobs of the stream of queue older than the
+// streamsRetention option, up to the oldest job which is
+// pending or was not delivered yet.
+func (b *streamsBackend) trim(conn *redisConn, queue string) error {
+	info, err := b.groupInfo(conn, queue)
+	if err != nil || info == nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-time.Duration(cfg.streamsRetention))
+	minID := fmt.Sprintf("%d-0", cutoff.UnixNano()/int64(time.Millisecond))
+
+	// The last delivered job is kept too, which spares
+	// finding the one after it.
+	if last, _ := redis.String(info["last-delivered-id"], nil); streamIDLess(last, minID) {
+		minID = last
+	}
+	summary, err := redis.Values(conn.Do("XPENDING", b.streamKey(queue), streamsGroup))
+	if err != nil {
+		return err
+	}
+	if len(summary) > 1 && summary[1] != nil {
+		if oldest, _ := redis.String(summary[1], nil); streamIDLess(oldest, minID) {
+			minID = oldest
+		}
+	}
+
+	_, err = conn.Do("XTRIM", b.streamKey(queue), "MINID", "~", minID)
+	return err
+}
+
+// Returns whether the entry id a is before b.
+func streamIDLess(a, b string) bool {
+	parse := func(id string) (ms, seq uint64) {
+		parts := strings.SplitN(id, "-", 2)
+		ms, _ = strconv.ParseUint(parts[0], 10, 64)
+		if len(parts) > 1 {
+			seq, _ = strconv.ParseUint(parts[1], 10, 64)
+		}
+		return
+	}
+	aMs, aSeq := parse(a)
+	bMs, bSeq := parse(b)
+	return aMs < bMs || (aMs == bMs && aSeq < bSeq)
+}
+
+// Returns the fields of the group of the stream of queue,
+// or nil if the stream or the group do not exist.
+func (b *streamsBackend) groupInfo(conn *redisConn, queue string) (map[string]interface{}, error) {
+	groups, err := redis.Values(conn.Do("XINFO", "GROUPS", b.streamKey(queue)))
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, group := range groups {
+		fields, err := redis.Values(group, nil)
+		if err != nil {
+			return nil, err
+		}
+		info := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			name, _ := redis.String(fields[i], nil)
+			info[name] = fields[i+1]
+		}
+		if name, _ := redis.String(info["name"], nil); name == streamsGroup {
+			return info, nil
+		}
+	}
+	return nil, nil
+}
+
+// QueueSize returns the number of jobs not delivered to a
+// consumer yet.
+func (b *streamsBackend) QueueSize(queue string) (size int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		info, err := b.groupInfo(conn, queue)
+		if err != nil {
+			return err
+		}
+		// The lag is only known when the group counted the
+		// entries it read, which is not the case after
+		// entries were deleted from the middle of the
+		// stream. The jobs are counted then.
+		if info != nil && info["entries-read"] != nil {
+			if lag, err := redis.Int(info["lag"], nil); err == nil {
+				size = lag
+				return nil
+			}
+		}
+		jobs, err := b.undelivered(conn, info, queue, 0, 0)
+		size = len(jobs)
+		return err
+	})
+	return
+}
+
+// Peek returns the jobs not delivered to a consumer yet.
+func (b *streamsBackend) Peek(queue string, offset, limit int) (jobs [][]byte, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		info, err := b.groupInfo(conn, queue)
+		if err != nil {
+			return err
+		}
+		jobs, err = b.undelivered(conn, info, queue, offset, limit)
+		return err
+	})
+	return
+}
+
+// Returns up to limit jobs of the stream of queue from
+// offset, after the last one delivered to the group of
+// info.
+func (b *streamsBackend) undelivered(conn *redisConn, info map[string]interface{}, queue string, offset, limit int) ([][]byte, error) {
+	args := []interface{}{b.streamKey(queue), undeliveredStart(info), "+"}
+	if limit > 0 {
+		args = append(args, "COUNT", offset+limit)
+	}
+	entries, err := streamEntries(conn.Do("XRANGE", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs [][]byte
+	for i, entry := range entries {
+		if i >= offset {
+			jobs = append(jobs, entry.job)
+		}
+	}
+	return jobs, nil
+}
+
+// Returns the start of the XRANGE of the entries after the
+// last one delivered to the group of info.
+func undeliveredStart(info map[string]interface{}) string {
+	if info != nil {
+		if last, _ := redis.String(info["last-delivered-id"], nil); last != "" && last != "0-0" {
+			return "(" + last
+		}
+	}
+	return "-"
+}
+
+// ClearQueue deletes the jobs not delivered to a consumer
+// yet, leaving the pending ones to be acknowledged.
+func (b *streamsBackend) ClearQueue(queue string) (cleared int, err error) {
+	err = withConn(b.pool, func(conn *redisConn) error {
+		info, err := b.groupInfo(conn, queue)
+		if err != nil {
+			return err
+		}
+		entries, err := streamEntries(conn.Do("XRANGE", b.streamKey(queue), undeliveredStart(info), "+"))
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		args := []interface{}{b.streamKey(queue)}
+		for _, entry := range entries {
+			args = append(args, entry.id)
+		}
+		cleared, err = redis.Int(conn.Do("XDEL", args...))
+		return err
+	})
+	return
+}
+
+func (b *streamsBackend) RetryFailure(failure *Failure) error {
+	return withConn(b.pool, func(conn *redisConn) error {
+		return retryFailure(conn, failure, time.Now(), func(conn *redisConn, queue string, job []byte) {
+			conn.Send("XADD", b.streamKey(queue), "*", "job", job)
+		})
+	})
+}
+
+type streamEntry struct {
+	id  string
+	job []byte
+}
+
+// Returns the entries of a reply of XRANGE, XREADGROUP or
+// XAUTOCLAIM, skipping the entries deleted since they were
+// added.
+func streamEntries(reply interface{}, err error) ([]streamEntry, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []streamEntry
+	for _, value := range values {
+		entry, err := redis.Values(value, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry) < 2 || entry[1] == nil {
+			continue
+		}
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := redis.ByteSlices(entry[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if string(fields[i]) == "job" {
+				entries = append(entries, streamEntry{id: id, job: fields[i+1]})
+				break
+			}
+		}
+	}
+	return entries, nil
+}
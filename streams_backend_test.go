@@ -0,0 +1,209 @@
+package goworker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func streamsPending(t *testing.T, b *streamsBackend, queue string) int {
+	var pending int
+	err := withConn(b.pool, func(conn *redisConn) error {
+		reply, err := redis.Values(conn.Do("XPENDING", b.streamKey(queue), streamsGroup))
+		if err != nil {
+			return err
+		}
+		pending, err = redis.Int(reply[0], nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+func TestStreamsBackend(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	b := NewStreamsBackend(p).(*streamsBackend)
+	defer withConn(p, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", b.streamKey("test_streams"))
+		return err
+	})
+
+	for _, job := range []string{"1", "2", "3"} {
+		if err := b.Push("test_streams", []byte(job)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queue, job, err := b.Pop([]string{"test_streams_empty", "test_streams"})
+	if err != nil || queue != "test_streams" || string(job) != "1" {
+		t.Fatalf("expecting job 1 of test_streams, but got %s %q %v", queue, job, err)
+	}
+	if size, _ := b.QueueSize("test_streams"); size != 2 {
+		t.Errorf("expecting 2 jobs left, but got %d", size)
+	}
+	if jobs, _ := b.Peek("test_streams", 1, 1); len(jobs) != 1 || string(jobs[0]) != "3" {
+		t.Errorf("expecting to peek job 3, but got %q", jobs)
+	}
+
+	if err := b.Ack("test_streams", job); err != nil {
+		t.Fatal(err)
+	}
+	if pending := streamsPending(t, b, "test_streams"); pending != 0 {
+		t.Errorf("expecting no pending job after the ack, but got %d", pending)
+	}
+
+	// The job popped by a consumer which died is claimed by
+	// another one once idle.
+	_, job, _ = b.Pop([]string{"test_streams"})
+	if string(job) != "2" {
+		t.Fatalf("expecting job 2, but got %q", job)
+	}
+	other := NewStreamsBackend(p).(*streamsBackend)
+	other.consumer = "other"
+
+	_, job, _ = other.Pop([]string{"test_streams"})
+	if string(job) != "3" {
+		t.Errorf("expecting job 3 before job 2 is idle, but got %q", job)
+	}
+	other.Requeue("test_streams", job)
+
+	cfg.streamsClaimIdle = 0
+	defer Configure(map[string]string{"streamsClaimIdle": "300"})
+
+	_, job, _ = other.Pop([]string{"test_streams"})
+	if string(job) != "2" {
+		t.Errorf("expecting job 2 to be claimed, but got %q", job)
+	}
+	other.Ack("test_streams", job)
+	_, job, _ = other.Pop([]string{"test_streams"})
+	if string(job) != "3" {
+		t.Errorf("expecting the requeued job 3, but got %q", job)
+	}
+	other.Ack("test_streams", job)
+
+	if pending := streamsPending(t, b, "test_streams"); pending != 0 {
+		t.Errorf("expecting no pending job, but got %d", pending)
+	}
+}
+
+var streamIDLessTests = []struct {
+	a, b     string
+	expected bool
+}{
+	{"1-0", "2-0", true},
+	{"2-0", "1-5", false},
+	{"10-1", "10-2", true},
+	{"10-2", "10-2", false},
+	{"9-9", "10-0", true},
+}
+
+func TestStreamIDLess(t *testing.T) {
+	for _, tt := range streamIDLessTests {
+		if actual := streamIDLess(tt.a, tt.b); actual != tt.expected {
+			t.Errorf("Stream id: %s before %s expected %v, actual %v", tt.a, tt.b, tt.expected, actual)
+		}
+	}
+}
+
+func TestStreamsBackendTrim(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	b := NewStreamsBackend(p).(*streamsBackend)
+	defer withConn(p, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", b.streamKey("test_streams_trim"))
+		return err
+	})
+
+	for _, job := range []string{"1", "2", "3"} {
+		if err := b.Push("test_streams_trim", []byte(job)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, job, _ := b.Pop([]string{"test_streams_trim"})
+	b.Ack("test_streams_trim", job)
+	b.Pop([]string{"test_streams_trim"})
+
+	cfg.streamsRetention = intervalOption(time.Nanosecond)
+	defer Configure(map[string]string{"streamsRetention": "0"})
+	time.Sleep(2 * time.Millisecond)
+
+	err := withConn(p, func(conn *redisConn) error {
+		if err := b.trim(conn, "test_streams_trim"); err != nil {
+			return err
+		}
+		entries, err := streamEntries(conn.Do("XRANGE", b.streamKey("test_streams_trim"), "-", "+"))
+		if err != nil {
+			return err
+		}
+		// The pending job 2 and the new job 3 are kept.
+		if len(entries) < 2 || string(entries[len(entries)-2].job) != "2" || string(entries[len(entries)-1].job) != "3" {
+			t.Errorf("expecting jobs 2 and 3 to be kept, but got %v", entries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Pops the job of queue with a poller which stops before a
+// worker takes the job, so that the poller requeues it.
+func stopPollerWithJob(t *testing.T, b Backend, queue string) {
+	p, err := newPoller([]string{queue}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	p.poll(b, time.Millisecond, quit)
+
+	deadline := time.Now().Add(time.Second)
+	for size, _ := b.QueueSize(queue); size > 0; size, _ = b.QueueSize(queue) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting the poller to pop the job of %s", queue)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(quit)
+	<-p.stopped
+}
+
+func TestStreamsBackendRequeuesOnStop(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	b := NewStreamsBackend(p).(*streamsBackend)
+	defer withConn(p, func(conn *redisConn) error {
+		_, err := conn.Do("DEL", b.streamKey("test_streams_stop"))
+		return err
+	})
+
+	// The keys are not in the order of payload, as in the
+	// jobs of RetryFailure.
+	raw := `{"args":[1],"class":"TestStreamsStop"}`
+	b.Push("test_streams_stop", []byte(raw))
+	stopPollerWithJob(t, b, "test_streams_stop")
+
+	if pending := streamsPending(t, b, "test_streams_stop"); pending != 0 {
+		t.Errorf("expecting the popped entry to be acknowledged, but got %d pending", pending)
+	}
+	if jobs, _ := b.Peek("test_streams_stop", 0, 0); len(jobs) != 1 || string(jobs[0]) != raw {
+		t.Errorf("expecting the job requeued as popped, but got %q", jobs)
+	}
+}
+
+func TestUseStreamsBackendSetsPool(t *testing.T) {
+	previousBackend, previousPool := currentBackend, pool
+	defer func() {
+		currentBackend, pool = previousBackend, previousPool
+	}()
+
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	useBackend(NewStreamsBackend(p))
+	if pool != p {
+		t.Error("expecting the pool of the streams backend to save the failures")
+	}
+}
@@ -0,0 +1,194 @@
+package goworker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer connects goworker to a tracing SDK. The trace
+// context of the enqueuing code is stored in the trace
+// field of the payload, which Resque ignores, and the span
+// of the job is linked to it. The carrier has the layout of
+// the TextMap carriers of OpenTelemetry, so that their
+// propagators can be used directly.
+type Tracer interface {
+	// Inject writes the trace context of ctx to carrier
+	// when a job is enqueued with EnqueueContext.
+	Inject(ctx context.Context, carrier map[string]string)
+
+	// StartJobSpan starts the consumer span of a job,
+	// linked to the trace context in carrier, which is
+	// empty for jobs enqueued without one.
+	StartJobSpan(carrier map[string]string, name string, attributes []Field) Span
+}
+
+// Span is the span of a job started by a Tracer.
+type Span interface {
+	SetAttributes(attributes []Field)
+	End(err error)
+}
+
+// SetTracer sets the tracer of enqueued and performed
+// jobs. Jobs are not traced by default.
+func SetTracer(tracer Tracer) {
+	cfg.tracer = tracer
+}
+
+// Returns the trace field of the payload of a job enqueued
+// with ctx.
+func injectTrace(ctx context.Context) map[string]string {
+	if cfg.tracer == nil {
+		return nil
+	}
+	carrier := make(map[string]string)
+	cfg.tracer.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+func startJobSpan(job *job) Span {
+	if cfg.tracer == nil {
+		return nil
+	}
+	carrier := job.Payload.Trace
+	if carrier == nil {
+		carrier = make(map[string]string)
+	}
+	attributes := []Field{
+		{"goworker.class", job.Payload.Class},
+		{"goworker.queue", job.Queue},
+		{"goworker.attempt", job.Payload.attempt()},
+	}
+	if job.Payload.id() != "" {
+		attributes = append(attributes, Field{"goworker.job_id", job.Payload.id()})
+	}
+	return cfg.tracer.StartJobSpan(carrier, job.Payload.Class, attributes)
+}
+
+func endJobSpan(span Span, err error) {
+	if span == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	span.SetAttributes([]Field{{"goworker.outcome", outcome}})
+	span.End(err)
+}
+
+// InMemoryTracer is a Tracer propagating W3C traceparent
+// headers and keeping the spans it starts in memory, to
+// test tracing without an SDK.
+type InMemoryTracer struct {
+	mu    sync.Mutex
+	spans []*InMemorySpan
+}
+
+// InMemorySpan is a span recorded by InMemoryTracer.
+type InMemorySpan struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Attributes   []Field
+	Err          error
+	StartTime    time.Time
+	EndTime      time.Time
+
+	tracer *InMemoryTracer
+}
+
+type inMemorySpanKey struct{}
+
+func NewInMemoryTracer() *InMemoryTracer {
+	return &InMemoryTracer{}
+}
+
+// StartSpan starts a span, the child of the span of ctx if
+// any, and returns a context holding it. It stands for the
+// span of the code enqueuing jobs.
+func (t *InMemoryTracer) StartSpan(ctx context.Context, name string) (context.Context, *InMemorySpan) {
+	span := t.start(name, nil)
+	if parent, ok := ctx.Value(inMemorySpanKey{}).(*InMemorySpan); ok {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	}
+	return context.WithValue(ctx, inMemorySpanKey{}, span), span
+}
+
+func (t *InMemoryTracer) Inject(ctx context.Context, carrier map[string]string) {
+	if span, ok := ctx.Value(inMemorySpanKey{}).(*InMemorySpan); ok {
+		carrier["traceparent"] = fmt.Sprintf("00-%s-%s-01", span.TraceID, span.SpanID)
+	}
+}
+
+func (t *InMemoryTracer) StartJobSpan(carrier map[string]string, name string, attributes []Field) Span {
+	span := t.start(name, attributes)
+	// traceparent is version-traceid-parentid-flags.
+	if parts := strings.Split(carrier["traceparent"], "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		span.TraceID = parts[1]
+		span.ParentSpanID = parts[2]
+	}
+	return span
+}
+
+func (t *InMemoryTracer) start(name string, attributes []Field) *InMemorySpan {
+	span := &InMemorySpan{
+		TraceID:    randomHex(16),
+		SpanID:     randomHex(8),
+		Name:       name,
+		Attributes: attributes,
+		StartTime:  time.Now(),
+		tracer:     t,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, span)
+	return span
+}
+
+// Spans returns the spans started so far, in order.
+func (t *InMemoryTracer) Spans() []*InMemorySpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*InMemorySpan(nil), t.spans...)
+}
+
+func (s *InMemorySpan) SetAttributes(attributes []Field) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Attributes = append(s.Attributes, attributes...)
+}
+
+func (s *InMemorySpan) End(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Err = err
+	s.EndTime = time.Now()
+}
+
+// Attribute returns the value of the attribute key, or nil.
+func (s *InMemorySpan) Attribute(key string) interface{} {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for i := len(s.Attributes) - 1; i >= 0; i-- {
+		if s.Attributes[i].Key == key {
+			return s.Attributes[i].Value
+		}
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,89 @@
+package goworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestTracingAcrossEnqueueAndRun(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+
+	ctx, parent := tracer.StartSpan(context.Background(), "request")
+	if err := EnqueueWithPoolContext(ctx, p, "test_tracing", "TestTracing", []interface{}{1}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	resource, _ := p.Get()
+	conn := resource.(*redisConn)
+	data, err := redis.Bytes(conn.Do("LPOP", fmt.Sprintf("%squeue:test_tracing", cfg.namespace)))
+	p.Put(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job job
+	job.Queue = "test_tracing"
+	if err := json.Unmarshal(data, &job.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if job.Payload.Trace["traceparent"] == "" {
+		t.Fatalf("expecting a traceparent in the payload, got %s", data)
+	}
+
+	w, err := newWorker("1", []string{"test_tracing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.run(NewRedisBackend(p), &job, func(queue string, args ...interface{}) error {
+		return errors.New("boom")
+	})
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expecting 2 spans, but got %d", len(spans))
+	}
+	span := spans[1]
+	if span.TraceID != parent.TraceID || span.ParentSpanID != parent.SpanID {
+		t.Errorf("expecting the job span to be a child of %s/%s, got %s/%s", parent.TraceID, parent.SpanID, span.TraceID, span.ParentSpanID)
+	}
+	for key, expected := range map[string]interface{}{
+		"goworker.class":   "TestTracing",
+		"goworker.queue":   "test_tracing",
+		"goworker.attempt": 1,
+		"goworker.outcome": "failure",
+	} {
+		if actual := span.Attribute(key); actual != expected {
+			t.Errorf("expecting attribute %s to be %v, but got %v", key, expected, actual)
+		}
+	}
+	if span.Err == nil || span.EndTime.IsZero() {
+		t.Errorf("expecting the job span to end with an error, got %v", span.Err)
+	}
+}
+
+func TestStartJobSpanWithoutTraceContext(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	span := startJobSpan(&job{Queue: "test_tracing", Payload: payload{Class: "TestTracing", Attempt: 2}})
+	endJobSpan(span, nil)
+
+	recorded := tracer.Spans()[0]
+	if recorded.ParentSpanID != "" {
+		t.Errorf("expecting a root span, got parent %s", recorded.ParentSpanID)
+	}
+	if recorded.Attribute("goworker.attempt") != 3 || recorded.Attribute("goworker.outcome") != "success" {
+		t.Errorf("expecting attempt 3 and outcome success, got %v", recorded.Attributes)
+	}
+}
@@ -5,13 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
 )
 
 type worker struct {
 	process
+
+	// quit stops the retries of the bookkeeping writes
+	// when goworker is stopping.
+	quit <-chan struct{}
+
+	// stop is closed to remove the worker, which exits
+	// once its current job is finished.
+	stop chan struct{}
 }
 
 func newWorker(id string, queues []string) (*worker, error) {
@@ -21,6 +28,7 @@ func newWorker(id string, queues []string) (*worker, error) {
 	}
 	return &worker{
 		process: *process,
+		stop:    make(chan struct{}),
 	}, nil
 }
 
@@ -28,7 +36,25 @@ func (w *worker) MarshalJSON() ([]byte, error) {
 	return json.Marshal(w.String())
 }
 
-func (w *worker) start(conn *redisConn, job *job) error {
+// Returns the log entry of job, with its queue and class.
+func (w *worker) jobLog(job *job) entry {
+	return withJobID(w.log().With("queue", job.Queue).With("class", job.Payload.Class), job)
+}
+
+// Returns the log entry of job for the messages which name
+// its queue and class already.
+func (w *worker) jobIDLog(job *job) entry {
+	return withJobID(w.log(), job)
+}
+
+func withJobID(e entry, job *job) entry {
+	if job.Payload.id() != "" {
+		return e.With("job_id", job.Payload.id())
+	}
+	return e
+}
+
+func (w *worker) start(b Backend, job *job) error {
 	work := &work{
 		Queue:   job.Queue,
 		RunAt:   time.Now(),
@@ -40,118 +66,135 @@ func (w *worker) start(conn *redisConn, job *job) error {
 		return err
 	}
 
-	conn.Send("SET", fmt.Sprintf("%sworker:%s", cfg.namespace, w), buffer)
-	logger.Debugf("Processing %s since %s [%v]", work.Queue, work.RunAt, work.Payload.Class)
+	w.jobIDLog(job).Debugf("Processing %s since %s [%v]", work.Queue, work.RunAt, work.Payload.Class)
 
-	return w.process.start(conn)
+	return b.StartWork(w.String(), buffer)
 }
 
-func (w *worker) fail(conn *redisConn, job *job, err error) error {
-	failure := &failure{
+// Saves the failure of job to the failure backend, and
+// returns whether it was saved.
+func (w *worker) fail(job *job, err error) bool {
+	failure := &Failure{
 		FailedAt:  time.Now(),
-		Payload:   job.Payload,
-		Exception: "Error",
-		Error:     err.Error(),
-		Worker:    w,
 		Queue:     job.Queue,
+		Class:     job.Payload.Class,
+		Args:      job.Payload.Args,
+		Exception: exceptionName(err),
+		Error:     err.Error(),
+		Backtrace: backtrace(err),
+		Worker:    w.String(),
+		job:       job.raw,
 	}
-	buffer, err := json.Marshal(failure)
-	if err != nil {
-		return err
+	if err := cfg.failureBackend.Save(failure); err != nil {
+		w.jobLog(job).Errorf("Error on saving failure of %v: %v", w, err)
+		return false
 	}
-	conn.Send("RPUSH", fmt.Sprintf("%sfailed", cfg.namespace), buffer)
-
-	return w.process.fail(conn)
-}
-
-func (w *worker) succeed(conn *redisConn, job *job) error {
-	conn.Send("INCR", fmt.Sprintf("%sstat:processed", cfg.namespace))
-	conn.Send("INCR", fmt.Sprintf("%sstat:processed:%s", cfg.namespace, w))
-
-	return nil
+	return true
 }
 
-func (w *worker) finish(conn *redisConn, job *job, err error) error {
+// Counts job as processed, or as failed after err.
+func (w *worker) count(b Backend, err error) error {
 	if err != nil {
-		w.fail(conn, job, err)
-	} else {
-		w.succeed(conn, job)
+		return w.process.fail(b)
 	}
-	return w.process.finish(conn)
+	return b.IncrStats("processed", "processed:"+w.String())
 }
 
-func (w *worker) work(pool *pools.ResourcePool, jobs <-chan *job, monitor *sync.WaitGroup) {
-	resource, err := pool.Get()
-	if err != nil {
-		logger.Criticalf("Error on getting connection in worker %v", w)
-	} else {
-		conn := resource.(*redisConn)
-		w.open(conn)
-		pool.Put(conn)
+func (w *worker) work(b Backend, jobs <-chan *job, monitor *sync.WaitGroup, quit <-chan struct{}) {
+	w.quit = quit
+
+	if err := retry(quit, func() error {
+		return w.open(b)
+	}); err != nil {
+		w.log().Criticalf("Error on opening worker %v: %v", w, err)
 	}
 
 	monitor.Add(1)
+	atomic.AddInt64(&workersTotal, 1)
+	setWorkerStatus(w.String(), nil)
 
 	go func() {
 		defer func() {
-			resource, err := pool.Get()
-			if err != nil {
-				logger.Criticalf("Error on getting connection in worker %v", w)
-			} else {
-				conn := resource.(*redisConn)
-				w.close(conn)
-				pool.Put(conn)
+			atomic.AddInt64(&workersTotal, -1)
+			removeWorkerStatus(w.String())
+
+			if err := w.close(b); err != nil {
+				w.log().Errorf("Error on closing worker %v: %v", w, err)
 			}
 
 			monitor.Done()
 		}()
-		for job := range jobs {
-			if workerFunc, ok := workers[job.Payload.Class]; ok {
-				w.run(pool, job, workerFunc)
-
-				logger.Debugf("done: (Job{%s} | %s | %v)", job.Queue, job.Payload.Class, job.Payload.Args)
-			} else {
-				errorLog := fmt.Sprintf("No worker for %s in queue %s with args %v", job.Payload.Class, job.Queue, job.Payload.Args)
-				logger.Critical(errorLog)
-
-				resource, err := pool.Get()
-				if err != nil {
-					logger.Criticalf("Error on getting connection in worker %v", w)
-				} else {
-					conn := resource.(*redisConn)
-					w.finish(conn, job, errors.New(errorLog))
-					pool.Put(conn)
+		for {
+			select {
+			case <-w.stop:
+				return
+			case job, ok := <-jobs:
+				if !ok {
+					return
 				}
+				w.handle(b, job)
 			}
 		}
 	}()
 }
 
-func (w *worker) run(pool *pools.ResourcePool, job *job, workerFunc workerFunc) {
-	var err error
-	defer func() {
-		resource, poolErr := pool.Get()
-		if poolErr != nil {
-			logger.Criticalf("Error on getting connection in worker %v", w)
-		} else {
-			conn := resource.(*redisConn)
-			w.finish(conn, job, err)
-			pool.Put(conn)
-		}
-	}()
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.New(fmt.Sprint(r))
-		}
-	}()
+func (w *worker) handle(b Backend, job *job) {
+	defer job.slots.release()
+	defer job.claim.release()
 
-	resource, err := pool.Get()
-	if err != nil {
-		logger.Criticalf("Error on getting connection in worker %v", w)
+	if workerFunc, ok := workers[job.Payload.Class]; ok {
+		start := time.Now()
+		w.run(b, job, workerFunc)
+
+		w.jobIDLog(job).With("duration", time.Since(start)).Debugf("done: (Job{%s} | %s | %v)", job.Queue, job.Payload.Class, job.Payload.Args)
 	} else {
-		conn := resource.(*redisConn)
-		w.start(conn, job)
-		pool.Put(conn)
+		errorLog := fmt.Sprintf("No worker for %s in queue %s with args %v", job.Payload.Class, job.Queue, job.Payload.Args)
+		w.jobIDLog(job).Critical(errorLog)
+
+		err := errors.New(errorLog)
+		saved := w.fail(job, err)
+		jobsFailed.inc(job.Queue, job.Payload.Class)
+		jobErrors.Add(job.Payload.Class, 1)
+		w.finishWithRetry(b, job, err, saved)
+	}
+}
+
+func (w *worker) run(b Backend, job *job, workerFunc workerFunc) {
+	setWorkerStatus(w.String(), &work{Queue: job.Queue, RunAt: time.Now(), Payload: job.Payload})
+
+	if err := retry(w.quit, func() error {
+		return w.start(b, job)
+	}); err != nil {
+		w.jobLog(job).Errorf("Error on starting job in worker %v: %v", w, err)
+	}
+	err := runJob(job, workerFunc)
+
+	setWorkerStatus(w.String(), nil)
+	ack := true
+	if err != nil {
+		ack = w.fail(job, err)
+	}
+	w.finishWithRetry(b, job, err, ack)
+}
+
+// Records the end of job, and acknowledges it with the
+// backends which keep the jobs popped if ack is set, as
+// when it succeeded or its failure was saved. Each step is
+// retried on its own while the backend cannot be reached,
+// so that the stats are counted once.
+func (w *worker) finishWithRetry(b Backend, job *job, err error, ack bool) {
+	steps := []func() error{
+		func() error { return w.count(b, err) },
+		func() error { return w.process.finish(b) },
+	}
+	if acknowledger, ok := b.(Acknowledger); ok && ack && job.raw != nil {
+		steps = append(steps, func() error {
+			return acknowledger.Ack(job.Queue, job.raw)
+		})
+	}
+	for _, step := range steps {
+		if err := retry(w.quit, step); err != nil {
+			w.jobLog(job).Errorf("Error on finishing job in worker %v: %v", w, err)
+		}
 	}
-	err = workerFunc(job.Queue, job.Payload.Args...)
 }
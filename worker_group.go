@@ -0,0 +1,312 @@
+package goworker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yudppp/goworker/_vendor/vitess/go/pools"
+)
+
+var (
+	errorInvalidConcurrency  = errors.New("The concurrency must be at least 1.")
+	errorNoRemoteConcurrency = errors.New("The backend can not change the concurrency of processes.")
+)
+
+// How often Work reads the concurrency set with
+// SetRemoteConcurrency.
+const remoteConcurrencyRefresh = 5 * time.Second
+
+var (
+	currentWorkersMutex sync.Mutex
+
+	// currentWorkers are the workers of Work, nil unless
+	// it runs.
+	currentWorkers *workerGroup
+
+	// reloadFunc is called on a HUP signal.
+	reloadFunc func() (int, error)
+)
+
+// SetConcurrency sets the number of workers. While Work
+// runs, workers are started at once, and registered like
+// the others, or stopped once they finish their current
+// job, and the Redis pool is resized within the
+// maxConnections option.
+func SetConcurrency(concurrency int) error {
+	currentWorkersMutex.Lock()
+	g := currentWorkers
+	currentWorkersMutex.Unlock()
+
+	return setConcurrency(g, concurrency)
+}
+
+// Sets the number of workers of g, or the concurrency
+// option if Work does not run.
+func setConcurrency(g *workerGroup, concurrency int) error {
+	if concurrency < 1 {
+		return errorInvalidConcurrency
+	}
+
+	if g == nil {
+		currentWorkersMutex.Lock()
+		cfg.concurrency = concurrency
+		currentWorkersMutex.Unlock()
+		return nil
+	}
+	return g.resize(concurrency)
+}
+
+// Returns the number of workers of Work while it runs, or
+// else the concurrency option.
+func currentConcurrency() int {
+	currentWorkersMutex.Lock()
+	defer currentWorkersMutex.Unlock()
+
+	if currentWorkers == nil {
+		return cfg.concurrency
+	}
+	return int(atomic.LoadInt64(&currentWorkers.current))
+}
+
+// SetRemoteConcurrency sets the concurrency of the process
+// named hostname:pid, as in the names of its workers, or
+// of every process if process is empty, through the
+// backend. Running processes read it within a few seconds,
+// the concurrency of their own process first. A
+// concurrency of zero removes it, leaving the processes
+// with their current concurrency.
+func SetRemoteConcurrency(process string, concurrency int) error {
+	if concurrency < 0 {
+		return errorInvalidConcurrency
+	}
+	return withBackend(func(b Backend) error {
+		commander, ok := b.(ConcurrencyCommander)
+		if !ok {
+			return errorNoRemoteConcurrency
+		}
+		return commander.SetRemoteConcurrency(process, concurrency)
+	})
+}
+
+// SetReloadFunc sets the function called by Work on a HUP
+// signal, which returns the concurrency to set, as read
+// from a configuration file. Without it, a HUP signal sets
+// the concurrency of SetRemoteConcurrency again, if any.
+func SetReloadFunc(fn func() (concurrency int, err error)) {
+	currentWorkersMutex.Lock()
+	defer currentWorkersMutex.Unlock()
+
+	reloadFunc = fn
+}
+
+// Returns the maximum capacity of the Redis pool.
+func maxConnections() int {
+	if cfg.maxConnections > cfg.connections {
+		return cfg.maxConnections
+	}
+	return cfg.connections
+}
+
+// workerGroup runs the workers of Work, whose number can
+// change while they run.
+type workerGroup struct {
+	mutex sync.Mutex
+
+	b    Backend
+	jobs <-chan *job
+	quit <-chan struct{}
+
+	// monitor counts the running workers, and one more
+	// until the poller stopped, after which no worker is
+	// started and closed is set.
+	monitor sync.WaitGroup
+	closed  bool
+
+	workers []*worker
+	nextID  int
+
+	// current is the number of workers, read without the
+	// mutex, and poolMutex orders the resizes of the pool.
+	current   int64
+	poolMutex sync.Mutex
+
+	// connections and concurrency are the options at
+	// start, from which the Redis pool is resized.
+	connections int
+	concurrency int
+
+	// process is the hostname:pid of SetRemoteConcurrency,
+	// and remote the last concurrency read for it.
+	process string
+	remote  int
+}
+
+func newWorkerGroup(b Backend, jobs <-chan *job, stopped, quit <-chan struct{}) (*workerGroup, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	g := &workerGroup{
+		b:           b,
+		jobs:        jobs,
+		quit:        quit,
+		connections: cfg.connections,
+		concurrency: cfg.concurrency,
+		current:     int64(cfg.concurrency),
+		process:     fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+	}
+
+	g.monitor.Add(1)
+	go func() {
+		<-stopped
+		g.mutex.Lock()
+		g.closed = true
+		g.mutex.Unlock()
+		g.monitor.Done()
+	}()
+	return g, nil
+}
+
+// Starts or stops workers until concurrency run, and
+// resizes the Redis pool. The workers added last are
+// stopped first.
+func (g *workerGroup) resize(concurrency int) error {
+	resized, err := g.resizeWorkers(concurrency)
+	if err != nil || !resized {
+		return err
+	}
+
+	// Shrinking the pool waits for the connections in use,
+	// so it is resized out of the mutex, to the number of
+	// workers of the last resize.
+	g.poolMutex.Lock()
+	defer g.poolMutex.Unlock()
+
+	g.resizePool(int(atomic.LoadInt64(&g.current)))
+	return nil
+}
+
+// Starts or stops workers until concurrency run, and
+// returns whether they did, unless the group stopped.
+func (g *workerGroup) resizeWorkers(concurrency int) (bool, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.closed {
+		return false, nil
+	}
+	select {
+	case <-g.quit:
+		return false, nil
+	default:
+	}
+	defer func() {
+		atomic.StoreInt64(&g.current, int64(len(g.workers)))
+	}()
+
+	if len(g.workers) > 0 && concurrency != len(g.workers) {
+		logger.Infof("Setting concurrency from %d to %d", len(g.workers), concurrency)
+	}
+	for len(g.workers) < concurrency {
+		worker, err := newWorker(strconv.Itoa(g.nextID), cfg.queues)
+		if err != nil {
+			return false, err
+		}
+		g.nextID++
+		worker.work(g.b, g.jobs, &g.monitor, g.quit)
+		g.workers = append(g.workers, worker)
+	}
+	for len(g.workers) > concurrency {
+		last := len(g.workers) - 1
+		close(g.workers[last].stop)
+		g.workers = g.workers[:last]
+	}
+	return true, nil
+}
+
+// Resizes the Redis pool of the backend in proportion to
+// concurrency. Shrinking it waits for the connections in
+// use to be returned.
+func (g *workerGroup) resizePool(concurrency int) {
+	backend, ok := g.b.(interface {
+		resourcePool() *pools.ResourcePool
+	})
+	if !ok || g.concurrency <= 0 {
+		return
+	}
+	pool := backend.resourcePool()
+
+	capacity := (g.connections*concurrency + g.concurrency - 1) / g.concurrency
+	_, _, maxCapacity, _, _, _ := pool.Stats()
+	if capacity > int(maxCapacity) {
+		capacity = int(maxCapacity)
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	if err := pool.SetCapacity(capacity); err != nil {
+		logger.Errorf("Error on resizing the Redis pool to %d: %v", capacity, err)
+	}
+}
+
+// Sets the concurrency of SetRemoteConcurrency and of HUP
+// signals, received on reload, until done is closed.
+func (g *workerGroup) watch(reload <-chan struct{}, done <-chan struct{}) {
+	ticker := time.NewTicker(remoteConcurrencyRefresh)
+	defer ticker.Stop()
+
+	g.readRemote(false)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-g.quit:
+			return
+		case <-ticker.C:
+			g.readRemote(false)
+		case <-reload:
+			currentWorkersMutex.Lock()
+			fn := reloadFunc
+			currentWorkersMutex.Unlock()
+
+			if fn == nil {
+				g.readRemote(true)
+				continue
+			}
+			concurrency, err := fn()
+			if err == nil {
+				err = setConcurrency(g, concurrency)
+			}
+			if err != nil {
+				logger.Errorf("Error on reloading the concurrency: %v", err)
+			}
+		}
+	}
+}
+
+// Sets the concurrency of SetRemoteConcurrency when it
+// changed since it was last read, or if force is set.
+func (g *workerGroup) readRemote(force bool) {
+	commander, ok := g.b.(ConcurrencyCommander)
+	if !ok {
+		return
+	}
+	concurrency, err := commander.RemoteConcurrency(g.process)
+	if err != nil {
+		logger.Errorf("Error on reading the remote concurrency: %v", err)
+		return
+	}
+	if concurrency <= 0 || (concurrency == g.remote && !force) {
+		return
+	}
+	g.remote = concurrency
+	if err := setConcurrency(g, concurrency); err != nil {
+		logger.Errorf("Error on setting the remote concurrency: %v", err)
+	}
+}
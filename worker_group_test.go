@@ -0,0 +1,185 @@
+package goworker
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Waits for the workers registered on b to be count.
+func waitForWorkers(t *testing.T, b Backend, count int) []string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		workers, err := b.Workers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(workers) == count || time.Now().After(deadline) {
+			return workers
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkerGroupResize(t *testing.T) {
+	defer Configure(map[string]string{"concurrency": "10"})
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	Register("TestWorkerGroup", func(queue string, args ...interface{}) error {
+		started <- struct{}{}
+		<-finish
+		return nil
+	})
+
+	b := NewMemoryBackend()
+	jobs, stopped, quit := make(chan *job), make(chan struct{}), make(chan struct{})
+	g, err := newWorkerGroup(b, jobs, stopped, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.resize(2); err != nil {
+		t.Fatal(err)
+	}
+	if workers := waitForWorkers(t, b, 2); len(workers) != 2 {
+		t.Fatalf("expecting 2 registered workers, but got %q", workers)
+	}
+
+	// Both workers are busy, and the one removed finishes
+	// its job before unregistering.
+	for i := 0; i < 2; i++ {
+		jobs <- &job{Queue: "test_group", Payload: payload{Class: "TestWorkerGroup"}}
+		<-started
+	}
+	if err := setConcurrency(g, 1); err != nil {
+		t.Fatal(err)
+	}
+	if workers, _ := b.Workers(); len(workers) != 2 {
+		t.Errorf("expecting the removed worker to run its job, but got %q", workers)
+	}
+	close(finish)
+	workers := waitForWorkers(t, b, 1)
+	if len(workers) != 1 || !strings.Contains(workers[0], "-0:") {
+		t.Errorf("expecting the first worker to be left, but got %q", workers)
+	}
+	if current := atomic.LoadInt64(&g.current); current != 1 {
+		t.Errorf("expecting the concurrency to be 1, but got %d", current)
+	}
+
+	if err := setConcurrency(g, 0); err != errorInvalidConcurrency {
+		t.Errorf("expecting %v, but got %v", errorInvalidConcurrency, err)
+	}
+	if err := g.resize(3); err != nil {
+		t.Fatal(err)
+	}
+	if workers := waitForWorkers(t, b, 3); len(workers) != 3 || !strings.Contains(strings.Join(workers, " "), "-3:") {
+		t.Errorf("expecting 3 workers with new names, but got %q", workers)
+	}
+
+	close(jobs)
+	close(stopped)
+	g.monitor.Wait()
+	g.resize(5)
+	if workers, _ := b.Workers(); len(workers) != 0 {
+		t.Errorf("expecting no worker once the poller stopped, but got %q", workers)
+	}
+}
+
+func TestWorkerGroupResizePool(t *testing.T) {
+	Configure(map[string]string{"concurrency": "4", "connections": "2"})
+	defer Configure(map[string]string{"concurrency": "10"})
+
+	b := NewRedisBackend(newRedisPool(cfg.uri, 2, 8, time.Minute)).(*redisBackend)
+	defer b.pool.Close()
+	g, err := newWorkerGroup(b, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ concurrency, capacity int }{
+		{8, 4},
+		{6, 3},
+		{100, 8},
+		{1, 1},
+		{4, 2},
+	} {
+		g.resizePool(tt.concurrency)
+		if capacity, _, _, _, _, _ := b.pool.Stats(); capacity != int64(tt.capacity) {
+			t.Errorf("ResizePool(%d): expected %d connections, actual %d", tt.concurrency, tt.capacity, capacity)
+		}
+	}
+}
+
+func TestWorkerGroupRemoteConcurrency(t *testing.T) {
+	defer Configure(map[string]string{"concurrency": "10"})
+	defer SetReloadFunc(nil)
+
+	b := NewMemoryBackend()
+	jobs, stopped, quit := make(chan *job), make(chan struct{}), make(chan struct{})
+	defer close(jobs)
+	defer close(quit)
+	g, err := newWorkerGroup(b, jobs, stopped, quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.resize(1)
+
+	commander := b.(ConcurrencyCommander)
+	commander.SetRemoteConcurrency("", 3)
+	commander.SetRemoteConcurrency("other:1", 5)
+	g.readRemote(false)
+	if len(g.workers) != 3 {
+		t.Errorf("expecting the concurrency of every process, but got %d workers", len(g.workers))
+	}
+
+	commander.SetRemoteConcurrency(g.process, 2)
+	g.readRemote(false)
+	if len(g.workers) != 2 {
+		t.Errorf("expecting the concurrency of the process, but got %d workers", len(g.workers))
+	}
+
+	// A HUP signal sets the concurrency again, and the
+	// reload function takes precedence.
+	SetConcurrency(4)
+	g.resize(4)
+	g.readRemote(false)
+	if len(g.workers) != 4 {
+		t.Errorf("expecting the unchanged remote concurrency to be ignored, but got %d workers", len(g.workers))
+	}
+	reload, done := make(chan struct{}), make(chan struct{})
+	go g.watch(reload, done)
+	defer close(done)
+
+	reload <- struct{}{}
+	waitForWorkers(t, b, 2)
+	SetReloadFunc(func() (int, error) {
+		return 1, nil
+	})
+	reload <- struct{}{}
+	if workers := waitForWorkers(t, b, 1); len(workers) != 1 {
+		t.Errorf("expecting the concurrency of the reload function, but got %q", workers)
+	}
+}
+
+func TestRedisRemoteConcurrency(t *testing.T) {
+	p := newRedisPool(cfg.uri, 1, 1, time.Minute)
+	defer p.Close()
+	b := NewRedisBackend(p).(ConcurrencyCommander)
+
+	b.SetRemoteConcurrency("", 0)
+	b.SetRemoteConcurrency("host:1", 0)
+	if concurrency, err := b.RemoteConcurrency("host:1"); err != nil || concurrency != 0 {
+		t.Errorf("expecting no concurrency, but got %d %v", concurrency, err)
+	}
+	b.SetRemoteConcurrency("", 4)
+	if concurrency, _ := b.RemoteConcurrency("host:1"); concurrency != 4 {
+		t.Errorf("expecting the concurrency of every process, but got %d", concurrency)
+	}
+	b.SetRemoteConcurrency("host:1", 2)
+	if concurrency, _ := b.RemoteConcurrency("host:1"); concurrency != 2 {
+		t.Errorf("expecting the concurrency of the process, but got %d", concurrency)
+	}
+	b.SetRemoteConcurrency("", 0)
+	b.SetRemoteConcurrency("host:1", 0)
+}